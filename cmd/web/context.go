@@ -2,4 +2,12 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const (
+	isAuthenticatedContextKey = contextKey("isAuthenticated")
+	isEmailVerifiedContextKey = contextKey("isEmailVerified")
+	userRoleContextKey        = contextKey("userRole")
+	clientIPContextKey        = contextKey("clientIP")
+	requestIDContextKey       = contextKey("requestID")
+
+	passwordResetRequiredContextKey = contextKey("passwordResetRequired")
+)
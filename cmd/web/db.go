@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// dbPool is the part of *sql.DB used for readiness checks and connection
+// pool statistics, so that tests can stand in for the database.
+type dbPool interface {
+	PingContext(ctx context.Context) error
+	Stats() sql.DBStats
+}
+
+// The delay before the first retry when the database can't be reached at
+// startup, which doubles after each failed attempt up to the maximum.
+const (
+	dbRetryMinDelay = 500 * time.Millisecond
+	dbRetryMaxDelay = 10 * time.Second
+)
+
+// waitForDB pings the database until it responds, waiting minDelay after
+// the first failed attempt and twice as long after each one after that, up
+// to maxDelay. Each failed attempt is logged.
+//
+// Error Handling:
+//   - The database responds with an error (for example, because the
+//     password is wrong): that error, without retrying, as retrying wouldn't
+//     help
+//   - ctx is done (for example, the connect timeout has passed): an error
+//     wrapping the last ping error
+func waitForDB(ctx context.Context, db dbPool, minDelay, maxDelay time.Duration, logger *slog.Logger) error {
+	delay := minDelay
+
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) {
+			return err
+		}
+
+		if ctx.Err() == nil {
+			logger.Warn("database not reachable; retrying", "attempt", attempt, "delay", delay.String(), "error", err.Error())
+
+			select {
+			case <-time.After(delay):
+				delay = min(delay*2, maxDelay)
+				continue
+			case <-ctx.Done():
+			}
+		}
+
+		return fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+	}
+}
+
+// logDBStats logs the connection pool's statistics every interval until ctx
+// is cancelled. The counts of waits and closed connections are totals since
+// the pool was opened.
+func logDBStats(ctx context.Context, db dbPool, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := db.Stats()
+			logger.Info("database pool stats",
+				"max_open", s.MaxOpenConnections,
+				"open", s.OpenConnections,
+				"in_use", s.InUse,
+				"idle", s.Idle,
+				"wait_count", s.WaitCount,
+				"wait_duration", s.WaitDuration.String(),
+				"max_idle_closed", s.MaxIdleClosed,
+				"max_idle_time_closed", s.MaxIdleTimeClosed,
+				"max_lifetime_closed", s.MaxLifetimeClosed,
+			)
+		}
+	}
+}
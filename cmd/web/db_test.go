@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestWaitForDB(t *testing.T) {
+	refused := errors.New("dial tcp 127.0.0.1:3306: connection refused")
+
+	tests := []struct {
+		name      string
+		db        *stubDB
+		timeout   time.Duration
+		wantPings int
+		wantError string
+	}{
+		{
+			name:      "Reachable",
+			db:        &stubDB{},
+			timeout:   time.Second,
+			wantPings: 1,
+		},
+		{
+			name:      "Reachable after retries",
+			db:        &stubDB{err: refused, failures: 3},
+			timeout:   time.Second,
+			wantPings: 4,
+		},
+		{
+			name:      "Access denied",
+			db:        &stubDB{err: &mysql.MySQLError{Number: 1045, Message: "Access denied for user 'web'"}},
+			timeout:   time.Second,
+			wantPings: 1,
+			wantError: "Access denied",
+		},
+		{
+			name:      "Unreachable",
+			db:        &stubDB{err: refused},
+			timeout:   50 * time.Millisecond,
+			wantError: "database not reachable after",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := newLogger(&buf, "text")
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			err := waitForDB(ctx, tt.db, time.Millisecond, 4*time.Millisecond, logger)
+			if tt.wantError == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.StringContains(t, err.Error(), tt.wantError)
+			}
+
+			if tt.wantPings > 0 {
+				assert.Equal(t, tt.db.pings, tt.wantPings)
+			}
+
+			// Each failed attempt is logged, except one which isn't retried.
+			if tt.wantPings > 1 {
+				assert.Equal(t, strings.Count(buf.String(), "database not reachable; retrying"), tt.wantPings-1)
+			}
+		})
+	}
+
+	t.Run("Backs off", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := newLogger(&buf, "text")
+
+		db := &stubDB{err: refused, failures: 4}
+		err := waitForDB(context.Background(), db, time.Millisecond, 4*time.Millisecond, logger)
+		assert.NilError(t, err)
+
+		for _, delay := range []string{"delay=1ms", "delay=2ms", "delay=4ms"} {
+			assert.StringContains(t, buf.String(), delay)
+		}
+		assert.Equal(t, strings.Count(buf.String(), "delay=4ms"), 2)
+	})
+}
+
+func TestLogDBStats(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		logDBStats(ctx, &stubDB{}, 10*time.Millisecond, logger)
+		close(done)
+	}()
+
+	time.Sleep(35 * time.Millisecond)
+	cancel()
+	<-done
+
+	lines := logLines(t, &buf)
+	if len(lines) == 0 {
+		t.Fatal("no stats logged")
+	}
+
+	line := lines[0]
+	assert.Equal(t, line["msg"], any("database pool stats"))
+	assert.Equal(t, line["max_open"], any(float64(25)))
+	assert.Equal(t, line["open"], any(float64(3)))
+	assert.Equal(t, line["in_use"], any(float64(1)))
+	assert.Equal(t, line["idle"], any(float64(2)))
+	assert.Equal(t, line["wait_count"], any(float64(7)))
+	assert.Equal(t, line["wait_duration"], any("1.5s"))
+	assert.Equal(t, line["max_lifetime_closed"], any(float64(6)))
+}
@@ -18,13 +18,40 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"rsc.io/qr"
 	"snippetbox.tomcat.net/internal/models"
+	"snippetbox.tomcat.net/internal/oidc"
+	"snippetbox.tomcat.net/internal/scanner"
+	"snippetbox.tomcat.net/internal/tokens"
+	"snippetbox.tomcat.net/internal/totp"
 	"snippetbox.tomcat.net/internal/validator"
+	"snippetbox.tomcat.net/internal/webauthn"
+)
+
+const (
+	// emailVerificationPurpose is mixed into the signature of email
+	// verification tokens so they cannot be reused for anything else.
+	emailVerificationPurpose = "email-verification"
+
+	// emailVerificationTTL is how long an email verification link is valid.
+	emailVerificationTTL = 48 * time.Hour
+
+	// twoFactorLoginTTL is how long a user has to enter their two-factor code
+	// after entering their password.
+	twoFactorLoginTTL = 5 * time.Minute
+
+	// twoFactorIssuer is the name authenticator apps show next to codes.
+	twoFactorIssuer = "Snippetbox"
 )
 
 // snippetCreateForm represents the data structure for the snippet creation form
@@ -45,6 +72,10 @@ import (
 //     Validation rules:
 //   - Required: Must not be empty.
 //   - Must be 1, 7, or 365.
+//   - IgnoreWarnings: bool - Save the snippet despite the content scanner's
+//     warnings (form:"ignore_warnings")
+//   - ScanWarning: bool - Set (form:"-") when the content scanner has warned
+//     about the content, to show the IgnoreWarnings checkbox
 //   - Validator: validator.Validator - Embedded validator (form:"-")
 //     Purpose: Manages validation errors.
 //
@@ -55,6 +86,8 @@ type snippetCreateForm struct {
 	Title               string `form:"title"`
 	Content             string `form:"content"`
 	Expires             int    `form:"expires"`
+	IgnoreWarnings      bool   `form:"ignore_warnings"`
+	ScanWarning         bool   `form:"-"`
 	validator.Validator `form:"-"`
 }
 
@@ -93,10 +126,16 @@ type userSignupForm struct {
 //   - Password: string - User's password (form:"password")
 //     Validation rules:
 //   - Required: Must not be empty.
+//   - RememberMe: bool - Whether to keep the user logged in after the
+//     browser is closed (form:"remember_me")
+//   - LockedFor: string - Set (form:"-") when too many failed attempts have
+//     been made, to how long until the user can try again (e.g. "2 minutes")
 //   - Validator: validator.Validator - Embedded validator for form validation (form:"-")
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	RememberMe          bool   `form:"remember_me"`
+	LockedFor           string `form:"-"`
 	validator.Validator `form:"-"`
 }
 
@@ -123,6 +162,99 @@ type accountPasswordUpdateForm struct {
 	validator.Validator     `form:"-"`
 }
 
+// twoFactorForm represents the form used to submit a two-factor
+// authentication code, when logging in, confirming enrolment or disabling
+// two-factor authentication.
+//
+// Fields:
+//   - Code: string - A code from an authenticator app, or a recovery code
+//     (form:"code")
+//     Validation rules:
+//   - Required: Must not be blank
+//   - Validator: validator.Validator - Embedded validator for error management (form:"-")
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// sessionRevokeForm represents the form used to sign out one of the user's
+// other sessions from the active sessions page.
+//
+// Fields:
+//   - ID: int - The ID of the session to sign out (form:"id")
+type sessionRevokeForm struct {
+	ID int `form:"id"`
+}
+
+// passkeyDeleteForm represents the form used to remove one of the user's
+// passkeys from the passkeys page.
+//
+// Fields:
+//   - ID: int - The ID of the passkey to remove (form:"id")
+type passkeyDeleteForm struct {
+	ID int `form:"id"`
+}
+
+// adminUserForm represents the forms used by administrators to act on a
+// user's account.
+//
+// Fields:
+//   - ID: int - The ID of the user (form:"id")
+//   - Disabled: bool - Whether to disable or re-enable the account, for the
+//     disable form (form:"disabled")
+type adminUserForm struct {
+	ID       int  `form:"id"`
+	Disabled bool `form:"disabled"`
+}
+
+// adminSnippetForm represents the form used by administrators to delete a
+// snippet.
+//
+// Fields:
+//   - ID: int - The ID of the snippet (form:"id")
+type adminSnippetForm struct {
+	ID int `form:"id"`
+}
+
+// snippetReportForm represents the form on the snippet view page for
+// reporting an abusive snippet.
+//
+// Fields:
+//   - ID: int - The ID of the snippet (form:"id")
+//   - Reason: string - Why the snippet is being reported (form:"reason")
+//   - Validator: validator.Validator - Embedded validator for error management (form:"-")
+type snippetReportForm struct {
+	ID                  int    `form:"id"`
+	Reason              string `form:"reason"`
+	validator.Validator `form:"-"`
+}
+
+// moderationForm represents the forms used by moderators to dismiss the
+// reports for a snippet or take it down.
+//
+// Fields:
+//   - ID: int - The ID of the snippet (form:"id")
+//   - Reason: string - Why the snippet is being taken down, shown to its
+//     author; required for the takedown form (form:"reason")
+//   - Validator: validator.Validator - Embedded validator for error management (form:"-")
+type moderationForm struct {
+	ID                  int    `form:"id"`
+	Reason              string `form:"reason"`
+	validator.Validator `form:"-"`
+}
+
+// passkeyRegisterRequest is the JSON body posted by the passkeys page to
+// finish registering a passkey.
+//
+// Fields:
+//   - Name: string - A label for the passkey, e.g. "Laptop"
+//   - Credential: webauthn.AttestationResponse - The new credential, as
+//     returned by navigator.credentials.create()
+type passkeyRegisterRequest struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
 // home handles GET requests to the root URL (/).
 //
 // It fetches the latest 5 snippets from the database and renders the home page
@@ -143,7 +275,7 @@ type accountPasswordUpdateForm struct {
 //   - Template errors: 500 Internal Server Error.
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	// Get the latest 5 snippets from the database.
-	snippets, err := app.snippets.Latest() // Fetch latest 5 snippets from database.
+	snippets, err := app.snippets.Latest(r.Context()) // Fetch latest 5 snippets from database.
 	if err != nil {
 		// If there's an error fetching snippets, return a 500 Internal Server Error.
 		app.serverError(w, r, err)
@@ -194,7 +326,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the snippet record from the database using the provided id
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippets.Get(r.Context(), id)
 	if err != nil {
 		// If the snippet is not found, return 404 Not Found
 		if errors.Is(err, models.ErrNoRecord) {
@@ -209,11 +341,99 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	// Create a new template data structure and set the snippet
 	data := app.newTemplateData(r)
 	data.Snippet = snippet // Add snippet to template data
+	data.Form = snippetReportForm{ID: snippet.ID}
+	data.CanReport = app.canReport(r, snippet)
 
 	// Render the "view.html" template with the provided data
 	app.render(w, r, http.StatusOK, "view.html", data)
 }
 
+// snippetReportPost handles POST requests from the form on the snippet view
+// page to report an abusive snippet to the moderators.
+//
+// Each user can have one open report for a snippet at a time; once a
+// moderator has resolved it, they can report the snippet again. When
+// app.reportThreshold different users have open reports for a snippet it is
+// hidden until a moderator reviews it (a threshold of zero disables this).
+//
+// Flow:
+// 1. Decode the form and look up the snippet
+// 2. Validate the reason, re-rendering the view page if it's invalid
+// 3. Record the report, hiding the snippet if it has reached the threshold
+// 4. Redirect to the home page with a flash message
+//
+// Error Handling:
+// - Invalid form data, or the user's own snippet: 400 Bad Request
+// - Unknown or hidden snippet: 404 Not Found
+// - Validation errors: 422 Unprocessable Entity
+// - Database errors: 500 Internal Server Error
+func (app *application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetReportForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !app.canReport(r, snippet) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Reason), "reason", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Reason, 500), "reason", "This field cannot be more than 500 characters long")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		data.CanReport = true
+		app.render(w, r, http.StatusUnprocessableEntity, "view.html", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	target := fmt.Sprintf("snippet:%d", snippet.ID)
+
+	reporters, err := app.reports.Insert(r.Context(), snippet.ID, userID, form.Reason)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateReport) {
+			app.sessionManager.Put(r.Context(), "flash", "You have already reported this snippet")
+			http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, userID, models.AuditSnippetReport, target)
+
+	if app.reportThreshold > 0 && reporters >= app.reportThreshold {
+		err = app.reports.Hide(r.Context(), snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// The snippet is hidden automatically, not by the reporter.
+		app.audit(r, 0, models.AuditSnippetHide, target)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Thanks for your report. A moderator will review the snippet.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 // snippetCreate handles GET requests to display the snippet creation form.
 // It:
 // - Initializes template data with a default expiration of 365 days.
@@ -292,6 +512,20 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
 
+	// Check the content for leaked secrets and spam. Blocking findings
+	// prevent the snippet from being saved; warnings can be overridden by
+	// ticking the "save anyway" box.
+	if form.Valid() {
+		findings := app.scanner.Scan(form.Content)
+
+		if scanner.Blocked(findings) {
+			form.AddFieldError("content", "This snippet can't be saved because "+scanner.Summary(findings, scanner.Block))
+		} else if len(findings) > 0 && !form.IgnoreWarnings {
+			form.AddFieldError("content", "Check this snippet before saving it: "+scanner.Summary(findings, scanner.Warn))
+			form.ScanWarning = true
+		}
+	}
+
 	if !form.Valid() { // Check if form validation failed.
 		data := app.newTemplateData(r)
 		data.Form = form
@@ -299,14 +533,19 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Insert the new snippet into the database.
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// Insert the new snippet into the database, recording its author.
+	id, err := app.snippets.Insert(r.Context(), userID, form.Title, form.Content, form.Expires)
 	if err != nil {
 		// Return 500 Internal Server Error if database insertion fails.
 		app.serverError(w, r, err)
 		return
 	}
 
+	app.audit(r, userID, models.AuditSnippetCreate, fmt.Sprintf("snippet:%d", id))
+	app.metrics.snippetsCreated.Inc()
+
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created") // Flash message on success.
 
 	// Redirect to show the new snippet.
@@ -353,8 +592,9 @@ func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 // 3. If validation fails, re-render form with error messages
 // 4. Attempt to create new user in database
 // 5. Handle potential duplicate email addresses
-// 6. Set success flash message
-// 7. Redirect to login page
+// 6. Send an email verification link (failures are logged, not fatal)
+// 7. Set success flash message
+// 8. Redirect to login page
 //
 // Error Handling:
 //   - Invalid form data: 400 Bad Request
@@ -393,7 +633,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Attempt to create a new user record in the database
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	err = app.users.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			// If email already exists, add an error message and re-render the form
@@ -409,13 +649,113 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Send the verification link in the background, so that a slow mail
+	// server doesn't hold up the response. The account already exists at this
+	// point, so if sending fails we log the error and let the user request a
+	// new link from their account page rather than failing the signup.
+	//
+	// The request may be over by the time sending fails, so its details are
+	// captured for the log line now, and the error is logged with a
+	// background context rather than the request's.
+	logger := app.logger.With("request_id", requestIDFromContext(r.Context()), "method", r.Method, "uri", r.URL.RequestURI())
+	app.background(func() {
+		err := app.sendVerificationEmail(form.Name, form.Email)
+		if err != nil {
+			logger.ErrorContext(context.Background(), err.Error())
+		}
+	})
+
+	app.audit(r, 0, models.AuditSignup, form.Email)
+
 	// Add a success flash message to be displayed on the login page
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please check your email to verify your address, then log in.")
 
 	// Redirect to the login page with status 303 See Other
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
+// userVerify handles GET requests to the email verification link which is
+// sent to users after they sign up.
+//
+// Query Parameters:
+//   - token: string - The signed token from the verification email
+//
+// Flow:
+// 1. Verify the token's signature and expiry
+// 2. Mark the email address carried by the token as verified
+// 3. Set a flash message and redirect to the account page (or the login page
+// if the user isn't logged in, which requireAuthentication would do anyway)
+//
+// Error Handling:
+//   - Invalid or expired token: flash message and redirect to /user/login
+//   - Unknown email address: flash message and redirect to /user/login
+//   - Database errors: 500 Internal Server Error
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	email, err := app.tokens.Verify(emailVerificationPurpose, r.URL.Query().Get("token"), time.Now())
+	if err == nil {
+		err = app.users.VerifyEmail(r.Context(), email)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, tokens.ErrExpiredToken):
+			app.sessionManager.Put(r.Context(), "flash", "That verification link has expired. Log in to request a new one.")
+		case errors.Is(err, tokens.ErrInvalidToken), errors.Is(err, models.ErrNoRecord):
+			app.sessionManager.Put(r.Context(), "flash", "That verification link is invalid.")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified")
+
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userVerifyResendPost handles POST requests to send a fresh email
+// verification link to the authenticated user.
+//
+// Flow:
+// 1. Retrieve the authenticated user
+// 2. If already verified, just say so
+// 3. Otherwise send a new verification email
+// 4. Redirect back to the account page with a flash message
+//
+// Error Handling:
+//   - Database or mailer errors: 500 Internal Server Error
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	err = app.sendVerificationEmail(user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "We've sent a new verification link to "+user.Email)
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
 // userLogin handles GET requests to display the user login form.
 //
 // It initializes template data and renders the login form template.
@@ -456,20 +796,30 @@ func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 // 3. If validation fails:
 //   - Re-render login form with error messages (HTTP 422)
 //
-// 4. Authenticate user credentials against database
-// 5. If authentication fails:
+// 4. Check the login throttle for the account and client IP address:
+//   - If locked, re-render login form with a lockout message (HTTP 429)
+//
+// 5. Authenticate user credentials against database
+// 6. If authentication fails:
+//   - Record the failure with the login throttle
 //   - Re-render login form with generic error message (HTTP 422)
 //
-// 6. If authentication succeeds:
+// 7. If the user has two-factor authentication enabled (see startLogin):
+//   - Renew session token and remember the user ID as pending
+//   - Redirect to /user/login/2fa for the second step
+//
+// 8. Otherwise complete the login (see completeLogin):
 //   - Renew session token for security
 //   - Store authenticated user ID in session
+//   - Clear the failures recorded by the login throttle
 //   - Redirect to either:
 //   - Original requested path (if available)
-//   - Home page (default)
+//   - Account page (default)
 //
 // Error Handling:
 // - Invalid form data: HTTP 400 Bad Request
 // - Validation errors: HTTP 422 Unprocessable Entity
+// - Too many failed attempts: HTTP 429 Too Many Requests
 // - Authentication errors: HTTP 422 Unprocessable Entity
 // - Session errors: HTTP 500 Internal Server Error
 func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -492,9 +842,34 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	// Refuse to check the password at all while the account or IP address
+	// is locked out, so that guesses made during a lockout are wasted.
+	ip := clientIP(r)
+
+	wait, err := app.loginThrottle.Check(form.Email, ip)
+	if err != nil {
+		if errors.Is(err, models.ErrAccountLocked) {
+			app.audit(r, 0, models.AuditLoginLocked, form.Email)
+
+			form.LockedFor = humanDuration(wait)
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusTooManyRequests, "login.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+
+		return
+	}
+
+	id, err := app.users.Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.loginThrottle.Failed(form.Email, ip)
+			app.audit(r, 0, models.AuditLoginFailed, form.Email)
+			app.metrics.failedLogins.WithLabelValues(loginStepPassword).Inc()
+
 			form.AddNonFieldError("Email or password is incorrect")
 
 			data := app.newTemplateData(r)
@@ -507,31 +882,115 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.sessionManager.RenewToken(r.Context())
+	// Earlier failures aren't forgotten until the login is complete (see
+	// completeLogin), as otherwise knowing the password would allow
+	// unlimited guesses at the second factor.
+	app.startLogin(w, r, id, form.RememberMe)
+}
+
+// userLoginTwoFactor handles GET requests to display the second login step
+// for users with two-factor authentication enabled.
+//
+// Flow:
+// 1. Check that the user has completed the password step
+// 2. Render "twofactor_login.html" template
+//
+// Returns:
+//   - No password step in session: 303 See Other redirect to /user/login
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.sessionManager.GetInt(r.Context(), "twoFactorUserID") == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	app.render(w, r, http.StatusOK, "twofactor_login.html", data)
+}
+
+// userLoginTwoFactorPost handles POST requests for the second login step. It
+// accepts either a code from the user's authenticator app or one of their
+// recovery codes.
+//
+// Flow:
+// 1. Check that the password step was completed within twoFactorLoginTTL
+// 2. Validate the code field
+// 3. Check the login throttle, as codes can be guessed like passwords
+// 4. Check the code as a TOTP code, then as a recovery code
+// 5. On success, clear the pending state and complete the login
+//
+// Error Handling:
+//   - No (or expired) password step: flash message and redirect to /user/login
+//   - Invalid form data: 400 Bad Request
+//   - Too many failed attempts: 429 Too Many Requests
+//   - Invalid code: 422 Unprocessable Entity
+//   - Database errors: 500 Internal Server Error
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+	started := time.Unix(app.sessionManager.GetInt64(r.Context(), "twoFactorStarted"), 0)
+
+	if id == 0 || time.Since(started) > twoFactorLoginTTL {
+		app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+		app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+		app.sessionManager.Remove(r.Context(), "twoFactorRememberMe")
+		app.sessionManager.Put(r.Context(), "flash", "Your login attempt has expired. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	user, err := app.users.Get(r.Context(), id)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	ip := clientIP(r)
+	status := http.StatusUnprocessableEntity
+
+	if form.Valid() {
+		var wait time.Duration
+
+		wait, err = app.loginThrottle.Check(user.Email, ip)
+		if err == nil {
+			err = app.checkTwoFactorCode(r.Context(), id, form.Code)
+		}
 
-	// Use PopString to retrieve the path and remove it from the session atomically.
-	// It returns the string value and a bool indicating if the key existed and held a string.
-	path := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+		switch {
+		case errors.Is(err, models.ErrAccountLocked):
+			form.AddFieldError("code", "Too many failed attempts. Please try again in "+humanDuration(wait)+".")
+			status = http.StatusTooManyRequests
+		case errors.Is(err, models.ErrInvalidCredentials):
+			app.loginThrottle.Failed(user.Email, ip)
+			app.metrics.failedLogins.WithLabelValues(loginStepTwoFactor).Inc()
+			form.AddFieldError("code", "Invalid authentication code")
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
 
-	// Check if the value existed in the session AND was a string.
-	// The check for path != "" might be slightly redundant if PopString guarantees
-	// ok=true only for non-empty strings it finds, but it's safe to include.
-	if path != "" {
-		// Value existed and was a non-empty string.
-		// No need to call Remove() here, PopString already did it.
-		http.Redirect(w, r, path, http.StatusSeeOther)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, status, "twofactor_login.html", data)
 		return
 	}
 
-	// If the path wasn't in the session, or wasn't a string, or was empty,
-	// redirect to the default page (e.g., account view)
-	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+	rememberMe := app.sessionManager.PopBool(r.Context(), "twoFactorRememberMe")
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+
+	app.completeLogin(w, r, id, rememberMe)
 }
 
 // userLogoutPost handles POST requests to logout the current user.
@@ -541,7 +1000,9 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 //   - By renewing the session token upon logout, we ensure that the session ID used during the logged-in state is invalidated.
 //   - This prevents an attacker from using the old session ID to gain unauthorized access after the user logs out.
 //
-// - Removes the 'authenticatedUserID' from the session
+// - Removes the 'authenticatedUserID' from the session, and turns off
+// "remember me" so the anonymous session isn't persisted
+// - Deletes the session's metadata, so it no longer appears on the sessions page
 // - Sets a flash message indicating successful logout
 // - Redirects the user to the home page ('/')
 //
@@ -550,22 +1011,36 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 //   - r: *http.Request - Contains the incoming HTTP request
 //
 // Flow:
-// 1. Renew the session token
-// 2. Remove 'authenticatedUserID' from the session
-// 3. Set a flash message
-// 4. Redirect to the home page ('/')
+// 1. Delete the metadata recorded against the current session token
+// 2. Renew the session token
+// 3. Remove 'authenticatedUserID' from the session
+// 4. Set a flash message
+// 5. Redirect to the home page ('/')
 //
 // Error Handling:
 // - Session errors during token renewal or user ID removal: 500 Internal Server Error
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// Forget the session's metadata before the token changes.
+	err := app.userSessions.Delete(r.Context(), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, userID, models.AuditLogout, fmt.Sprintf("user:%d", userID))
+
 	// Logout the user.
-	err := app.sessionManager.RenewToken(r.Context())
+	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
 	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "lastActivity")
+	app.sessionManager.RememberMe(r.Context(), false)
 
 	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
 
@@ -613,6 +1088,21 @@ func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// redirectToHTTPS handles every request to the plain HTTP listener, by
+// permanently redirecting it to the same path and query on the
+// application's public HTTPS URL.
+//
+// The target is built from baseURL rather than the request's Host header,
+// so that requests for other host names (such as the server's IP address)
+// end up on the name its certificate is valid for.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	// Tell clients not to keep the connection open, as they'll be making
+	// their next request to the HTTPS listener.
+	w.Header().Set("Connection", "close")
+
+	http.Redirect(w, r, app.baseURL+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
 // accountView handles GET requests to display the authenticated user's account information.
 // It performs the following operations:
 // 1. Retrieves the authenticated user's ID from the session
@@ -643,7 +1133,7 @@ func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
 
 	// Retrieve the user's details from the database
-	user, err := app.users.Get(userID)
+	user, err := app.users.Get(r.Context(), userID)
 	if err != nil {
 		// Handle case where user is not found
 		if errors.Is(err, models.ErrNoRecord) {
@@ -655,9 +1145,26 @@ func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Look up whether the user has two-factor authentication enabled
+	enabled, err := app.twoFactor.Enabled(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Look up any of the user's snippets which moderators have taken down,
+	// so that they can see why
+	takenDown, err := app.snippets.TakenDown(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// Prepare template data and add the user's information
 	data := app.newTemplateData(r)
 	data.User = user
+	data.TwoFactor.Enabled = enabled
+	data.Snippets = takenDown
 
 	// Render the account page template
 	app.render(w, r, http.StatusOK, "account.html", data)
@@ -700,7 +1207,9 @@ func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Req
 // 4. If validation fails, re-renders form with error messages
 // 5. Attempts to update password in database
 // 6. Handles incorrect current password case
-// 7. Sets success flash message and redirects to account view on success
+// 7. Signs out the user's other sessions, in case the password was changed
+// because someone else knew it
+// 8. Sets success flash message and redirects to account view on success
 //
 // Parameters:
 //   - w: http.ResponseWriter - Used to write the HTTP response
@@ -743,7 +1252,7 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 	}
 
 	// Attempt to update password in database
-	err = app.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	err = app.users.PasswordUpdate(r.Context(), userID, form.CurrentPassword, form.NewPassword)
 	if err != nil {
 		// Handle incorrect current password case
 		if errors.Is(err, models.ErrInvalidCredentials) {
@@ -759,7 +1268,1023 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 		return
 	}
 
+	app.audit(r, userID, models.AuditPasswordChange, fmt.Sprintf("user:%d", userID))
+
+	// Sign out everywhere else, so that anyone who knew the old password
+	// loses access straight away
+	_, err = app.revokeOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// Set success flash message and redirect to account view
 	app.sessionManager.Put(r.Context(), "flash", "Password updated successfully")
 	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
+
+// accountTwoFactor handles GET requests to the two-factor authentication
+// settings page. If two-factor authentication is off, this starts enrolment
+// and shows the QR code and secret to add to an authenticator app, along with
+// a form to confirm it. If it's on, it shows a form to turn it off.
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{})
+}
+
+// accountTwoFactorPost handles POST requests to confirm two-factor enrolment.
+//
+// Flow:
+// 1. Decode and validate the code
+// 2. Confirm enrolment, which checks the code and generates recovery codes
+// 3. Render the recovery codes (they are only ever shown once)
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Wrong code: 422 Unprocessable Entity with form errors
+//   - Enrolment not started: 303 See Other redirect to /account/2fa
+//   - Database errors: 500 Internal Server Error
+func (app *application) accountTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	codes, err := app.twoFactor.Confirm(r.Context(), userID, form.Code, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("code", "Invalid authentication code")
+			app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form)
+		case errors.Is(err, models.ErrNoRecord):
+			http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Flash = "Two-factor authentication is now enabled"
+	data.TwoFactor = twoFactorData{Enabled: true, RecoveryCodes: codes}
+	data.Form = twoFactorForm{}
+	app.render(w, r, http.StatusOK, "twofactor.html", data)
+}
+
+// accountTwoFactorQR handles GET requests for the QR code image shown during
+// two-factor enrolment. The image encodes the otpauth:// provisioning URI.
+//
+// Error Handling:
+//   - Two-factor authentication already enabled: 404 Not Found
+//   - Database or encoding errors: 500 Internal Server Error
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	secret, err := app.twoFactor.Begin(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, models.ErrTwoFactorEnabled) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	code, err := qr.Encode(totp.Default.URI(twoFactorIssuer, user.Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	code.Scale = 4
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
+}
+
+// accountTwoFactorDisablePost handles POST requests to turn off two-factor
+// authentication. A current code (or a recovery code) is required so that
+// someone with brief access to a logged-in browser can't remove it.
+//
+// Codes are checked against the same login throttle as the second login
+// step, so that someone holding a stolen session can't guess codes here
+// instead.
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Too many failed attempts: 429 Too Many Requests
+//   - Wrong code: 422 Unprocessable Entity with form errors
+//   - Database errors: 500 Internal Server Error
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	ip := clientIP(r)
+	status := http.StatusUnprocessableEntity
+
+	if form.Valid() {
+		var wait time.Duration
+
+		wait, err = app.loginThrottle.Check(user.Email, ip)
+		if err == nil {
+			err = app.checkTwoFactorCode(r.Context(), userID, form.Code)
+		}
+
+		switch {
+		case errors.Is(err, models.ErrAccountLocked):
+			form.AddFieldError("code", "Too many failed attempts. Please try again in "+humanDuration(wait)+".")
+			status = http.StatusTooManyRequests
+		case errors.Is(err, models.ErrInvalidCredentials):
+			app.loginThrottle.Failed(user.Email, ip)
+			form.AddFieldError("code", "Invalid authentication code")
+		case errors.Is(err, models.ErrNoRecord):
+			form.AddFieldError("code", "Invalid authentication code")
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, status, form)
+		return
+	}
+
+	app.loginThrottle.Succeeded(user.Email, ip)
+
+	err = app.twoFactor.Disable(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been turned off")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// accountSessions handles GET requests to the active sessions page, which
+// lists everywhere the user is logged in so they can sign out of sessions
+// they don't recognise.
+//
+// Sessions which have expired in the session store are pruned from the list
+// (and their metadata deleted) as it is built, since nothing else removes
+// them.
+//
+// Error Handling:
+//   - Database or session store errors: 500 Internal Server Error
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	currentToken := app.sessionManager.Token(r.Context())
+
+	sessions, err := app.userSessions.List(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+
+	for _, s := range sessions {
+		_, found, err := app.sessionManager.Store.Find(s.Token)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// The current session may not have been saved yet if this is the
+		// first request since logging in, so it's always kept.
+		if !found && s.Token != currentToken {
+			err = app.userSessions.Delete(r.Context(), s.Token)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			continue
+		}
+
+		if s.Token == currentToken {
+			data.Sessions.CurrentID = s.ID
+		}
+		data.Sessions.List = append(data.Sessions.List, s)
+	}
+
+	app.render(w, r, http.StatusOK, "sessions.html", data)
+}
+
+// accountSessionRevokePost handles POST requests to sign out one of the
+// user's other sessions. Users sign out of the current session with the
+// normal logout button instead.
+//
+// Error Handling:
+//   - Invalid form data, or the current session's ID: 400 Bad Request
+//   - Unknown session, or one belonging to someone else: 404 Not Found
+//   - Database or session store errors: 500 Internal Server Error
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	var form sessionRevokeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	session, err := app.userSessions.Get(r.Context(), userID, form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if session.Token == app.sessionManager.Token(r.Context()) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.revokeSession(r.Context(), session.Token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The session has been signed out")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// accountSessionRevokeOthersPost handles POST requests to sign out of every
+// session except the current one.
+//
+// Error Handling:
+//   - Database or session store errors: 500 Internal Server Error
+func (app *application) accountSessionRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	n, err := app.revokeOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	flash := "You've been signed out everywhere else"
+	if n == 0 {
+		flash = "There were no other sessions to sign out"
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// userLoginOIDC handles GET requests to start logging in with the single
+// sign-on identity provider. It stores a random state, nonce and PKCE code
+// verifier in the session and redirects the user to the provider.
+//
+// Error Handling:
+//   - Single sign-on not configured: 404 Not Found
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	http.Redirect(w, r, app.sso.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+}
+
+// userLoginOIDCCallback handles the redirect back from the identity provider
+// after the user has logged in there. It:
+// 1. Checks the state matches the one stored by userLoginOIDC
+// 2. Exchanges the authorization code for an ID token and verifies it
+// 3. Finds the user linked to the identity. If there isn't one, and the
+// provider has verified the user's email address, it links the identity to
+// the user with that address, or creates a new user if there is none
+// 4. Logs the user in (see startLogin)
+//
+// Error Handling:
+//   - Single sign-on not configured: 404 Not Found
+//   - Missing or mismatched state: 400 Bad Request
+//   - Login refused by the provider, or an invalid ID token: redirect to
+//     the login page with a flash message
+//   - Database errors: 500 Internal Server Error
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	q := r.URL.Query()
+
+	if state == "" || q.Get("state") != state {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	loginFailed := func(message string) {
+		app.sessionManager.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+
+	if q.Get("error") != "" {
+		loginFailed("Single sign-on login was cancelled or refused")
+		return
+	}
+
+	claims, err := app.sso.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrExchange) || errors.Is(err, oidc.ErrInvalidIDToken) {
+			app.logger.WarnContext(r.Context(), "single sign-on login failed", "error", err.Error())
+			loginFailed("Single sign-on login failed. Please try again.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	issuer := app.sso.Issuer()
+
+	id, err := app.identities.Find(r.Context(), issuer, claims.Subject)
+	if errors.Is(err, models.ErrNoRecord) {
+		if claims.Email == "" || !claims.EmailVerified {
+			loginFailed("Your identity provider hasn't confirmed your email address, so you can't log in with it.")
+			return
+		}
+
+		id, err = app.identities.LinkByEmail(r.Context(), issuer, claims.Subject, claims.Email)
+		if errors.Is(err, models.ErrNoRecord) {
+			name := claims.Name
+			if name == "" {
+				name = claims.Email
+			}
+
+			id, err = app.identities.CreateUser(r.Context(), issuer, claims.Subject, name, claims.Email)
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, models.ErrUnverifiedEmail) {
+			loginFailed("An account with your email address already exists but its email address hasn't been verified. " +
+				"Log in with your password and verify your email address first.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.startLogin(w, r, id, false)
+}
+
+// accountPasskeys handles GET requests to the passkeys page, which lists the
+// user's passkeys and lets them register new ones.
+//
+// Error Handling:
+//   - Database errors: 500 Internal Server Error
+func (app *application) accountPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	passkeys, err := app.passkeys.List(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Passkeys = passkeys
+
+	app.render(w, r, http.StatusOK, "passkeys.html", data)
+}
+
+// accountPasskeyRegisterBeginPost handles POST requests to start registering
+// a passkey. It stores a new challenge in the session and responds with the
+// options for navigator.credentials.create() as JSON. The user's existing
+// passkeys are excluded, so the same authenticator isn't registered twice.
+//
+// Error Handling:
+//   - Database errors: 500 Internal Server Error
+func (app *application) accountPasskeyRegisterBeginPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	passkeys, err := app.passkeys.List(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var exclude [][]byte
+	for _, p := range passkeys {
+		exclude = append(exclude, p.CredentialID)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "passkeyRegisterChallenge", challenge)
+
+	options := app.webauthn.CreationOptions(challenge, passkeyUserHandle(userID), user.Email, user.Name, exclude)
+	app.writeJSON(w, r, http.StatusOK, map[string]any{"publicKey": options})
+}
+
+// accountPasskeyRegisterFinishPost handles POST requests to finish
+// registering a passkey, with the credential created by the browser. The
+// challenge stored by accountPasskeyRegisterBeginPost can only be used once.
+//
+// Whatever the outcome, the user is redirected back to the passkeys page with
+// a flash message. The page's script follows the redirect.
+//
+// Error Handling:
+//   - Malformed JSON, or no registration in progress: 400 Bad Request
+//   - Database errors: 500 Internal Server Error
+func (app *application) accountPasskeyRegisterFinishPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	challenge := app.sessionManager.PopBytes(r.Context(), "passkeyRegisterChallenge")
+
+	var req passkeyRegisterRequest
+
+	err := app.readJSON(w, r, &req)
+	if err != nil || challenge == nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	done := func(message string) {
+		app.sessionManager.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+	}
+
+	cred, err := app.webauthn.VerifyRegistration(challenge, req.Credential.ClientDataJSON, req.Credential.AttestationObject)
+	if err == nil && !bytes.Equal(cred.ID, req.Credential.ID) {
+		err = webauthn.ErrInvalidResponse
+	}
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "passkey registration failed", "error", err.Error())
+		done("Your passkey couldn't be registered. Please try again.")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	err = app.passkeys.Insert(r.Context(), userID, name, cred.ID, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateCredential) {
+			done("That passkey is already registered")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	done("Your passkey has been registered")
+}
+
+// accountPasskeyDeletePost handles POST requests to remove one of the user's
+// passkeys.
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Unknown passkey, or one belonging to someone else: 404 Not Found
+//   - Database errors: 500 Internal Server Error
+func (app *application) accountPasskeyDeletePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	var form passkeyDeleteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.passkeys.Delete(r.Context(), userID, form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your passkey has been removed")
+	http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+}
+
+// userLoginPasskeyBeginPost handles POST requests to start logging in with a
+// passkey. It stores a new challenge in the session and responds with the
+// options for navigator.credentials.get() as JSON.
+func (app *application) userLoginPasskeyBeginPost(w http.ResponseWriter, r *http.Request) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "passkeyLoginChallenge", challenge)
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{"publicKey": app.webauthn.RequestOptions(challenge)})
+}
+
+// userLoginPasskeyFinishPost handles POST requests to finish logging in with
+// a passkey. It:
+// 1. Finds the passkey with the credential ID the browser sent
+// 2. Verifies the signature over the challenge stored by
+// userLoginPasskeyBeginPost, and that the signature counter has increased
+// 3. Stores the new signature counter
+// 4. Logs the user in. If the authenticator verified the user (with a PIN or
+// biometric) the passkey counts as two factors and two-factor authentication
+// is skipped; otherwise it stands in for the password only (see startLogin)
+//
+// A failed login redirects back to the login page with a flash message, which
+// the login page's script follows.
+//
+// Error Handling:
+//   - Malformed JSON, or no login in progress: 400 Bad Request
+//   - Database errors: 500 Internal Server Error
+func (app *application) userLoginPasskeyFinishPost(w http.ResponseWriter, r *http.Request) {
+	challenge := app.sessionManager.PopBytes(r.Context(), "passkeyLoginChallenge")
+
+	var resp webauthn.AssertionResponse
+
+	err := app.readJSON(w, r, &resp)
+	if err != nil || challenge == nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	loginFailed := func(message string) {
+		app.sessionManager.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+
+	passkey, err := app.passkeys.GetByCredentialID(r.Context(), resp.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			loginFailed("That passkey isn't registered with Snippetbox")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// The user handle is optional, but must match the passkey's owner if
+	// it's given.
+	if len(resp.UserHandle) > 0 && !bytes.Equal(resp.UserHandle, passkeyUserHandle(passkey.UserID)) {
+		loginFailed("Passkey login failed. Please try again.")
+		return
+	}
+
+	cred := webauthn.Credential{
+		ID:        passkey.CredentialID,
+		PublicKey: passkey.PublicKey,
+		SignCount: passkey.SignCount,
+	}
+
+	signCount, userVerified, err := app.webauthn.VerifyAssertion(challenge, cred, resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			app.logger.WarnContext(r.Context(), "passkey signature counter did not increase; possible cloned authenticator",
+				"user_id", passkey.UserID, "passkey_id", passkey.ID)
+		} else {
+			app.logger.WarnContext(r.Context(), "passkey login failed", "error", err.Error())
+		}
+		loginFailed("Passkey login failed. Please try again.")
+		return
+	}
+
+	err = app.passkeys.UpdateSignCount(r.Context(), passkey.ID, signCount)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if userVerified {
+		app.completeLogin(w, r, passkey.UserID, false)
+	} else {
+		app.startLogin(w, r, passkey.UserID, false)
+	}
+}
+
+// adminDashboard handles GET requests to the admin area's landing page,
+// which shows counts of users and snippets. Only administrators can reach the
+// admin area (see routes).
+//
+// Error Handling:
+//   - Database errors: 500 Internal Server Error
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	users, err := app.users.Count(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	live, expired, err := app.snippets.Counts(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Admin.UserCount = users
+	data.Admin.LiveSnippets = live
+	data.Admin.ExpiredSnippets = expired
+
+	app.render(w, r, http.StatusOK, "admin.html", data)
+}
+
+// adminDatabase handles GET requests to the admin area's database page,
+// which shows the connection pool's live statistics: how many connections
+// are open and in use, how often requests had to wait for one, and why
+// connections were closed. It helps with sizing the pool.
+func (app *application) adminDatabase(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Admin.DBStats = app.db.Stats()
+
+	app.render(w, r, http.StatusOK, "admin_database.html", data)
+}
+
+// adminUsers handles GET requests to list users in the admin area. The "q"
+// query string parameter searches names and email addresses, and "page"
+// selects the page of results.
+//
+// Error Handling:
+//   - Invalid page number: 400 Bad Request
+//   - Database errors: 500 Internal Server Error
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := readFilter(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	users, metadata, err := app.users.AdminList(r.Context(), filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Admin.Users = users
+	data.Admin.Metadata = metadata
+	data.Admin.Search = filter.Search
+
+	app.render(w, r, http.StatusOK, "admin_users.html", data)
+}
+
+// adminUserDisablePost handles POST requests to disable or re-enable a
+// user's account. Disabling an account also signs out all of its sessions.
+// Administrators can't disable their own account, so there is always
+// someone left to re-enable it.
+//
+// Error Handling:
+//   - Invalid form data, or the administrator's own account: 400 Bad Request
+//   - Unknown user: 404 Not Found
+//   - Database or session store errors: 500 Internal Server Error
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if form.ID == app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetDisabled(r.Context(), form.ID, form.Disabled)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	adminID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	target := fmt.Sprintf("user:%d", form.ID)
+
+	flash := "The account has been re-enabled"
+	action := models.AuditAdminUserEnable
+	if form.Disabled {
+		_, err = app.revokeUserSessions(r.Context(), form.ID, "")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		flash = "The account has been disabled and signed out"
+		action = models.AuditAdminUserDisable
+	}
+
+	app.audit(r, adminID, action, target)
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserPasswordResetPost handles POST requests to make a user change
+// their password before they can do anything else.
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Unknown user: 404 Not Found
+//   - Database errors: 500 Internal Server Error
+func (app *application) adminUserPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.RequirePasswordReset(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), models.AuditAdminUserPasswordReset, fmt.Sprintf("user:%d", form.ID))
+
+	app.sessionManager.Put(r.Context(), "flash", "The user will have to change their password when they next use Snippetbox")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminSnippets handles GET requests to list snippets, including expired
+// ones, in the admin area. The "q" query string parameter searches titles,
+// and "page" selects the page of results.
+//
+// Error Handling:
+//   - Invalid page number: 400 Bad Request
+//   - Database errors: 500 Internal Server Error
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	filter, err := readFilter(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, metadata, err := app.snippets.AdminList(r.Context(), filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Admin.Snippets = snippets
+	data.Admin.Metadata = metadata
+	data.Admin.Search = filter.Search
+
+	app.render(w, r, http.StatusOK, "admin_snippets.html", data)
+}
+
+// adminSnippetDeletePost handles POST requests to permanently delete any
+// snippet.
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Unknown snippet: 404 Not Found
+//   - Database errors: 500 Internal Server Error
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippets.Delete(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), models.AuditAdminSnippetDelete, fmt.Sprintf("snippet:%d", form.ID))
+
+	app.sessionManager.Put(r.Context(), "flash", "The snippet has been deleted")
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+// adminAudit handles GET requests to view the audit log in the admin area.
+// The "q" query string parameter searches actions, targets and actors' email
+// addresses, and "page" selects the page of results.
+//
+// Error Handling:
+//   - Invalid page number: 400 Bad Request
+//   - Database errors: 500 Internal Server Error
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := readFilter(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	events, metadata, err := app.auditLog.List(r.Context(), filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Admin.AuditEvents = events
+	data.Admin.Metadata = metadata
+	data.Admin.Search = filter.Search
+
+	app.render(w, r, http.StatusOK, "admin_audit.html", data)
+}
+
+// adminAuditExport handles GET requests to download the whole audit log as
+// JSON lines (one JSON object per event, oldest first), for loading into
+// other tools.
+//
+// The response is streamed, so once the first event has been written an
+// error can't change the status code. Errors part way through are logged and
+// the download ends early.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102")))
+
+	enc := json.NewEncoder(w)
+
+	err := app.auditLog.Export(r.Context(), func(e models.AuditEvent) error {
+		return enc.Encode(e)
+	})
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "audit log export failed", "error", err.Error())
+	}
+}
+
+// moderationQueue handles GET requests to view the moderation queue: the
+// snippets with open abuse reports, hidden snippets first.
+//
+// Error Handling:
+//   - Database errors: 500 Internal Server Error
+func (app *application) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	app.renderModerationQueue(w, r, http.StatusOK, moderationForm{})
+}
+
+// renderModerationQueue renders the moderation queue page with the given
+// status code and form (for showing validation errors).
+func (app *application) renderModerationQueue(w http.ResponseWriter, r *http.Request, status int, form moderationForm) {
+	queue, err := app.reports.Queue(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.ModerationQueue = queue
+	data.Form = form
+
+	app.render(w, r, status, "moderation.html", data)
+}
+
+// moderationDismissPost handles POST requests to dismiss the reports for a
+// snippet, leaving it up. A snippet hidden because of its reports is shown
+// again.
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Snippet without open reports: 404 Not Found
+//   - Database errors: 500 Internal Server Error
+func (app *application) moderationDismissPost(w http.ResponseWriter, r *http.Request) {
+	var form moderationForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.reports.Dismiss(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), models.AuditModerationDismiss, fmt.Sprintf("snippet:%d", form.ID))
+
+	app.sessionManager.Put(r.Context(), "flash", "The reports have been dismissed")
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// moderationTakeDownPost handles POST requests to take down a snippet. The
+// snippet is soft-deleted: it's kept in the database, and the reason is
+// shown to its author on their account page.
+//
+// Error Handling:
+//   - Invalid form data: 400 Bad Request
+//   - Unknown or already taken-down snippet: 404 Not Found
+//   - Missing or overlong reason: 422 Unprocessable Entity
+//   - Database errors: 500 Internal Server Error
+func (app *application) moderationTakeDownPost(w http.ResponseWriter, r *http.Request) {
+	var form moderationForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Reason), "reason", "Give a reason for taking the snippet down")
+	form.CheckField(validator.MaxChars(form.Reason, 500), "reason", "This field cannot be more than 500 characters long")
+
+	if !form.Valid() {
+		app.renderModerationQueue(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.reports.TakeDown(r.Context(), form.ID, form.Reason)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), models.AuditModerationTakeDown, fmt.Sprintf("snippet:%d", form.ID))
+
+	app.sessionManager.Put(r.Context(), "flash", "The snippet has been taken down")
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
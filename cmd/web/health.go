@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// The statuses reported by GET /healthz, GET /readyz and their checks.
+const (
+	healthAlive    = "alive"
+	healthReady    = "ready"
+	healthNotReady = "not ready"
+	healthDraining = "draining"
+	healthOK       = "ok"
+	healthFail     = "fail"
+)
+
+// readinessProbeToken is looked up in the session store to check that it's
+// working. No session has this token, as real tokens are random.
+const readinessProbeToken = "readiness-probe"
+
+// healthCheck is the result of one of the readiness checks. Error is a short
+// description of the failure; the details are logged rather than shown, as
+// the endpoint is public.
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readiness is the response body of GET /readyz.
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// healthz handles GET /healthz, the liveness probe. It responds as long as
+// the process is serving requests, without checking its dependencies, so
+// that an orchestrator only restarts the application if it's stuck rather
+// than whenever the database is down.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	app.writeJSON(w, r, http.StatusOK, map[string]string{"status": healthAlive})
+}
+
+// readyz handles GET /readyz, the readiness probe, which tells a load
+// balancer whether to send the application requests.
+//
+// Flow:
+// 1. Check that the database responds to a ping
+// 2. Check that the template cache has been loaded
+// 3. Check that the session store can look up a session
+// 4. Respond with each check's result
+//
+// The database and session store checks each wait at most
+// app.readinessTimeout, so a hung database can't hold the probe up.
+//
+// Error Handling:
+//   - A check fails: 503 Service Unavailable, with status "not ready" and the
+//     failure logged
+//   - The server is shutting down: 503 Service Unavailable, with status
+//     "draining", so that the load balancer stops sending new requests
+//     while in-flight ones finish
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	withTimeout := func(fn func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(r.Context(), app.readinessTimeout)
+		defer cancel()
+		return fn(ctx)
+	}
+
+	checks := map[string]healthCheck{
+		"database":  app.check(r, "database", "database ping failed", withTimeout(app.db.PingContext)),
+		"templates": app.check(r, "templates", "template cache is empty", app.checkTemplates()),
+		"sessions":  app.check(r, "sessions", "session store lookup failed", withTimeout(app.checkSessionStore)),
+	}
+
+	status, code := healthReady, http.StatusOK
+	for _, c := range checks {
+		if c.Status != healthOK {
+			status, code = healthNotReady, http.StatusServiceUnavailable
+		}
+	}
+	if app.draining.Load() {
+		status, code = healthDraining, http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	app.writeJSON(w, r, code, readiness{Status: status, Checks: checks})
+}
+
+// check turns the error returned by a readiness check into its result,
+// logging the error if there was one. msg is shown in place of the error;
+// if the check timed out, that's shown too.
+func (app *application) check(r *http.Request, name, msg string, err error) healthCheck {
+	if err == nil {
+		return healthCheck{Status: healthOK}
+	}
+
+	app.logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err.Error())
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		msg += ": timed out"
+	}
+	return healthCheck{Status: healthFail, Error: msg}
+}
+
+// checkTemplates reports an error if no templates have been loaded.
+func (app *application) checkTemplates() error {
+	if len(app.templateCache) == 0 {
+		return errors.New("template cache is empty")
+	}
+	return nil
+}
+
+// checkSessionStore looks up a session which doesn't exist, to check that
+// the session store is reachable. Stores which don't take a context (such as
+// the MySQL store) are given until ctx is done to answer; their lookup
+// carries on in the background if they take longer.
+func (app *application) checkSessionStore(ctx context.Context) error {
+	if store, ok := app.sessionManager.Store.(scs.CtxStore); ok {
+		_, _, err := store.FindCtx(ctx, readinessProbeToken)
+		return err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := app.sessionManager.Store.Find(readinessProbeToken)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
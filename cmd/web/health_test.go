@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// failingStore is a session store whose lookups fail.
+type failingStore struct{}
+
+func (failingStore) Find(token string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (failingStore) Commit(token string, b []byte, expiry time.Time) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Delete(token string) error {
+	return errors.New("connection refused")
+}
+
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+	app.db = &stubDB{err: errors.New("connection refused")}
+	ts := newTestServer(t, app.routes())
+	defer ts.server.Close()
+
+	// The liveness probe doesn't depend on the database.
+	code, header, body := ts.get(t, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.Equal(t, body, `{"status":"alive"}`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(app *application)
+		wantCode   int
+		wantStatus string
+		wantFailed string
+		wantError  string
+	}{
+		{
+			name:       "Ready",
+			setup:      func(app *application) {},
+			wantCode:   http.StatusOK,
+			wantStatus: healthReady,
+		},
+		{
+			name: "Database down",
+			setup: func(app *application) {
+				app.db = &stubDB{err: errors.New("dial tcp 10.0.0.5:3306: connection refused")}
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: healthNotReady,
+			wantFailed: "database",
+			wantError:  "database ping failed",
+		},
+		{
+			name: "Database hangs",
+			setup: func(app *application) {
+				app.db = &stubDB{block: true}
+				app.readinessTimeout = 20 * time.Millisecond
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: healthNotReady,
+			wantFailed: "database",
+			wantError:  "database ping failed: timed out",
+		},
+		{
+			name:       "No templates",
+			setup:      func(app *application) { app.templateCache = map[string]*template.Template{} },
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: healthNotReady,
+			wantFailed: "templates",
+			wantError:  "template cache is empty",
+		},
+		{
+			name:       "Session store down",
+			setup:      func(app *application) { app.sessionManager.Store = failingStore{} },
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: healthNotReady,
+			wantFailed: "sessions",
+			wantError:  "session store lookup failed",
+		},
+		{
+			name:       "Draining",
+			setup:      func(app *application) { app.draining.Store(true) },
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: healthDraining,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			tt.setup(app)
+
+			rr := httptest.NewRecorder()
+			app.readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, rr.Code, tt.wantCode)
+			assert.Equal(t, rr.Header().Get("Cache-Control"), "no-store")
+
+			var got readiness
+			err := json.NewDecoder(rr.Body).Decode(&got)
+			assert.NilError(t, err)
+			assert.Equal(t, got.Status, tt.wantStatus)
+			assert.Equal(t, len(got.Checks), 3)
+
+			for name, check := range got.Checks {
+				if name == tt.wantFailed {
+					assert.Equal(t, check.Status, healthFail)
+					assert.Equal(t, check.Error, tt.wantError)
+				} else {
+					assert.Equal(t, check.Status, healthOK)
+				}
+			}
+		})
+	}
+}
+
+func TestServeDraining(t *testing.T) {
+	app := newTestApplication(t)
+	app.shutdownTimeout = 5 * time.Second
+	app.drainDelay = 500 * time.Millisecond
+
+	url, shutdown, result := startServe(t, app, app.routes())
+
+	readyz := func() (int, string) {
+		resp, err := http.Get(url + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var got readiness
+		body, err := io.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		assert.NilError(t, json.Unmarshal(body, &got))
+		return resp.StatusCode, got.Status
+	}
+
+	code, status := readyz()
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, status, healthReady)
+
+	shutdown()
+
+	// Requests are still served during the drain delay, but the server
+	// reports that it's draining.
+	deadline := time.Now().Add(app.drainDelay)
+	for !app.draining.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	code, status = readyz()
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, status, healthDraining)
+
+	assert.NilError(t, <-result)
+}
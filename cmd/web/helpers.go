@@ -2,16 +2,35 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"snippetbox.tomcat.net/internal/models"
+	"snippetbox.tomcat.net/internal/totp"
 )
 
+// maxJSONBodySize limits the size of JSON request bodies, such as passkey
+// responses posted by the browser.
+const maxJSONBodySize = 64 * 1024
+
+// adminPageSize is the number of records on each page of the admin area's
+// lists.
+const adminPageSize = 20
+
 // serverError handles internal server errors by:
 // - Logging the error details including method, URI and stack trace
 // - Sending a 500 Internal Server Error response to the client
@@ -33,7 +52,7 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 	)
 
 	// Log the error with additional details such as HTTP method and URI, along with a stack trace.
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 
 	// If debug mode is enabled, show detailed error information to the client
 	// including the error message and stack trace. This is useful during development
@@ -69,7 +88,7 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 // render handles template rendering with proper error handling and status code management.
 // It:
 // - Retrieves the template from the cache
-// - Executes the template with provided data
+// - Executes the template with provided data, timing and tracing it
 // - Handles template execution errors
 // - Sets the appropriate HTTP status code
 //
@@ -97,8 +116,14 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 
 	buf := new(bytes.Buffer)
 
-	// Execute the template with the provided data and render it into the buffer
+	// Execute the template with the provided data and render it into the
+	// buffer, recording how long it took and tracing it as part of the
+	// request.
+	_, span := startRenderSpan(r.Context(), page)
+	start := time.Now()
 	err := ts.ExecuteTemplate(buf, "base", data)
+	app.metrics.renderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
+	endSpan(span, err)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -114,7 +139,8 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 // newTemplateData creates and initializes a templateData struct with:
 // - Current year for copyright information
 // - Flash messages from session
-// - Authentiacated status
+// - Authentiacated status and role
+// - Single sign-on provider name
 //
 // Parameters:
 //   - r: *http.Request - Contains the incoming HTTP request
@@ -125,12 +151,20 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 // This function is called at the start of each handler to create
 // the base data structure that will be passed to templates
 func (app *application) newTemplateData(r *http.Request) templateData {
-	return templateData{
+	data := templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
+		Role:            app.userRole(r),
 		CSRFToken:       nosurf.Token(r), // Add the CSRF token
 	}
+
+	// Only offer single sign-on if it's configured
+	if app.sso != nil {
+		data.SSOName = app.ssoName
+	}
+
+	return data
 }
 
 // decodePostForm handles form data decoding with proper error handling.
@@ -168,6 +202,34 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+// writeJSON encodes data as the JSON response body, with the given status
+// code.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// readJSON decodes a JSON request body of at most maxJSONBodySize bytes into
+// dst. Unknown fields are ignored, as browsers may add fields to WebAuthn
+// responses.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	return json.NewDecoder(r.Body).Decode(dst)
+}
+
+// passkeyUserHandle returns the WebAuthn user handle for a user, which
+// authenticators store alongside the passkey: their ID as 8 big-endian bytes.
+func passkeyUserHandle(userID int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
 // check the request context to determine if a user is authenticated or not
 // return true if the current request is from an authenticated user, otherwise
 // return false
@@ -179,3 +241,505 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenicated
 }
+
+// userRole returns the role of the authenticated user making the request, or
+// the empty string for unauthenticated requests.
+func (app *application) userRole(r *http.Request) models.Role {
+	role, ok := r.Context().Value(userRoleContextKey).(models.Role)
+	if !ok {
+		return ""
+	}
+
+	return role
+}
+
+// isPasswordResetRequired reports whether an administrator has required the
+// authenticated user making the request to change their password.
+func (app *application) isPasswordResetRequired(r *http.Request) bool {
+	required, ok := r.Context().Value(passwordResetRequiredContextKey).(bool)
+	if !ok {
+		return false
+	}
+
+	return required
+}
+
+// isEmailVerified reports whether the authenticated user making the request
+// has verified their email address. It returns false for unauthenticated
+// requests.
+func (app *application) isEmailVerified(r *http.Request) bool {
+	isEmailVerified, ok := r.Context().Value(isEmailVerifiedContextKey).(bool)
+	if !ok {
+		return false
+	}
+
+	return isEmailVerified
+}
+
+// sendVerificationEmail emails the user a signed link which they can follow
+// to prove that they own the address.
+//
+// Parameters:
+//   - name: string - The user's name, used to greet them
+//   - email: string - The address to verify (and send the link to)
+//
+// Returns:
+//   - error: Any error returned by the mailer
+func (app *application) sendVerificationEmail(name, email string) error {
+	token := app.tokens.Sign(emailVerificationPurpose, email, time.Now().Add(emailVerificationTTL))
+
+	link := app.baseURL + "/user/verify?token=" + url.QueryEscape(token)
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Thanks for signing up to Snippetbox. Please confirm your email address by visiting the link below:\n\n"+
+		"%s\n\n"+
+		"The link expires in %d hours. If you didn't sign up, you can ignore this email.\n",
+		name, link, int(emailVerificationTTL.Hours()))
+
+	return app.mailer.Send(email, "Verify your Snippetbox email address", body)
+}
+
+// startLogin is called once a user has proven who they are with their
+// password (or an identity provider). If they have two-factor authentication
+// enabled that alone isn't enough, so it remembers who they are in the
+// session (renewing the token, as their privilege level is changing) and asks
+// for a code before they are logged in. Otherwise it calls completeLogin.
+func (app *application) startLogin(w http.ResponseWriter, r *http.Request, id int, rememberMe bool) {
+	if _, ok := app.checkNotDisabled(w, r, id); !ok {
+		return
+	}
+
+	enabled, err := app.twoFactor.Enabled(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !enabled {
+		app.completeLogin(w, r, id, rememberMe)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+	app.sessionManager.Put(r.Context(), "twoFactorStarted", time.Now().Unix())
+	app.sessionManager.Put(r.Context(), "twoFactorRememberMe", rememberMe)
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// completeLogin finishes logging in the user with the given ID once all
+// authentication steps have succeeded. It:
+// - Refuses the login if an administrator has disabled the account
+// - Renews the session token to prevent session fixation attacks
+// - Stores the user ID in the session
+// - Clears the failed logins recorded for the account and client IP address
+// by the login throttle, now that every step has succeeded
+// - Makes the session persistent if the user asked to be remembered, or
+// starts tracking activity for the idle timeout if not
+// - Records the session's metadata so it appears on the sessions page
+// - Records the login in the audit log and the login metrics
+// - Redirects to the path the user originally requested, or to their account
+//
+// Parameters:
+//   - w: http.ResponseWriter - Used to write the redirect
+//   - r: *http.Request - The login request
+//   - id: int - The ID of the authenticated user
+//   - rememberMe: bool - Whether the user ticked "remember me"
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, id int, rememberMe bool) {
+	user, ok := app.checkNotDisabled(w, r, id)
+	if !ok {
+		return
+	}
+
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.loginThrottle.Succeeded(user.Email, clientIP(r))
+
+	// Remembered sessions get a persistent cookie and a longer lifetime, and
+	// aren't subject to the idle timeout. Other sessions keep the normal
+	// lifetime and a cookie which is deleted when the browser is closed.
+	if rememberMe {
+		app.sessionManager.RememberMe(r.Context(), true)
+		app.sessionManager.SetDeadline(r.Context(), time.Now().Add(app.rememberMeLifetime))
+	} else if app.sessionIdleTimeout > 0 {
+		app.sessionManager.Put(r.Context(), "lastActivity", time.Now().Unix())
+	}
+
+	// RenewToken has already generated the new token, so it can be recorded
+	// now even though the session won't be saved until the response is
+	// written.
+	err = app.userSessions.Record(r.Context(), app.sessionManager.Token(r.Context()), id, clientIP(r), r.UserAgent())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, id, models.AuditLogin, fmt.Sprintf("user:%d", id))
+	app.metrics.logins.Inc()
+
+	// Use PopString to retrieve the path and remove it from the session atomically.
+	// It returns the empty string if the key doesn't exist.
+	path := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+	if path != "" {
+		http.Redirect(w, r, path, http.StatusSeeOther)
+		return
+	}
+
+	// If the path wasn't in the session, redirect to the default page (the
+	// account view)
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// checkNotDisabled reports whether the user with the given ID may log in,
+// returning the user if so. If an administrator has disabled their account it
+// sends them back to the login page with a flash message and returns false.
+func (app *application) checkNotDisabled(w http.ResponseWriter, r *http.Request, id int) (models.User, bool) {
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return models.User{}, false
+	}
+
+	if user.Disabled {
+		app.sessionManager.Put(r.Context(), "flash", "Your account has been disabled. Please contact us if you think this is a mistake.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+// checkTwoFactorCode checks a code submitted by a user with two-factor
+// authentication enabled. The code is tried first as a code from their
+// authenticator app and then as one of their single-use recovery codes.
+//
+// Returns:
+//   - error: nil if either check passed, models.ErrInvalidCredentials if
+//     neither did, or any other error from the model
+func (app *application) checkTwoFactorCode(ctx context.Context, userID int, code string) error {
+	err := app.twoFactor.ValidateCode(ctx, userID, code, time.Now())
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		return err
+	}
+
+	return app.twoFactor.UseRecoveryCode(ctx, userID, code)
+}
+
+// renderTwoFactor renders the two-factor settings page with the given form.
+// If two-factor authentication isn't enabled yet it starts (or resumes)
+// enrolment, so that the page can show the secret to scan.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, form twoFactorForm) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+
+	secret, err := app.twoFactor.Begin(r.Context(), userID)
+	switch {
+	case errors.Is(err, models.ErrTwoFactorEnabled):
+		data.TwoFactor.Enabled = true
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	default:
+		data.TwoFactor.Secret = totp.EncodeSecret(secret)
+		data.TwoFactor.URI = totp.Default.URI(twoFactorIssuer, user.Email, secret)
+	}
+
+	app.render(w, r, status, "twofactor.html", data)
+}
+
+// revokeSession signs out a session other than the current one, by deleting
+// both its data in the session store and its metadata.
+func (app *application) revokeSession(ctx context.Context, token string) error {
+	err := app.sessionManager.Store.Delete(token)
+	if err != nil {
+		return err
+	}
+
+	return app.userSessions.Delete(ctx, token)
+}
+
+// revokeOtherSessions signs out all of a user's sessions except the current
+// one, returning the number of sessions signed out.
+func (app *application) revokeOtherSessions(r *http.Request, userID int) (int, error) {
+	return app.revokeUserSessions(r.Context(), userID, app.sessionManager.Token(r.Context()))
+}
+
+// revokeUserSessions signs out all of a user's sessions except the one with
+// keepToken (which may be empty, to sign out every session), returning the
+// number of sessions signed out.
+func (app *application) revokeUserSessions(ctx context.Context, userID int, keepToken string) (int, error) {
+	tokens, err := app.userSessions.DeleteOthers(ctx, userID, keepToken)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, token := range tokens {
+		err = app.sessionManager.Store.Delete(token)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(tokens), nil
+}
+
+// audit records an event in the audit log, along with the client's IP
+// address and User-Agent header.
+//
+// Parameters:
+//   - r: *http.Request - The request which caused the event
+//   - actorID: int - The user responsible, or 0 if they aren't logged in
+//   - action: string - What happened, one of the models.Audit* constants
+//   - target: string - What it happened to, e.g. "snippet:42"
+//
+// A failure to write the event is logged rather than returned, so that an
+// audit log outage doesn't stop people using the site.
+func (app *application) audit(r *http.Request, actorID int, action, target string) {
+	err := app.auditLog.Insert(r.Context(), models.AuditEvent{
+		ActorID:   actorID,
+		Action:    action,
+		Target:    target,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "failed to write audit event", "action", action, "target", target, "error", err.Error())
+	}
+}
+
+// readFilter reads the search text ("q") and page number ("page") for the
+// admin area's lists from the query string. The page defaults to 1, and an
+// error is returned if it isn't a positive integer.
+func readFilter(r *http.Request) (models.Filter, error) {
+	q := r.URL.Query()
+
+	filter := models.Filter{
+		Search:   strings.TrimSpace(q.Get("q")),
+		Page:     1,
+		PageSize: adminPageSize,
+	}
+
+	if s := q.Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 || page > 100_000 {
+			return models.Filter{}, fmt.Errorf("invalid page number %q", s)
+		}
+		filter.Page = page
+	}
+
+	return filter, nil
+}
+
+// clientIP returns the IP address of the client making the request, without
+// the port number. This is the address found by the realIP middleware, which
+// looks through trusted proxies; for requests which haven't been through it,
+// it's the address of the immediate peer.
+func clientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if ok {
+		return ip
+	}
+
+	return peerIP(r)
+}
+
+// peerIP returns the IP address of the immediate peer (the client, or a
+// proxy in front of the application), without the port number.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// parseTrustedProxies parses a comma-separated list of IP address ranges in
+// CIDR notation, such as "10.0.0.0/8, 192.168.1.10". A plain address is
+// treated as a range containing just that address.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// isTrustedProxy reports whether addr is in one of the trusted proxy ranges.
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP finds the client's IP address for a request whose
+// immediate peer is trusted, from the addresses in the header the trusted
+// proxies set (app.proxyHeader): X-Forwarded-For, or Forwarded. The other
+// header is never read, as a proxy which only sets one of them passes the
+// other on as the client sent it.
+//
+// Each proxy appends the address it received the request from, so the list
+// is read from right to left, skipping trusted proxies. The first untrusted
+// address is the client: anything to its left was supplied by the client and
+// can't be believed. If an entry can't be parsed (such as "unknown"), the
+// last trusted proxy before it is used.
+func (app *application) resolveClientIP(r *http.Request, peer netip.Addr) netip.Addr {
+	var hops []string
+	if app.proxyHeader == "forwarded" {
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+
+		client = addr
+		if !app.isTrustedProxy(addr) {
+			break
+		}
+	}
+
+	return client
+}
+
+// forwardedFor returns the "for" parameters of the elements of RFC 7239
+// Forwarded headers, in order. Elements without one give an empty string,
+// so that they can't be skipped over.
+func forwardedFor(values []string) []string {
+	var hops []string
+
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// parseHop parses an address from a Forwarded or X-Forwarded-For header,
+// which may have a port and, for IPv6, square brackets.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	// An IPv6 address in brackets without a port.
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		if addr, err := netip.ParseAddr(s[1 : len(s)-1]); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+
+	return netip.Addr{}, false
+}
+
+// humanDuration formats a (usually short) duration for display to users,
+// rounding up so that "try again in ..." is never too early. For example
+// 90 seconds becomes "2 minutes" and 20 seconds becomes "20 seconds".
+func humanDuration(d time.Duration) string {
+	if d < time.Minute {
+		seconds := int(math.Ceil(d.Seconds()))
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// canReport reports whether the current user can report the snippet as
+// abusive: they must be logged in, and it mustn't be their own snippet.
+func (app *application) canReport(r *http.Request, snippet models.Snippet) bool {
+	if !app.isAuthenticated(r) {
+		return false
+	}
+
+	return snippet.UserID != app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+}
+
+// background runs fn in a new goroutine, which shutdown waits for. A panic in
+// fn is logged rather than crashing the application.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error("background task panicked", "error", fmt.Sprint(err))
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader is the header a request's ID is read from, if the client
+// (or a proxy in front of the application) sent one, and returned in.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client.
+// Longer IDs are replaced, so that clients can't fill the logs.
+const maxRequestIDLength = 128
+
+// requestID middleware gives each request an ID, which is stored in the
+// request context and sent back in the X-Request-ID response header. Every
+// line logged with the request's context includes it, so that the lines
+// logged for one request can be found together.
+//
+// If the request already has a valid X-Request-ID header, for example from
+// a proxy which logs the same ID, that ID is used; otherwise a random one is
+// generated. It should come first in the middleware chain, so that even
+// recoverPanic's log lines include the ID.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newRequestID returns a random 128-bit request ID, as 32 hex digits.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether id can be used as a request ID: it must be
+// no longer than maxRequestIDLength and only contain printable ASCII
+// characters other than space, so that it can't break up log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the ID of the request whose context ctx is,
+// or "" if it doesn't have one.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// newLogger returns the application's logger, which writes to w in the
+// given format: "json" for one JSON object per line, or anything else for
+// slog's key=value text format. Lines logged with a request's context
+// include its ID and, if it's traced, its trace and span IDs.
+func newLogger(w io.Writer, format string) *slog.Logger {
+	var h slog.Handler
+	if format == "json" {
+		h = slog.NewJSONHandler(w, nil)
+	} else {
+		h = slog.NewTextHandler(w, nil)
+	}
+
+	return slog.New(&requestIDHandler{h})
+}
+
+// requestIDHandler is a slog.Handler which adds the request ID from each
+// record's context, if it has one, and the trace and span IDs of the span in
+// the context, if it's traced, before passing the record on. This way log
+// lines written while handling a request can be found from its trace and
+// vice versa.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h *requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	id := requestIDFromContext(ctx)
+	sc := trace.SpanContextFromContext(ctx)
+
+	if id != "" || sc.IsValid() {
+		r = r.Clone()
+	}
+	if id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *requestIDHandler) WithGroup(name string) slog.Handler {
+	return &requestIDHandler{h.Handler.WithGroup(name)}
+}
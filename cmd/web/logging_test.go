@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		header    string
+		generated bool
+	}{
+		{name: "No header", header: "", generated: true},
+		{name: "Valid header", header: "f3a9c1d2-proxy-42", generated: false},
+		{name: "Contains a space", header: "f3a9 c1d2", generated: true},
+		{name: "Contains a newline", header: "f3a9\nlevel=ERROR", generated: true},
+		{name: "Too long", header: strings.Repeat("a", maxRequestIDLength+1), generated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = requestIDFromContext(r.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(requestIDHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			app.requestID(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Header().Get(requestIDHeader), got)
+			if tt.generated {
+				assert.Equal(t, len(got), 32)
+			} else {
+				assert.Equal(t, got, tt.header)
+			}
+		})
+	}
+
+	t.Run("Unique", func(t *testing.T) {
+		assert.Equal(t, newRequestID() == newRequestID(), false)
+	})
+}
+
+// logLines decodes the JSON log lines in buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line map[string]any
+		err := dec.Decode(&line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.logger = newLogger(&buf, "json")
+
+	r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	r.Header.Set(requestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	lines := logLines(t, &buf)
+	assert.Equal(t, len(lines), 2)
+	received, completed := lines[0], lines[1]
+
+	t.Run("Received", func(t *testing.T) {
+		assert.Equal(t, received["msg"], any("received request"))
+		assert.Equal(t, received["request_id"], any("req-1"))
+		assert.Equal(t, received["uri"], any("/ping"))
+	})
+
+	t.Run("Completed", func(t *testing.T) {
+		assert.Equal(t, completed["msg"], any("completed request"))
+		assert.Equal(t, completed["request_id"], any("req-1"))
+		assert.Equal(t, completed["method"], any("GET"))
+		assert.Equal(t, completed["status"], any(float64(http.StatusOK)))
+		assert.Equal(t, completed["size"], any(float64(rr.Body.Len())))
+
+		_, ok := completed["duration"].(float64)
+		assert.Equal(t, ok, true)
+	})
+
+	t.Run("Status code", func(t *testing.T) {
+		buf.Reset()
+		app.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/snippet/view/2", nil))
+
+		lines := logLines(t, &buf)
+		assert.Equal(t, lines[len(lines)-1]["status"], any(float64(http.StatusNotFound)))
+	})
+}
+
+func TestServerErrorLogsRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.logger = newLogger(&buf, "text")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.serverError(w, r, errors.New("database is down"))
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(requestIDHeader, "req-2")
+	app.requestID(next).ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.StringContains(t, buf.String(), `msg="database is down"`)
+	assert.StringContains(t, buf.String(), "request_id=req-2")
+}
@@ -1,21 +1,42 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"snippetbox.tomcat.net/internal/config"
+	"snippetbox.tomcat.net/internal/mailer"
 	"snippetbox.tomcat.net/internal/models"
+	"snippetbox.tomcat.net/internal/oidc"
+	"snippetbox.tomcat.net/internal/scanner"
+	"snippetbox.tomcat.net/internal/secrets"
+	"snippetbox.tomcat.net/internal/tokens"
+	"snippetbox.tomcat.net/internal/webauthn"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // application struct represents the core application instance.
@@ -23,40 +44,256 @@ import (
 // This struct promotes dependency injection, making components easily testable and replaceable.
 type application struct {
 	debug          bool
-	logger         *slog.Logger                  // Structured logger for consistent logging.
-	snippets       models.SnippetModelInterface  // Changed to interface type
-	templateCache  map[string]*template.Template // In-memory cache for parsed HTML templates.
-	formDecoder    *form.Decoder                 // HTML form decoder for processing form data.
-	sessionManager *scs.SessionManager           // User session manager for handling user sessions.
-	users          models.UserModelInterface     // Changed to interface type
+	logger         *slog.Logger                     // Structured logger for consistent logging.
+	snippets       models.SnippetModelInterface     // Changed to interface type
+	templateCache  map[string]*template.Template    // In-memory cache for parsed HTML templates.
+	formDecoder    *form.Decoder                    // HTML form decoder for processing form data.
+	sessionManager *scs.SessionManager              // User session manager for handling user sessions.
+	users          models.UserModelInterface        // Changed to interface type
+	twoFactor      models.TwoFactorModelInterface   // TOTP two-factor authentication model.
+	loginThrottle  models.LoginThrottleInterface    // Tracks failed logins to slow down password guessing.
+	identities     models.IdentityModelInterface    // Links users to single sign-on identities.
+	userSessions   models.UserSessionModelInterface // Metadata about logged-in sessions, for remote sign-out.
+	passkeys       models.PasskeyModelInterface     // WebAuthn credentials for passwordless login.
+	auditLog       models.AuditEventModelInterface  // Append-only log of security-relevant events.
+	reports        models.ReportModelInterface      // Abuse reports and moderation decisions.
+	scanner        scanner.Scanner                  // Checks new snippets for leaked secrets and spam.
+	rateLimiter    models.RateLimiterInterface      // Token buckets for rate-limited routes.
+	mailer         mailer.Mailer                    // Sends transactional emails such as verification links.
+	tokens         *tokens.Signer                   // Signs and verifies tokens embedded in emailed links.
+	baseURL        string                           // Public URL of the application, used to build links in emails.
+
+	// sso is the OpenID Connect identity provider used for single sign-on,
+	// and ssoName its name as shown on the login page. sso is nil if single
+	// sign-on isn't configured.
+	sso     *oidc.Provider
+	ssoName string
+
+	// webauthn is the relying party that passkeys are registered with,
+	// derived from baseURL.
+	webauthn *webauthn.RelyingParty
+
+	// rememberMeLifetime is the lifetime of sessions where the user ticked
+	// "remember me" when logging in. sessionIdleTimeout logs out other
+	// sessions after this long without a request (zero disables it).
+	rememberMeLifetime time.Duration
+	sessionIdleTimeout time.Duration
+
+	// secureCookies is true if cookies should only be sent over HTTPS, which
+	// is the case unless the application is served over plain HTTP to
+	// browsers. hstsMaxAge is the max-age of the Strict-Transport-Security
+	// header, which is only sent when serving HTTPS (zero disables it).
+	secureCookies bool
+	hstsMaxAge    time.Duration
+
+	// trustedProxies are the address ranges of proxies in front of the
+	// application, whose proxyHeader ("x-forwarded-for" for X-Forwarded-For,
+	// or "forwarded" for Forwarded) is believed when finding the client's IP
+	// address. The other header is ignored, as the proxies may pass it on
+	// from the client unchanged.
+	trustedProxies []netip.Prefix
+	proxyHeader    string
+
+	// requireEmailVerification prevents users who haven't verified their
+	// email address from using routes wrapped by requireVerifiedEmail.
+	requireEmailVerification bool
+
+	// reportThreshold is the number of users who must report a snippet for
+	// it to be hidden until a moderator reviews it (zero disables hiding).
+	reportThreshold int
+
+	// metrics are the Prometheus metrics served by GET /metrics. If
+	// metricsToken is set, scrapes must send it as a bearer token.
+	// metricsOnMainListener is true if GET /metrics is served by routes,
+	// rather than by a separate listener.
+	metrics               *appMetrics
+	metricsToken          string
+	metricsOnMainListener bool
+
+	// tracerProvider records a span for each request, and for the queries
+	// and templates it runs. It's nil if tracing is disabled.
+	tracerProvider trace.TracerProvider
+
+	// db is the database connection pool, pinged by GET /readyz, which
+	// waits at most readinessTimeout for it and the session store, and whose
+	// statistics are shown at GET /admin/database.
+	db               dbPool
+	readinessTimeout time.Duration
+
+	// shutdownTimeout is how long to wait for in-flight requests and
+	// background tasks to finish when shutting down. Before that, requests
+	// are still served for drainDelay, with draining set so that GET /readyz
+	// reports the server isn't ready.
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	draining        atomic.Bool
+
+	// wg tracks the goroutines started by background, so that shutdown can
+	// wait for them.
+	wg sync.WaitGroup
 }
 
 func main() {
-	// Define a flag for the HTTP network address.
-	// Default: ":4000" (listen on all interfaces, port 4000).
-	// Usage: -addr=":8080" to change the listening port.
-	addr := flag.String("addr", ":4000", "HTTP network address")
+	// Load the configuration from the defaults, the configuration file,
+	// SNIPPETBOX_* environment variables and command-line flags, in that
+	// order of precedence. An invalid configuration stops the application
+	// before it connects to anything.
+	cfg, unknownEnv, err := config.Load(os.Args[0], os.Args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// With -print-config, show the effective configuration (with secrets
+	// redacted) instead of starting the server.
+	if cfg.PrintConfig {
+		err = cfg.WriteTOML(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Define a flag for the MySQL Data Source Name (DSN).
-	// Format: "username:password@protocol(address)/dbname?param=value".
-	// Default: "web:pass@/snippetbox?parseTime=true".
-	// Usage: -dsn="user:password@tcp(localhost:3306)/dbname".
-	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	// Create a new structured logger that writes to standard output.
+	// It uses a text format for human readability by default, or JSON for
+	// log collectors. The default log level is Info. Lines logged while
+	// handling a request include its ID and, if it's traced, its trace and
+	// span IDs.
+	logger := newLogger(os.Stdout, cfg.LogFormat)
 
-	debug := flag.Bool("debug", false, "Enable debug mode")
+	// Environment variables which look like settings but aren't are most
+	// likely typos, though some platforms set variables with the same prefix.
+	if len(unknownEnv) > 0 {
+		logger.Warn("ignoring unknown environment variables", "names", strings.Join(unknownEnv, ","))
+	}
 
-	// Parse command-line flags.
-	// This reads the actual values provided when the program is executed.
-	flag.Parse()
+	// Parse the trusted proxy ranges.
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	// Create a new structured logger that writes to standard output.
-	// It uses a text format for human readability.
-	// The default log level is Info.
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// Load the secret key used for signing tokens, generating a temporary
+	// one if none was provided.
+	secretKey, err := loadSecretKey(cfg.Secret)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if cfg.Secret == "" {
+		logger.Warn("no secret configured; using a temporary key, emailed links and two-factor secrets will stop working after a restart")
+	}
 
-	// Open a MySQL database connection using the provided DSN.
-	// The openDB function handles the connection and ping verification.
-	db, err := openDB(*dsn)
+	// Create the box used to encrypt TOTP secrets before they are stored,
+	// using a key derived from the secret so that it's separate from the
+	// token signing key.
+	twoFactorBox, err := secrets.NewBox(secrets.DeriveKey(secretKey, "totp-secrets"))
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Use a real SMTP server if one is configured, otherwise log emails so
+	// that verification links can be followed during local development.
+	var mail mailer.Mailer = &mailer.Log{Logger: logger}
+	if cfg.SMTP.Host != "" {
+		mail = &mailer.SMTP{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			Sender:   cfg.SMTP.Sender,
+		}
+	}
+
+	// Discover the single sign-on provider, if one is configured. The
+	// application won't start if the provider can't be reached, rather than
+	// starting with single sign-on silently broken.
+	var sso *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		sso, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(cfg.BaseURL, "/") + "/user/login/oidc/callback",
+			Scopes:       []string{"email", "profile"},
+		})
+		cancel()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Passkeys are scoped to the host name of the public URL, and can only
+	// be used on pages served from its origin.
+	rp, err := newRelyingParty(cfg.BaseURL)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Load the content scanner's rules.
+	contentScanner := scanner.Default()
+	if cfg.ScannerRules != "" {
+		contentScanner, err = scanner.Load(cfg.ScannerRules)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Send traces to an OpenTelemetry collector, if one is configured.
+	// Failures to send them are logged but don't affect requests.
+	var (
+		tracerProvider *sdktrace.TracerProvider
+		modelTracer    trace.Tracer
+	)
+	if cfg.Tracing.OTLPEndpoint != "" {
+		headers, err := config.ParseHeaders(cfg.Tracing.OTLPHeaders)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			logger.Error(err.Error())
+		}))
+
+		tracerProvider, err = newTracerProvider(context.Background(), cfg.Tracing.OTLPEndpoint, headers, cfg.Tracing.ServiceName)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		modelTracer = tracerProvider.Tracer(models.TracerName)
+
+		logger.Info("sending traces", "endpoint", cfg.Tracing.OTLPEndpoint)
+	}
+
+	// Stop gracefully on SIGINT (Ctrl+C) or SIGTERM (sent by most process
+	// managers and orchestrators when deploying a new version). Before the
+	// server starts, this stops waiting for the database.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Once the first signal has arrived, restore the default behaviour, so
+	// that a second SIGINT or SIGTERM exits straight away instead of waiting
+	// for the shutdown to finish.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Open a MySQL database connection pool using the provided DSN.
+	// The openDB function sizes the pool and waits for the database to
+	// become reachable, retrying for up to the connect timeout.
+	db, err := openDB(ctx, cfg.DSN, cfg.DB, logger)
 	if err != nil {
 		// Log the error at the Error level and exit the program.
 		// An exit code of 1 indicates a general error.
@@ -65,7 +302,9 @@ func main() {
 	}
 
 	// Ensure the database connection is closed when the main function exits.
-	// This will execute even if subsequent errors occur.
+	// This will execute even if subsequent errors occur. It's also closed
+	// explicitly after a graceful shutdown, as os.Exit doesn't run deferred
+	// calls.
 	defer db.Close()
 
 	// Create a new template cache from all template files in the "ui/html" directory.
@@ -77,67 +316,352 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Create the rate limiter's store.
+	var rateLimiter models.RateLimiterInterface
+	switch cfg.RateLimitStore {
+	case "memory":
+		rateLimiter = models.NewMemoryRateLimiter()
+	case "mysql":
+		rateLimiter = &models.MySQLRateLimiter{DB: db, QueryTimeout: cfg.DB.QueryTimeout}
+	default:
+		logger.Error("invalid rate limit store", "value", cfg.RateLimitStore)
+		os.Exit(1)
+	}
+
 	// Initialize a new form decoder for handling HTML form data.
 	// The decoder handles URL-encoded and multipart form data.
 	formDecoder := form.NewDecoder()
 
+	// Cookies are only sent over HTTPS, unless browsers are using plain
+	// HTTP. Behind a proxy which terminates TLS the application serves plain
+	// HTTP but browsers still use HTTPS, as the base URL shows.
+	secureCookies := cfg.TLS.Enabled || strings.HasPrefix(cfg.BaseURL, "https://")
+
+	// Browsers are told to keep using HTTPS only if we're serving it.
+	var hstsMaxAge time.Duration
+	if cfg.TLS.Enabled {
+		hstsMaxAge = cfg.TLS.HSTSMaxAge
+	}
+
 	// Initialize a new session manager using MySQL storage.
-	// Session data is stored in the database with a 12-hour lifetime.
+	// Session data is stored in the database with the configured lifetime
+	// (12 hours by default). Cookies are only persistent for "remember me" sessions.
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
-	sessionManager.Cookie.Secure = true // Only send cookies over HTTPS.
+	sessionStore := mysqlstore.New(db)
+	sessionManager.Store = sessionStore
+	sessionManager.Lifetime = cfg.Session.Lifetime
+	sessionManager.Cookie.Persist = false
+	sessionManager.Cookie.Secure = secureCookies
+
+	// Every model abandons its queries if they take longer than the query
+	// timeout. The snippet and user models also trace their queries as part
+	// of the request they're made for.
+	queryTimeout := cfg.DB.QueryTimeout
+	snippets := &models.SnippetModel{DB: db, Tracer: modelTracer, QueryTimeout: queryTimeout}
+	users := &models.UserModel{DB: db, Tracer: modelTracer, QueryTimeout: queryTimeout}
 
 	// Initialize the application instance with all required dependencies.
 	// This creates the core application context that persists throughout the program.
 	app := &application{
-		debug:          *debug,
-		logger:         logger,                       // Structured logger.
-		snippets:       &models.SnippetModel{DB: db}, // Snippet database model.
-		templateCache:  templateCache,                // Template cache.
-		formDecoder:    formDecoder,                  // Form decoder.
-		sessionManager: sessionManager,               // Session manager.
-		users:          &models.UserModel{DB: db},    // User database model.
+		debug:          cfg.Debug,
+		logger:         logger,         // Structured logger.
+		snippets:       snippets,       // Snippet database model.
+		templateCache:  templateCache,  // Template cache.
+		formDecoder:    formDecoder,    // Form decoder.
+		sessionManager: sessionManager, // Session manager.
+		users:          users,          // User database model.
+		twoFactor:      &models.TwoFactorModel{DB: db, Box: twoFactorBox, QueryTimeout: queryTimeout},
+		loginThrottle:  models.NewLoginThrottle(models.DefaultAccountPolicy, models.DefaultIPPolicy),
+		identities:     &models.IdentityModel{DB: db, QueryTimeout: queryTimeout},    // Single sign-on identities.
+		userSessions:   &models.UserSessionModel{DB: db, QueryTimeout: queryTimeout}, // Logged-in session metadata.
+		passkeys:       &models.PasskeyModel{DB: db, QueryTimeout: queryTimeout},     // Passkeys.
+		auditLog:       &models.AuditEventModel{DB: db, QueryTimeout: queryTimeout},  // Audit log.
+		reports:        &models.ReportModel{DB: db, QueryTimeout: queryTimeout},      // Abuse reports.
+		scanner:        contentScanner,                                               // Secret and spam detection.
+		rateLimiter:    rateLimiter,                                                  // Rate limits.
+		mailer:         mail,                                                         // Email sender.
+		tokens:         tokens.New(secretKey),                                        // Token signer.
+		baseURL:        strings.TrimSuffix(cfg.BaseURL, "/"),
+
+		sso:                      sso,
+		ssoName:                  cfg.OIDC.Name,
+		webauthn:                 rp,
+		rememberMeLifetime:       cfg.Session.RememberMeLifetime,
+		sessionIdleTimeout:       cfg.Session.IdleTimeout,
+		secureCookies:            secureCookies,
+		hstsMaxAge:               hstsMaxAge,
+		trustedProxies:           trustedProxies,
+		proxyHeader:              cfg.ProxyHeader,
+		requireEmailVerification: cfg.RequireVerifiedEmail,
+		reportThreshold:          cfg.ReportThreshold,
+		metrics:                  newAppMetrics(db),
+		metricsToken:             cfg.Metrics.Token,
+		metricsOnMainListener:    cfg.Metrics.Addr == "" && cfg.Metrics.Token != "",
+		db:                       db,
+		readinessTimeout:         cfg.Server.ReadinessTimeout,
+		shutdownTimeout:          cfg.Server.ShutdownTimeout,
+		drainDelay:               cfg.Server.DrainDelay,
+	}
+
+	// Only set the tracer provider if tracing is enabled, as a nil
+	// *sdktrace.TracerProvider would make a non-nil interface.
+	if tracerProvider != nil {
+		app.tracerProvider = tracerProvider
+	}
+
+	// Log the connection pool's statistics periodically, if configured, to
+	// help with sizing the pool.
+	if cfg.DB.StatsInterval > 0 {
+		app.background(func() {
+			logDBStats(ctx, db, cfg.DB.StatsInterval, logger)
+		})
+	}
+
+	// Get certificates from an ACME CA such as Let's Encrypt, if host names
+	// are configured, caching them in a directory or in the database.
+	var certManager *autocert.Manager
+	if hosts := cfg.TLS.ACMEHosts(); len(hosts) > 0 {
+		var cache autocert.Cache = autocert.DirCache(cfg.TLS.ACMECacheDir)
+		if cfg.TLS.ACMECache == "mysql" {
+			cache = &models.CertCacheModel{DB: db}
+		}
+
+		certManager, err = newCertManager(cfg.TLS, cache)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		logger.Info("using ACME certificates", "hosts", hosts, "directory", cfg.TLS.ACMEDirectory)
 	}
 
 	// Configure TLS settings for secure communication.
-	// This prefers modern, secure elliptic curves for key exchange.
-	tlsConfig := &tls.Config{
-		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	tlsConfig := newTLSConfig(certManager)
+
+	// Without ACME, serve the certificate files, reloading them when they're
+	// replaced so that rotated certificates are picked up without a restart.
+	if cfg.TLS.Enabled && certManager == nil {
+		reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, app.logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+
+		if cfg.TLS.ReloadInterval > 0 {
+			app.background(func() {
+				reloader.watch(ctx, cfg.TLS.ReloadInterval)
+			})
+		}
 	}
 
 	// Initialize the HTTP server with configuration.
 	// This includes the address, request handler, error logging, and TLS settings.
 	srv := &http.Server{
-		Addr:         *addr,                                                // Network address to listen on.
+		Addr:         cfg.Addr,                                             // Network address to listen on.
 		Handler:      app.routes(),                                         // Router/mux for request handling.
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError), // Error logger.
 		TLSConfig:    tlsConfig,                                            // TLS configuration for HTTPS.
-		IdleTimeout:  time.Minute,                                          // Maximum time to wait for the next request when keep-alives are enabled.
-		ReadTimeout:  5 * time.Second,                                      // Maximum duration for reading the entire request, including the body.
-		WriteTimeout: 10 * time.Second,                                     // Maximum duration before timing out writes of the response.
+		IdleTimeout:  cfg.Server.IdleTimeout,                               // Maximum time to wait for the next request when keep-alives are enabled.
+		ReadTimeout:  cfg.Server.ReadTimeout,                               // Maximum duration for reading the entire request, including the body.
+		WriteTimeout: cfg.Server.WriteTimeout,                              // Maximum duration before timing out writes of the response.
+	}
+
+	// Serve HTTPS, or plain HTTP if TLS is disabled (for example, because a
+	// proxy in front of the application terminates TLS). The default
+	// certificate is self-signed, for local development. Certificates come
+	// from tlsConfig.GetCertificate, so no files are passed here.
+	listeners := []listener{{
+		name: "server",
+		srv:  srv,
+		listen: func() error {
+			if !cfg.TLS.Enabled {
+				return srv.ListenAndServe()
+			}
+			return srv.ListenAndServeTLS("", "")
+		},
+	}}
+
+	// Optionally listen for plain HTTP as well, and redirect to HTTPS. With
+	// ACME certificates, this listener also answers http-01 challenges.
+	if cfg.TLS.RedirectAddr != "" {
+		redirectHandler := app.redirectRoutes()
+		if certManager != nil {
+			redirectHandler = certManager.HTTPHandler(redirectHandler)
+		}
+
+		redirectSrv := &http.Server{
+			Addr:         cfg.TLS.RedirectAddr,
+			Handler:      redirectHandler,
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  cfg.Server.IdleTimeout,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+		}
+		listeners = append(listeners, listener{
+			name:   "redirect server",
+			srv:    redirectSrv,
+			listen: redirectSrv.ListenAndServe,
+		})
+	}
+
+	// Optionally serve metrics on a separate plain HTTP listener, which can
+	// be kept off the public network.
+	if cfg.Metrics.Addr != "" {
+		metricsSrv := &http.Server{
+			Addr:         cfg.Metrics.Addr,
+			Handler:      app.metricsRoutes(),
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  cfg.Server.IdleTimeout,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+		}
+		listeners = append(listeners, listener{
+			name:   "metrics server",
+			srv:    metricsSrv,
+			listen: metricsSrv.ListenAndServe,
+		})
+	}
+
+	// Log a message indicating that the servers are starting.
+	// This includes the addresses they will listen on.
+	for _, l := range listeners {
+		logger.Info("starting "+l.name, "addr", l.srv.Addr)
+	}
+
+	// Serve requests until we're told to stop.
+	err = app.serve(ctx, listeners...)
+	if err != nil {
+		// This typically indicates a port conflict or permission issue, or
+		// requests which didn't finish before the shutdown timeout.
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// With no requests left, stop the session store's cleanup goroutine,
+	// send the last traces and close the database.
+	sessionStore.StopCleanup()
+
+	if tracerProvider != nil {
+		logger.Info("sending remaining traces")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = tracerProvider.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	logger.Info("closing database")
+	err = db.Close()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info("stopped server")
+}
+
+// listener is a server run by serve.
+//
+// Fields:
+//   - name: Describes the server in log messages, such as "redirect server"
+//   - srv: The server, used to shut it down
+//   - listen: Starts the server and blocks until it stops, such as
+//     srv.ListenAndServeTLS
+type listener struct {
+	name   string
+	srv    *http.Server
+	listen func() error
+}
+
+// serve runs the servers until ctx is cancelled, then shuts them down
+// gracefully.
+//
+// Parameters:
+//   - ctx: Cancelled to start the shutdown, for example on SIGTERM
+//   - listeners: The servers to run
+//
+// Flow:
+// 1. Start each server with its listen function
+// 2. Wait for ctx to be cancelled (or a server to fail)
+// 3. Keep serving for app.drainDelay, with GET /readyz reporting "draining"
+// 4. Stop accepting connections and wait for in-flight requests to finish
+// 5. Wait for background tasks started with app.background to finish
+//
+// Error Handling:
+//   - A listen function fails (for example, the address is in use): its
+//     error, after the other servers have been shut down
+//   - Requests or background tasks still running after app.shutdownTimeout:
+//     context.DeadlineExceeded
+func (app *application) serve(ctx context.Context, listeners ...listener) error {
+	listenErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			listenErr <- l.listen()
+		}()
 	}
 
-	// Log a message indicating that the server is starting.
-	// This includes the address the server will listen on.
-	logger.Info("starting server", "addr", srv.Addr)
+	var err error
+	select {
+	case err = <-listenErr:
+	case <-ctx.Done():
+	}
 
-	// Start the HTTP server on the specified address.
-	// The routes() method returns the configured router/mux.
-	// Start HTTPS server with TLS certificates.
-	// Uses self-signed certificates for local development.
-	err = srv.ListenAndServeTLS("./tls/localhost+2.pem", "./tls/localhost+2-key.pem")
+	if err != nil {
+		// One of the servers failed, so stop the others before giving up.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+		defer cancel()
+
+		for _, l := range listeners {
+			l.srv.Shutdown(shutdownCtx)
+		}
+		return err
+	}
+
+	app.draining.Store(true)
+	if app.drainDelay > 0 {
+		app.logger.Info("draining server", "delay", app.drainDelay.String())
+		time.Sleep(app.drainDelay)
+	}
+
+	app.logger.Info("shutting down server", "timeout", app.shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+	defer cancel()
+
+	// Shutdown closes the listeners, then waits for active connections to
+	// become idle.
+	for _, l := range listeners {
+		err = l.srv.Shutdown(shutdownCtx)
+		if err != nil {
+			return err
+		}
+	}
+
+	app.logger.Info("waiting for background tasks")
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		return shutdownCtx.Err()
+	}
 
-	// If an error occurs while starting the server, log the error and exit.
-	// This typically indicates a port conflict or permission issue.
-	logger.Error(err.Error())
-	os.Exit(1)
+	app.logger.Info("server shut down")
+	return nil
 }
 
-// openDB establishes and verifies a connection to the MySQL database using the provided DSN.
-// It ensures proper resource cleanup in case of connection failures.
+// openDB opens a pool of connections to the MySQL database, and waits for
+// the database to become reachable.
 //
 // Parameters:
+//   - ctx: Cancelled to stop waiting, for example on SIGTERM
 //   - dsn: Data Source Name containing connection details.
 //     Format: "username:password@protocol(address)/dbname?param=value".
 //     Common parameters:
@@ -145,33 +669,47 @@ func main() {
 //   - timeout=30s: Connection timeout duration.
 //   - readTimeout=30s: Read operation timeout.
 //   - writeTimeout=30s: Write operation timeout.
+//   - cfg: The pool's limits, and how long to wait for the database
+//   - logger: Logs each failed attempt to reach the database
 //
 // Returns:
 //   - *sql.DB: Database connection handle, ready for query execution.
 //   - error: Any error that occurred during connection or verification.
 //
 // The function performs these steps:
-// 1. Opens a new database connection using the MySQL driver.
-// 2. Verifies the connection is alive by pinging the database.
-// 3. Returns the verified connection handle.
+// 1. Opens a new database connection pool using the MySQL driver.
+// 2. Applies the pool's connection limits and lifetimes.
+// 3. Pings the database until it responds, backing off between attempts.
+// 4. Returns the verified connection handle.
 //
 // Error Handling:
-//   - If the connection fails, returns the original error immediately.
-//   - If the ping fails, ensures proper resource cleanup by closing the connection
-//     before returning the ping error, preventing connection leaks.
+//   - If the DSN is invalid, returns the error immediately.
+//   - If the database isn't reachable within cfg.ConnectTimeout, or rejects
+//     the connection (for example, because the password is wrong), closes
+//     the pool and returns the last ping error, preventing connection leaks.
 //   - The returned *sql.DB handle is safe for concurrent use and manages
 //     a pool of underlying connections automatically.
-func openDB(dsn string) (*sql.DB, error) {
-	// Open a new database connection using the MySQL driver.
+func openDB(ctx context.Context, dsn string, cfg config.DBConfig, logger *slog.Logger) (*sql.DB, error) {
+	// Open a new database connection pool using the MySQL driver.
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify the connection is alive by pinging the database.
-	err = db.Ping()
+	// Limit the pool. The maximum number of idle connections is capped at
+	// the maximum number of open connections, so that's set first.
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	// Wait for the database, for example while its container starts.
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	err = waitForDB(ctx, db, dbRetryMinDelay, dbRetryMaxDelay, logger)
 	if err != nil {
-		// If the ping fails, close the connection to free resources.
+		// If the database can't be reached, close the pool to free resources.
 		db.Close()
 		return nil, err
 	}
@@ -179,3 +717,42 @@ func openDB(dsn string) (*sql.DB, error) {
 	// Return the verified database connection.
 	return db, nil
 }
+
+// loadSecretKey returns the key used to sign tokens. If secret is empty, a
+// random 32-byte key is generated; otherwise secret must be at least 32
+// characters long.
+func loadSecretKey(secret string) ([]byte, error) {
+	if secret == "" {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	if len(secret) < 32 {
+		return nil, errors.New("the secret must be at least 32 characters long")
+	}
+
+	return []byte(secret), nil
+}
+
+// newRelyingParty returns the WebAuthn relying party for the application's
+// public base URL, such as "https://snippetbox.tomcat.net".
+func newRelyingParty(baseURL string) (*webauthn.RelyingParty, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	return &webauthn.RelyingParty{
+		ID:     u.Hostname(),
+		Name:   "Snippetbox",
+		Origin: u.Scheme + "://" + u.Host,
+	}, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// startServe runs app.serve for a server with the given handler on a random
+// local port, and returns the server's URL, a function which starts the
+// shutdown, and a channel which receives serve's result.
+func startServe(t *testing.T, app *application, handler http.Handler) (string, context.CancelFunc, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: handler}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	result := make(chan error, 1)
+	go func() {
+		result <- app.serve(ctx, listener{
+			name: "server",
+			srv:  srv,
+			listen: func() error {
+				return srv.Serve(ln)
+			},
+		})
+	}()
+
+	return "http://" + ln.Addr().String(), cancel, result
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	app.shutdownTimeout = 5 * time.Second
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	url, shutdown, result := startServe(t, app, handler)
+
+	// Start a slow request.
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	<-started
+
+	// And a background task.
+	var taskDone atomic.Bool
+	app.background(func() {
+		<-release
+		taskDone.Store(true)
+	})
+
+	shutdown()
+
+	// serve waits while the request is in flight.
+	select {
+	case err := <-result:
+		t.Fatalf("serve returned %v before the request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	resp := <-responses
+	assert.NilError(t, resp.err)
+	assert.Equal(t, resp.body, "done")
+
+	assert.NilError(t, <-result)
+	assert.Equal(t, taskDone.Load(), true)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	app.shutdownTimeout = 50 * time.Millisecond
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	url, shutdown, result := startServe(t, app, handler)
+
+	go http.Get(url)
+	<-started
+
+	shutdown()
+
+	err := <-result
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	app := newTestApplication(t)
+
+	listenErr := errors.New("address already in use")
+
+	err := app.serve(context.Background(), listener{
+		name: "server",
+		srv:  &http.Server{},
+		listen: func() error {
+			return listenErr
+		},
+	})
+	assert.Equal(t, err, listenErr)
+}
+
+func TestServeStopsOtherServersOnListenError(t *testing.T) {
+	app := newTestApplication(t)
+	app.shutdownTimeout = 5 * time.Second
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	stopped := make(chan error, 1)
+
+	listenErr := errors.New("address already in use")
+
+	err = app.serve(context.Background(),
+		listener{
+			name: "server",
+			srv:  srv,
+			listen: func() error {
+				err := srv.Serve(ln)
+				stopped <- err
+				return err
+			},
+		},
+		listener{
+			name: "redirect server",
+			srv:  &http.Server{},
+			listen: func() error {
+				return listenErr
+			},
+		},
+	)
+	assert.Equal(t, err, listenErr)
+
+	// The working server was shut down rather than left running.
+	select {
+	case err := <-stopped:
+		assert.Equal(t, err, http.ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("server is still running")
+	}
+}
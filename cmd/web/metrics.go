@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// appMetrics are the application's Prometheus metrics, served by GET
+// /metrics.
+//
+// Fields:
+//   - registry: Holds every metric, including the Go runtime's and the
+//     process's
+//   - handler: Gathers the metrics in the registry when scraped
+//   - requests, requestDuration: Requests handled, and how long they took,
+//     by route pattern (such as "GET /snippet/view/{id}") and status code
+//   - renderDuration: How long templates took to execute, by page
+//   - snippetsCreated: Snippets saved
+//   - logins: Users who logged in, by any method
+//   - failedLogins: Wrong passwords or two-factor codes, by login step
+type appMetrics struct {
+	registry        *prometheus.Registry
+	handler         http.Handler
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	renderDuration  *prometheus.HistogramVec
+	snippetsCreated prometheus.Counter
+	logins          prometheus.Counter
+	failedLogins    *prometheus.CounterVec
+}
+
+// The login steps counted by appMetrics.failedLogins.
+const (
+	loginStepPassword  = "password"
+	loginStepTwoFactor = "two_factor"
+)
+
+// unmatchedRoute is the route label of requests which didn't match a route,
+// so that scanning for random URLs doesn't create a series for each one.
+const unmatchedRoute = "unmatched"
+
+// newAppMetrics registers the application's metrics in a new registry, so
+// that tests don't share the global one. If db isn't nil, its connection
+// pool statistics are included too, as the go_sql_* metrics.
+func newAppMetrics(db *sql.DB) *appMetrics {
+	reg := prometheus.NewRegistry()
+	factory := promauto.With(reg)
+
+	m := &appMetrics{
+		registry: reg,
+		handler:  promhttp.HandlerFor(reg, promhttp.HandlerOpts{}),
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "HTTP requests handled, by route pattern and status code.",
+		}, []string{"route", "status"}),
+		requestDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "status"}),
+		renderDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_template_render_duration_seconds",
+			Help:    "Time taken to execute page templates, by page.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"page"}),
+		snippetsCreated: factory.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Snippets created.",
+		}),
+		logins: factory.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_logins_total",
+			Help: "Successful logins.",
+		}),
+		failedLogins: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_failed_logins_total",
+			Help: "Failed login attempts, by the step which failed (password or two_factor).",
+		}, []string{"step"}),
+	}
+
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if db != nil {
+		reg.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+	}
+
+	return m
+}
+
+// recordMetrics middleware counts each request and measures how long it
+// took, labelled with the route pattern it matched and its status code. It
+// reads the pattern from the request after the servemux has handled it, so
+// it must come after any middleware which replaces the request (such as
+// realIP) in the chain.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(sw.status)
+
+		app.metrics.requests.WithLabelValues(route, status).Inc()
+		app.metrics.requestDuration.WithLabelValues(route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricsHandler serves the metrics to a Prometheus scrape. If a metrics
+// token is configured, the request must send it in an "Authorization:
+// Bearer" header; otherwise it gets a 401 Unauthorized response.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.metricsToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.metricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			app.clientError(w, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	app.metrics.handler.ServeHTTP(w, r)
+}
+
+// metricsRoutes returns the handler for the separate metrics listener, which
+// only serves GET /metrics. Scrapes aren't logged, as they come every few
+// seconds.
+func (app *application) metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", app.metricsHandler)
+
+	return app.recoverPanic(mux)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// sampleCount returns the number of observations in the histogram with the
+// given label values.
+func sampleCount(t *testing.T, h *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	err := h.WithLabelValues(labels...).(prometheus.Metric).Write(&m)
+	assert.NilError(t, err)
+
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRecordMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.server.Close()
+
+	ts.get(t, "/ping")
+	ts.get(t, "/ping")
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/no/such/page")
+
+	tests := []struct {
+		name   string
+		route  string
+		status string
+		want   float64
+	}{
+		{name: "Route without parameters", route: "GET /ping", status: "200", want: 2},
+		{name: "Route with parameters", route: "GET /snippet/view/{id}", status: "200", want: 1},
+		{name: "Status code", route: "GET /snippet/view/{id}", status: "404", want: 1},
+		{name: "Unmatched", route: unmatchedRoute, status: "404", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, testutil.ToFloat64(app.metrics.requests.WithLabelValues(tt.route, tt.status)), tt.want)
+			assert.Equal(t, sampleCount(t, app.metrics.requestDuration, tt.route, tt.status), uint64(tt.want))
+		})
+	}
+
+	t.Run("Template render duration", func(t *testing.T) {
+		assert.Equal(t, sampleCount(t, app.metrics.renderDuration, "view.html"), uint64(1))
+	})
+}
+
+func TestBusinessMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.server.Close()
+
+	// A wrong password is counted as a failed login.
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "wrong")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/login", form)
+
+	assert.Equal(t, testutil.ToFloat64(app.metrics.failedLogins.WithLabelValues(loginStepPassword)), 1.0)
+	assert.Equal(t, testutil.ToFloat64(app.metrics.logins), 0.0)
+
+	ts.login(t, "alice@example.com")
+	assert.Equal(t, testutil.ToFloat64(app.metrics.logins), 1.0)
+
+	_, _, body = ts.get(t, "/snippet/create")
+	form = url.Values{}
+	form.Add("title", "O snail")
+	form.Add("content", "O snail\nClimb Mount Fuji,\nBut slowly, slowly!")
+	form.Add("expires", "7")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, testutil.ToFloat64(app.metrics.snippetsCreated), 1.0)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		onMain        bool
+		authorization string
+		wantCode      int
+	}{
+		{name: "Not served on the main listener", wantCode: http.StatusNotFound},
+		{name: "Missing token", token: "scrape-token", onMain: true, wantCode: http.StatusUnauthorized},
+		{name: "Wrong token", token: "scrape-token", onMain: true, authorization: "Bearer guess", wantCode: http.StatusUnauthorized},
+		{name: "Wrong scheme", token: "scrape-token", onMain: true, authorization: "Basic scrape-token", wantCode: http.StatusUnauthorized},
+		{name: "Valid token", token: "scrape-token", onMain: true, authorization: "Bearer scrape-token", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.metricsToken = tt.token
+			app.metricsOnMainListener = tt.onMain
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			app.routes().ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			if tt.wantCode == http.StatusOK {
+				assert.StringContains(t, rr.Body.String(), "# TYPE snippetbox_logins_total counter")
+			}
+		})
+	}
+
+	t.Run("Separate listener", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		// Requests to the main listener show up in the metrics.
+		app.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+		rr := httptest.NewRecorder()
+		app.metricsRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, rr.Code, http.StatusOK)
+		assert.StringContains(t, rr.Body.String(), `snippetbox_http_requests_total{route="GET /ping",status="200"} 1`)
+		assert.StringContains(t, rr.Body.String(), `snippetbox_http_request_duration_seconds_count{route="GET /ping",status="200"} 1`)
+		assert.StringContains(t, rr.Body.String(), "# TYPE go_goroutines gauge")
+	})
+}
+
+func TestDBStatsMetrics(t *testing.T) {
+	// Opening a handle doesn't connect, so no database is needed.
+	db, err := sql.Open("mysql", "web:pass@/snippetbox")
+	assert.NilError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(25)
+
+	m := newAppMetrics(db)
+
+	rr := httptest.NewRecorder()
+	m.handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.StringContains(t, rr.Body.String(), `go_sql_max_open_connections{db_name="snippetbox"} 25`)
+	assert.StringContains(t, rr.Body.String(), `go_sql_open_connections{db_name="snippetbox"} 0`)
+	assert.StringContains(t, rr.Body.String(), "# TYPE go_sql_wait_count_total counter")
+}
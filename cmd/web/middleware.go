@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"snippetbox.tomcat.net/internal/models"
 )
 
 // commonHeaders middleware sets various security-related headers on outgoing HTTP responses.
-func commonHeaders(next http.Handler) http.Handler {
+func (app *application) commonHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set Content-Security-Policy header to enhance security by restricting the sources of content that can be loaded on the page.
 		// This policy:
@@ -51,6 +58,16 @@ func commonHeaders(next http.Handler) http.Handler {
 			"0",
 		)
 
+		// When serving HTTPS, set the Strict-Transport-Security header so that
+		// browsers which have visited the site use HTTPS from then on, even if
+		// the user types an http:// URL or follows an old link.
+		if app.hstsMaxAge > 0 {
+			w.Header().Set(
+				"Strict-Transport-Security",
+				fmt.Sprintf("max-age=%d", int(app.hstsMaxAge.Seconds())),
+			)
+		}
+
 		// Set Server header to mask the Go server version, enhancing security and privacy.
 		// By setting it to 'Go', we obscure the exact version of the server, reducing the attack surface.
 		w.Header().Set(
@@ -63,7 +80,29 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
-// logRequest middleware logs details about each HTTP request received by the server.
+// realIP middleware finds the IP address of the client making the request
+// and stores it in the request context, for clientIP to return. When the
+// immediate peer is one of the trusted proxies (such as a load balancer),
+// the address comes from the header they set (see resolveClientIP);
+// otherwise forwarding headers are ignored, as anyone could have set them. It must come
+// before any middleware which uses the client's address, such as logRequest.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := peerIP(r)
+
+		peer, err := netip.ParseAddr(ip)
+		if err == nil && app.isTrustedProxy(peer) {
+			ip = app.resolveClientIP(r, peer.Unmap()).String()
+		}
+
+		ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// logRequest middleware logs details about each HTTP request received by the
+// server, then, once it's been handled, logs a line with the response's
+// status code, size in bytes and how long it took.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract details from the incoming request for logging purposes.
@@ -73,7 +112,7 @@ func (app *application) logRequest(next http.Handler) http.Handler {
 		//   - method: The HTTP method used (e.g., "GET", "POST")
 		//   - uri: The request URI path and query string
 		var (
-			ip     = r.RemoteAddr       // IP address of the client making the request
+			ip     = clientIP(r)        // IP address of the client making the request
 			proto  = r.Proto            // Protocol used for the request (e.g., "HTTP/1.1")
 			method = r.Method           // HTTP method used (e.g., "GET", "POST")
 			uri    = r.URL.RequestURI() // Request URI path and query string
@@ -85,13 +124,52 @@ func (app *application) logRequest(next http.Handler) http.Handler {
 		//   - The protocol used
 		//   - The HTTP method
 		//   - The request URI
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		app.logger.InfoContext(r.Context(), "received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
 
-		// Call the next handler in the chain with the modified response writer and request
-		next.ServeHTTP(w, r)
+		// Call the next handler in the chain, recording the response's status
+		// code and size as it's written.
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		app.logger.InfoContext(r.Context(), "completed request", "ip", ip, "method", method, "uri", uri,
+			"status", sw.status, "size", sw.size, "duration", time.Since(start))
 	})
 }
 
+// statusResponseWriter records the status code and size of the response
+// written through it, for logRequest, recordMetrics and trace.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints are followed by the
+	// real status.
+	if !sw.wroteHeader && status >= 200 {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	n, err := sw.ResponseWriter.Write(b)
+	sw.size += n
+	return n, err
+}
+
+// Unwrap returns the underlying response writer, so that
+// http.ResponseController can reach it.
+func (sw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
 // recoverPanic middleware recovers from any panic that occurs during the processing of a request.
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -143,13 +221,14 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 }
 
 // Create a NoSurf middlerware function which uses a customized CSRF cookie with
-// the Secure, Path and HttpOnly attributes set
-func noSurf(next http.Handler) http.Handler {
+// the Path and HttpOnly attributes set, and the Secure attribute set unless
+// the application is served over plain HTTP
+func (app *application) noSurf(next http.Handler) http.Handler {
 	csrHandler := nosurf.New(next)
 	csrHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
-		Secure:   true,
+		Secure:   app.secureCookies,
 	})
 
 	return csrHandler
@@ -159,8 +238,12 @@ func noSurf(next http.Handler) http.Handler {
 // by verifying the presence of a valid user ID in the session. It:
 // - Retrieves the authenticatedUserID from the session
 // - If no ID is found, continues to the next handler
-// - If an ID is found, checks if the user exists in the database
-// - If the user exists, adds authentication context to the request
+// - If an ID is found, checks the session hasn't been revoked (see
+// accountSessions) or been idle for too long and, if so, destroys it
+// - Otherwise fetches the user from the database, destroying the session if
+// their account has been disabled
+// - If the user exists, adds authentication, email verification, role and
+// password reset context to the request
 // - Handles database errors appropriately
 // - Continues to the next handler in the chain
 func (app *application) authenticate(next http.Handler) http.Handler {
@@ -175,25 +258,190 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Otherwise, we check to see if a user with that ID exists in out
-		// database.
-		exists, err := app.users.Exists(id)
+		// Check that the session hasn't been revoked from another device, and
+		// record that it's still in use. If it has been revoked, destroy what
+		// is left of it and treat the request as unauthenticated.
+		err := app.userSessions.Touch(r.Context(), app.sessionManager.Token(r.Context()), time.Now())
 		if err != nil {
-			app.serverError(w, r, err)
+			if errors.Is(err, models.ErrNoRecord) {
+				err = app.sessionManager.Destroy(r.Context())
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+				next.ServeHTTP(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
 			return
 		}
 
-		// If a matching user is found, we know that the request is
-		// coming from an authenticated user who exists in our database
-		// We create a new copy of the request
-		// (with an isAuthenticatedContextKey)
-		// value of true in the request context and assign it to r.
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+		// Sessions without "remember me" expire after a period of inactivity,
+		// unless the idle timeout is disabled (zero), in which case sessions
+		// which recorded their activity while it was enabled are left alone.
+		// The last activity time is only stored if it has moved on by at least
+		// a minute, to avoid writing the session on every request.
+		if lastActivity := app.sessionManager.GetInt64(r.Context(), "lastActivity"); lastActivity != 0 {
+			idle := time.Since(time.Unix(lastActivity, 0))
+
+			if app.sessionIdleTimeout > 0 && idle > app.sessionIdleTimeout {
+				err = app.userSessions.Delete(r.Context(), app.sessionManager.Token(r.Context()))
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+
+				err = app.sessionManager.Destroy(r.Context())
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if idle >= time.Minute {
+				app.sessionManager.Put(r.Context(), "lastActivity", time.Now().Unix())
+			}
+		}
+
+		// Otherwise, we fetch the user with that ID from our database. If
+		// there is no matching user (e.g. the account was deleted) we treat
+		// the request as unauthenticated.
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				next.ServeHTTP(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
 		}
 
+		// Disabling an account signs out its sessions, but in case one is
+		// missed (for example it was being created at the time), sessions of
+		// disabled users are ended here too.
+		if user.Disabled {
+			err = app.userSessions.Delete(r.Context(), app.sessionManager.Token(r.Context()))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			err = app.sessionManager.Destroy(r.Context())
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A matching user was found, so we know that the request is
+		// coming from an authenticated user who exists in our database.
+		// We create a new copy of the request with an
+		// isAuthenticatedContextKey value of true and the user's email
+		// verification status and role in the request context and assign it
+		// to r.
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, isEmailVerifiedContextKey, user.EmailVerified)
+		ctx = context.WithValue(ctx, userRoleContextKey, user.Role)
+		ctx = context.WithValue(ctx, passwordResetRequiredContextKey, user.PasswordResetRequired)
+		r = r.WithContext(ctx)
+
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requirePasswordChange middleware sends users who an administrator has
+// required to reset their password to the change password page, with a flash
+// message, until they have done so. It must be used after
+// requireAuthentication, and not on the change password page itself.
+func (app *application) requirePasswordChange(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isPasswordResetRequired(r) {
+			app.sessionManager.Put(r.Context(), "flash", "Please choose a new password to continue")
+			http.Redirect(w, r, "/account/password/update", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireVerifiedEmail middleware restricts routes to users who have verified
+// their email address. It must be used after requireAuthentication.
+//
+// The policy is controlled by app.requireEmailVerification:
+//   - If disabled, every authenticated user is let through
+//   - If enabled, unverified users are redirected to their account page (where
+//     they can request a new verification email) with a flash message
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.requireEmailVerification && !app.isEmailVerified(r) {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address to continue")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole returns middleware which restricts routes to users with one of
+// the given roles, responding 403 Forbidden to everyone else. It must be used
+// after requireAuthentication, so that unauthenticated users are sent to the
+// login page rather than refused. For example:
+//
+//	admin := protected.Append(app.requireRole(models.RoleAdmin))
+func (app *application) requireRole(roles ...models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(roles, app.userRole(r)) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimit returns middleware which limits how often the routes it wraps
+// can be requested, using a token bucket per authenticated user or, for
+// anonymous requests, per client IP address. Each use has a name, so that
+// different route groups have separate buckets. For example:
+//
+//	signupLimit := app.rateLimit("signup", models.RateLimit{Requests: 10, Per: time.Hour, Burst: 5})
+//
+// It must be used after authenticate. Requests over the limit get a 429 Too
+// Many Requests response, with a Retry-After header giving the number of
+// seconds to wait. If the rate limiter's store fails, the error is logged and
+// the request is allowed, so that an outage of the store doesn't take the
+// site down with it.
+func (app *application) rateLimit(name string, limit models.RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := fmt.Sprintf("%s:ip:%s", name, clientIP(r))
+			if app.isAuthenticated(r) {
+				key = fmt.Sprintf("%s:user:%d", name, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+			}
+
+			wait, err := app.rateLimiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				if errors.Is(err, models.ErrRateLimited) {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					app.clientError(w, http.StatusTooManyRequests)
+					return
+				}
+
+				app.logger.ErrorContext(r.Context(), "rate limiter failed", "key", key, "error", err.Error())
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"snippetbox.tomcat.net/internal/assert"
+	"snippetbox.tomcat.net/internal/models"
 )
 
 func TestCommonHeaders(t *testing.T) {
@@ -29,7 +32,8 @@ func TestCommonHeaders(t *testing.T) {
 	// commonHeaders *returns* a http.handler we can call its ServeHTTP()
 	// method, passing in the http.Request to
 	// execute it
-	commonHeaders(next).ServeHTTP(rr, r)
+	app := &application{}
+	app.commonHeaders(next).ServeHTTP(rr, r)
 
 	// Call the Result() method on the http.ResponseRecorder to get the results
 	// of the test
@@ -56,6 +60,9 @@ func TestCommonHeaders(t *testing.T) {
 	expectedValue = "Go"
 	assert.Equal(t, rs.Header.Get("Server"), expectedValue)
 
+	// Check that Strict-Transport-Security isn't set when HSTS is disabled
+	assert.Equal(t, rs.Header.Get("Strict-Transport-Security"), "")
+
 	// Check that the middleware has correctly called the next handler in line
 	// and the response status code and body are as expected.
 	assert.Equal(t, rs.StatusCode, http.StatusOK)
@@ -70,3 +77,286 @@ func TestCommonHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestCommonHeadersHSTS(t *testing.T) {
+	app := &application{hstsMaxAge: 365 * 24 * time.Hour}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	app.commonHeaders(next).ServeHTTP(rr, r)
+
+	assert.Equal(t, rr.Header().Get("Strict-Transport-Security"), "max-age=31536000")
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     models.Role
+		allowed  []models.Role
+		wantCode int
+	}{
+		{
+			name:     "Admin allowed",
+			role:     models.RoleAdmin,
+			allowed:  []models.Role{models.RoleAdmin},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Moderator allowed",
+			role:     models.RoleModerator,
+			allowed:  []models.Role{models.RoleModerator, models.RoleAdmin},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Moderator refused",
+			role:     models.RoleModerator,
+			allowed:  []models.Role{models.RoleAdmin},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "User refused",
+			role:     models.RoleUser,
+			allowed:  []models.Role{models.RoleModerator, models.RoleAdmin},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Unauthenticated",
+			allowed:  []models.Role{models.RoleAdmin},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.role != "" {
+				r = r.WithContext(context.WithValue(r.Context(), userRoleContextKey, tt.role))
+			}
+
+			app.requireRole(tt.allowed...)(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+
+	clock := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+	limiter := models.NewMemoryRateLimiter()
+	limiter.Now = func() time.Time { return clock }
+	app.rateLimiter = limiter
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	// Two requests a minute, in bursts of up to two.
+	handler := app.rateLimit("test", models.RateLimit{Requests: 2, Per: time.Minute, Burst: 2})(next)
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		wantCode       int
+		wantRetryAfter string
+	}{
+		{
+			name:       "First request",
+			remoteAddr: "192.0.2.1:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "Second request",
+			remoteAddr: "192.0.2.1:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:           "Over the limit",
+			remoteAddr:     "192.0.2.1:5678",
+			wantCode:       http.StatusTooManyRequests,
+			wantRetryAfter: "30",
+		},
+		{
+			name:       "Another IP address",
+			remoteAddr: "192.0.2.2:1234",
+			wantCode:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodPost, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+
+			handler.ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			assert.Equal(t, rr.Header().Get("Retry-After"), tt.wantRetryAfter)
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	app := newTestApplication(t)
+
+	var err error
+	app.trustedProxies, err = parseTrustedProxies("10.0.0.0/8, 2001:db8::/32, 192.0.2.10")
+	assert.NilError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(clientIP(r)))
+	})
+
+	// Unless proxyHeader is set, the proxies set X-Forwarded-For.
+	tests := []struct {
+		name          string
+		proxyHeader   string
+		remoteAddr    string
+		forwarded     string
+		xForwardedFor string
+		want          string
+	}{
+		{
+			name:       "Direct",
+			remoteAddr: "203.0.113.7:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:          "Untrusted peer",
+			remoteAddr:    "203.0.113.7:1234",
+			xForwardedFor: "198.51.100.1",
+			want:          "203.0.113.7",
+		},
+		{
+			name:       "Trusted peer without headers",
+			remoteAddr: "10.0.0.2:1234",
+			want:       "10.0.0.2",
+		},
+		{
+			name:          "X-Forwarded-For",
+			remoteAddr:    "10.0.0.2:1234",
+			xForwardedFor: "203.0.113.7",
+			want:          "203.0.113.7",
+		},
+		{
+			name:          "Spoofed X-Forwarded-For",
+			remoteAddr:    "10.0.0.2:1234",
+			xForwardedFor: "198.51.100.1, 203.0.113.7, 10.0.0.3",
+			want:          "203.0.113.7",
+		},
+		{
+			name:          "Single trusted address",
+			remoteAddr:    "192.0.2.10:1234",
+			xForwardedFor: "203.0.113.7",
+			want:          "203.0.113.7",
+		},
+		{
+			name:          "Only trusted proxies",
+			remoteAddr:    "10.0.0.2:1234",
+			xForwardedFor: "10.0.0.5, 10.0.0.3",
+			want:          "10.0.0.5",
+		},
+		{
+			name:          "Unparsable entry",
+			remoteAddr:    "10.0.0.2:1234",
+			xForwardedFor: "unknown, 10.0.0.3",
+			want:          "10.0.0.3",
+		},
+		{
+			name:          "Spoofed Forwarded",
+			remoteAddr:    "10.0.0.2:1234",
+			forwarded:     "for=198.51.100.1",
+			xForwardedFor: "203.0.113.7",
+			want:          "203.0.113.7",
+		},
+		{
+			name:        "Forwarded",
+			proxyHeader: "forwarded",
+			remoteAddr:  "10.0.0.2:1234",
+			forwarded:   `for=203.0.113.7;proto=https, for="[2001:db8:cafe::17]:4711"`,
+			want:        "203.0.113.7",
+		},
+		{
+			name:          "Spoofed X-Forwarded-For with Forwarded",
+			proxyHeader:   "forwarded",
+			remoteAddr:    "10.0.0.2:1234",
+			forwarded:     "for=203.0.113.7",
+			xForwardedFor: "198.51.100.1",
+			want:          "203.0.113.7",
+		},
+		{
+			name:          "Forwarded missing",
+			proxyHeader:   "forwarded",
+			remoteAddr:    "10.0.0.2:1234",
+			xForwardedFor: "198.51.100.1",
+			want:          "10.0.0.2",
+		},
+		{
+			name:        "Forwarded IPv6",
+			proxyHeader: "forwarded",
+			remoteAddr:  "[2001:db8::1]:443",
+			forwarded:   `For="[2001:db9::17]"`,
+			want:        "2001:db9::17",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.proxyHeader = tt.proxyHeader
+
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+			if tt.xForwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+			}
+
+			app.realIP(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Body.String(), tt.want)
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies("")
+	assert.NilError(t, err)
+	assert.Equal(t, len(prefixes), 0)
+
+	for _, s := range []string{"10.0.0.0/33", "proxy.example.com", "10.0.0.1/8/8"} {
+		_, err := parseTrustedProxies(s)
+		if err == nil {
+			t.Errorf("parseTrustedProxies(%q): got nil error", s)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/justinas/alice"
+	"snippetbox.tomcat.net/internal/models"
 	"snippetbox.tomcat.net/ui"
 )
 
@@ -26,13 +28,31 @@ func (app *application) routes() http.Handler {
 	// Add a new GET /ping route.
 	mux.HandleFunc("GET /ping", ping)
 
+	// Liveness and readiness probes, for orchestrators and load balancers.
+	mux.HandleFunc("GET /healthz", app.healthz)
+	mux.HandleFunc("GET /readyz", app.readyz)
+
+	// Prometheus metrics, unless they're served by a separate listener.
+	// They're only served here if a token is configured, so that they
+	// aren't public.
+	if app.metricsOnMainListener {
+		mux.HandleFunc("GET /metrics", app.metricsHandler)
+	}
+
 	// Unprotected application routes using the "dynamic" middleware chain.
 	// Create a middleware chain containing the session management middleware.
 	// Specifically, this will:
 	// - LoadAndSave session data for the current request.
 	// - noSurf function middleware to protect from CSRD attack
 	// - app.authenticate check if it is authenticated
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, app.noSurf, app.authenticate)
+
+	// Rate limits for routes which create accounts or content, to slow down
+	// spammers and scripted abuse. Each allows a burst of requests, then
+	// refills steadily. Signups are limited per IP address; the content
+	// routes are limited per user.
+	signupLimit := app.rateLimit("signup", models.RateLimit{Requests: 5, Per: time.Hour, Burst: 10})
+	contentLimit := app.rateLimit("content", models.RateLimit{Requests: 30, Per: time.Hour, Burst: 10})
 
 	// Register the dynamic routes (those that require session management) using
 	// the dynamic middleware chain.
@@ -48,38 +68,117 @@ func (app *application) routes() http.Handler {
 
 	// User signup routes
 	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.userSignup))
-	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.userSignupPost))
+	mux.Handle("POST /user/signup", dynamic.Append(signupLimit).ThenFunc(app.userSignupPost))
 
 	// User login routes
 	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
 	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
 
+	// Second login step for users with two-factor authentication enabled
+	mux.Handle("GET /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	mux.Handle("POST /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+
+	// Single sign-on with an OpenID Connect identity provider
+	mux.Handle("GET /user/login/oidc", dynamic.ThenFunc(app.userLoginOIDC))
+	mux.Handle("GET /user/login/oidc/callback", dynamic.ThenFunc(app.userLoginOIDCCallback))
+
+	// Logging in with a passkey instead of a password
+	mux.Handle("POST /user/login/passkey/begin", dynamic.ThenFunc(app.userLoginPasskeyBeginPost))
+	mux.Handle("POST /user/login/passkey/finish", dynamic.ThenFunc(app.userLoginPasskeyFinishPost))
+
+	// Email verification link sent after signup
+	mux.Handle("GET /user/verify", dynamic.ThenFunc(app.userVerify))
+
+	// Authenticated-only routes which users can still reach when an
+	// administrator has required them to change their password.
+	authenticated := dynamic.Append(app.requireAuthentication)
+
 	// Protected (authenticated-only) application routes, using a new "protected"
 	// middleware chain which includes the requireAuthentication middleware.
-	protected := dynamic.Append(app.requireAuthentication)
+	protected := authenticated.Append(app.requirePasswordChange)
+
+	// Routes which, depending on the email verification policy, are only
+	// available to users who have verified their email address.
+	verified := protected.Append(app.requireVerifiedEmail)
 
 	// Create a new snippet form
-	mux.Handle("GET /snippet/create", protected.ThenFunc(app.snippetCreate))
+	mux.Handle("GET /snippet/create", verified.ThenFunc(app.snippetCreate))
 
 	// Post a new snippet
-	mux.Handle("POST /snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	mux.Handle("POST /snippet/create", verified.Append(contentLimit).ThenFunc(app.snippetCreatePost))
+
+	// Report an abusive snippet to the moderators
+	mux.Handle("POST /snippet/report", verified.Append(contentLimit).ThenFunc(app.snippetReportPost))
+
+	// Resend the email verification link
+	mux.Handle("POST /user/verify/resend", protected.ThenFunc(app.userVerifyResendPost))
 
 	// User logout route
-	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
+	mux.Handle("POST /user/logout", authenticated.ThenFunc(app.userLogoutPost))
 
 	// GET user account view
 	mux.Handle("GET /account/view", protected.ThenFunc(app.accountView))
 
-	mux.Handle("GET /account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
+	mux.Handle("GET /account/password/update", authenticated.ThenFunc(app.accountPasswordUpdate))
 
-	mux.Handle("POST /account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
+	mux.Handle("POST /account/password/update", authenticated.ThenFunc(app.accountPasswordUpdatePost))
+
+	// Two-factor authentication settings
+	mux.Handle("GET /account/2fa", protected.ThenFunc(app.accountTwoFactor))
+	mux.Handle("POST /account/2fa", protected.ThenFunc(app.accountTwoFactorPost))
+	mux.Handle("GET /account/2fa/qr.png", protected.ThenFunc(app.accountTwoFactorQR))
+	mux.Handle("POST /account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisablePost))
+
+	// Active sessions, with remote sign-out
+	mux.Handle("GET /account/sessions", protected.ThenFunc(app.accountSessions))
+	mux.Handle("POST /account/sessions/revoke", protected.ThenFunc(app.accountSessionRevokePost))
+	mux.Handle("POST /account/sessions/revoke-others", protected.ThenFunc(app.accountSessionRevokeOthersPost))
+
+	// Passkey management
+	mux.Handle("GET /account/passkeys", protected.ThenFunc(app.accountPasskeys))
+	mux.Handle("POST /account/passkeys/register/begin", protected.ThenFunc(app.accountPasskeyRegisterBeginPost))
+	mux.Handle("POST /account/passkeys/register/finish", protected.ThenFunc(app.accountPasskeyRegisterFinishPost))
+	mux.Handle("POST /account/passkeys/delete", protected.ThenFunc(app.accountPasskeyDeletePost))
+
+	// Moderation queue, available to moderators and administrators.
+	moderator := protected.Append(app.requireRole(models.RoleModerator, models.RoleAdmin))
+
+	mux.Handle("GET /moderation", moderator.ThenFunc(app.moderationQueue))
+	mux.Handle("POST /moderation/dismiss", moderator.ThenFunc(app.moderationDismissPost))
+	mux.Handle("POST /moderation/takedown", moderator.ThenFunc(app.moderationTakeDownPost))
+
+	// Administration routes, only available to administrators.
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+
+	mux.Handle("GET /admin", admin.ThenFunc(app.adminDashboard))
+	mux.Handle("GET /admin/users", admin.ThenFunc(app.adminUsers))
+	mux.Handle("POST /admin/users/disable", admin.ThenFunc(app.adminUserDisablePost))
+	mux.Handle("POST /admin/users/reset-password", admin.ThenFunc(app.adminUserPasswordResetPost))
+	mux.Handle("GET /admin/snippets", admin.ThenFunc(app.adminSnippets))
+	mux.Handle("POST /admin/snippets/delete", admin.ThenFunc(app.adminSnippetDeletePost))
+	mux.Handle("GET /admin/audit", admin.ThenFunc(app.adminAudit))
+	mux.Handle("GET /admin/audit/export", admin.ThenFunc(app.adminAuditExport))
+	mux.Handle("GET /admin/database", admin.ThenFunc(app.adminDatabase))
 
 	// Create a middleware chain containing our 'standard' middleware
 	// which will be applied to every request our application receives.
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	// requestID comes first, so that every log line for the request carries
+	// its ID. trace and recordMetrics must come after realIP, and
+	// recordMetrics after trace, as each of those replaces the request and
+	// they need to see the route pattern the servemux sets on it. trace comes
+	// before logRequest, so that request logs carry the trace ID.
+	standard := alice.New(app.requestID, app.recoverPanic, app.realIP, app.trace, app.recordMetrics, app.logRequest, app.commonHeaders)
 
 	// Wrap the servemux with the standard middleware chain. So any HTTP
 	// requests coming in will be subject to the middleware chain before being
 	// passed to the servemux.
 	return standard.Then(mux)
 }
+
+// redirectRoutes returns the handler for the plain HTTP listener, which
+// redirects every request to HTTPS.
+func (app *application) redirectRoutes() http.Handler {
+	standard := alice.New(app.requestID, app.recoverPanic, app.realIP, app.logRequest)
+
+	return standard.ThenFunc(app.redirectToHTTPS)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"html/template"
 	"io/fs"
 	"path/filepath"
@@ -17,8 +18,16 @@ import (
 // - Form: A generic type to hold form data for processing and validation
 // - Flash: A string to display temporary messages to the user
 // - IsAuthenticated: Boolean indicating if the user is authenticated
+// - Role: The authenticated user's role, empty if not authenticated
 // - CSRFToken: Cross-Site Request Forgery token for form security
 // - User: The currently authenticated user's data
+// - TwoFactor: Two-factor authentication settings for the account pages
+// - Sessions: The user's logged-in sessions for the sessions page
+// - Passkeys: The user's passkeys for the passkeys page
+// - Admin: Data for the admin area's pages
+// - CanReport: Whether the user can report the snippet being viewed
+// - ModerationQueue: Reported snippets for the moderation queue page
+// - SSOName: Name of the single sign-on provider, empty if it's not configured
 type templateData struct {
 	CurrentYear     int // The current year for copyright information.
 	Snippet         models.Snippet
@@ -26,8 +35,56 @@ type templateData struct {
 	Form            any
 	Flash           string
 	IsAuthenticated bool
+	Role            models.Role
 	CSRFToken       string
 	User            models.User
+	TwoFactor       twoFactorData
+	Sessions        sessionsData
+	Passkeys        []models.Passkey
+	Admin           adminData
+	CanReport       bool
+	ModerationQueue []models.ReportedSnippet
+	SSOName         string
+}
+
+// sessionsData holds the data for the active sessions page:
+// - List: The user's sessions, most recently used first
+// - CurrentID: The ID of the session making the request
+type sessionsData struct {
+	List      []models.UserSession
+	CurrentID int
+}
+
+// adminData holds the data for the admin area's pages:
+// - UserCount, LiveSnippets, ExpiredSnippets: Counts for the dashboard
+// - Users: A page of users for the users list
+// - Snippets: A page of snippets for the snippets list
+// - AuditEvents: A page of events for the audit log
+// - Metadata: Pagination details for the list
+// - Search: The search text the list was filtered by
+// - DBStats: The database connection pool's statistics
+type adminData struct {
+	UserCount       int
+	LiveSnippets    int
+	ExpiredSnippets int
+	Users           []models.User
+	Snippets        []models.Snippet
+	AuditEvents     []models.AuditEvent
+	Metadata        models.Metadata
+	Search          string
+	DBStats         sql.DBStats
+}
+
+// twoFactorData holds the data for the two-factor authentication pages:
+// - Enabled: Whether the user has completed enrolment
+// - Secret: The base32 secret, for users who can't scan the QR code
+// - URI: The otpauth:// provisioning URI encoded in the QR code
+// - RecoveryCodes: Recovery codes, only set immediately after enrolment
+type twoFactorData struct {
+	Enabled       bool
+	Secret        string
+	URI           string
+	RecoveryCodes []string
 }
 
 // newTemplateCache initializes a template cache by parsing all HTML templates from the ui/html directory.
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
@@ -13,7 +16,12 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"snippetbox.tomcat.net/internal/mailer"
+	"snippetbox.tomcat.net/internal/models"
 	"snippetbox.tomcat.net/internal/models/mocks"
+	"snippetbox.tomcat.net/internal/scanner"
+	"snippetbox.tomcat.net/internal/tokens"
+	"snippetbox.tomcat.net/internal/webauthn"
 )
 
 // newTestApplication initializes an application instance for testing, injecting
@@ -34,21 +42,88 @@ func newTestApplication(t *testing.T) *application {
 	// Uses secure cookies and a 12-hour lifetime to match production-like behavior
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Cookie.Persist = false
 	sessionManager.Cookie.Secure = true
 
 	return &application{
 		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
 		snippets:       &mocks.SnippetModel{}, // Now compatible via interface
 		users:          &mocks.UserModel{},    // Now compatible via interface
+		twoFactor:      &mocks.TwoFactorModel{},
+		loginThrottle:  models.NewLoginThrottle(models.DefaultAccountPolicy, models.DefaultIPPolicy),
+		identities:     &mocks.IdentityModel{},
+		userSessions:   &mocks.UserSessionModel{},
+		passkeys:       &mocks.PasskeyModel{},
+		auditLog:       &mocks.AuditEventModel{},
+		reports:        &mocks.ReportModel{},
+		scanner:        scanner.Default(),
+		rateLimiter:    models.NewMemoryRateLimiter(),
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         &mailer.Memory{}, // Records emails so tests can inspect them
+		tokens:         tokens.New([]byte("0123456789abcdef0123456789abcdef")),
+		baseURL:        "https://snippetbox.example.com",
+		secureCookies:  true,
+		metrics:        newAppMetrics(nil),
+		db:             &stubDB{},
+		webauthn: &webauthn.RelyingParty{
+			ID:     "snippetbox.example.com",
+			Name:   "Snippetbox",
+			Origin: "https://snippetbox.example.com",
+		},
+
+		rememberMeLifetime:       30 * 24 * time.Hour,
+		sessionIdleTimeout:       2 * time.Hour,
+		proxyHeader:              "x-forwarded-for",
+		requireEmailVerification: true,
+		reportThreshold:          3,
+		readinessTimeout:         time.Second,
 	}
 }
 
-// Define a custom testServer type which embeds a httptest.Server instance.
+// stubDB stands in for the database connection pool in readiness checks and
+// pool statistics. If block is set, pings wait until they're cancelled;
+// otherwise the first failures pings return err, and the rest succeed (all
+// of them return err if failures is 0).
+type stubDB struct {
+	err      error
+	block    bool
+	failures int
+	pings    int
+}
+
+func (db *stubDB) PingContext(ctx context.Context) error {
+	db.pings++
+	if db.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if db.failures > 0 && db.pings > db.failures {
+		return nil
+	}
+	return db.err
+}
+
+func (db *stubDB) Stats() sql.DBStats {
+	return sql.DBStats{
+		MaxOpenConnections: 25,
+		OpenConnections:    3,
+		InUse:              1,
+		Idle:               2,
+		WaitCount:          7,
+		WaitDuration:       1500 * time.Millisecond,
+		MaxIdleClosed:      4,
+		MaxIdleTimeClosed:  5,
+		MaxLifetimeClosed:  6,
+	}
+}
+
+// Define a custom testServer type which embeds a httptest.Server instance,
+// along with the client (browser) used to make requests to it.
 type testServer struct {
 	server *httptest.Server
+	client *http.Client
 }
 
 // Create a newTestServer helper which initializes and returns a new httptest.Server
@@ -59,30 +134,44 @@ func newTestServer(t *testing.T, h http.Handler) *testServer {
 	// creates a TLS certificate and key that are used when serving HTTPS requests.
 	ts := httptest.NewTLSServer(h)
 
+	return &testServer{server: ts, client: newTestClient(t, ts)}
+}
+
+// newBrowser returns a testServer for the same server which uses a separate
+// client with its own cookies, like a second browser or device.
+func (ts *testServer) newBrowser(t *testing.T) *testServer {
+	return &testServer{server: ts.server, client: newTestClient(t, ts.server)}
+}
+
+// newTestClient returns a client which trusts the test server's TLS
+// certificate, stores cookies and doesn't follow redirects.
+func newTestClient(t *testing.T, ts *httptest.Server) *http.Client {
 	// Initialize a new cookie jar
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// Add the cookie jar to the test server client. Any response cookies will
-	// now be stored and sent with subsequent requests when using this client
-	ts.Client().Jar = jar
+	return &http.Client{
+		Transport: ts.Client().Transport,
 
-	// Disable redirect following. This means our client will not follow
-	// redirects from the test server.
-	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	}
+		// Any response cookies will be stored in the jar and sent with
+		// subsequent requests when using this client
+		Jar: jar,
 
-	return &testServer{ts}
+		// Disable redirect following. This means our client will not follow
+		// redirects from the test server.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
 }
 
 // Implement a get() method on our custom testServer type. This makes
 // a GET request to a given url path on the test server, and returns the response
 // body, status code and server error.
 func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, string) {
-	rs, err := ts.server.Client().Get(ts.server.URL + urlPath)
+	rs, err := ts.client.Get(ts.server.URL + urlPath)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -102,7 +191,7 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 // final parameter to this method is a url.Values object which can contain any
 // form data that you want to send in the request body
 func (ts *testServer) postForm(t *testing.T, usrlPath string, form url.Values) (int, http.Header, string) {
-	rs, err := ts.server.Client().PostForm(ts.server.URL+usrlPath, form)
+	rs, err := ts.client.PostForm(ts.server.URL+usrlPath, form)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -118,3 +207,52 @@ func (ts *testServer) postForm(t *testing.T, usrlPath string, form url.Values) (
 	// Return the response status, headers and body
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// postJSON sends a POST request with data encoded as the JSON body, and the
+// CSRF token in the X-CSRF-Token header as the application's scripts do.
+func (ts *testServer) postJSON(t *testing.T, urlPath, csrfToken string, data any) (int, http.Header, string) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.server.URL+urlPath, bytes.NewReader(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", csrfToken)
+
+	rs, err := ts.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = bytes.TrimSpace(body)
+
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+// login is a helper which logs in to the test server as the user with the
+// given email address and the mock password "pa$$word".
+func (ts *testServer) login(t *testing.T, email string) {
+	t.Helper()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s: got status %d", email, code)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"snippetbox.tomcat.net/internal/config"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig returns the TLS configuration for the HTTPS server. It
+// prefers modern, secure elliptic curves for key exchange.
+//
+// If certManager isn't nil, certificates come from it rather than from
+// files, and the server answers the ACME CA's tls-alpn-01 challenges.
+func newTLSConfig(certManager *autocert.Manager) *tls.Config {
+	tlsConfig := &tls.Config{
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+
+	if certManager != nil {
+		tlsConfig.GetCertificate = certManager.GetCertificate
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+
+	return tlsConfig
+}
+
+// newCertManager returns a manager which obtains certificates for the
+// configured host names from an ACME CA such as Let's Encrypt, and renews
+// them before they expire.
+//
+// Parameters:
+//   - cfg: The TLS configuration, with ACMEHosts set
+//   - cache: Where certificates and the ACME account key are kept, such as
+//     autocert.DirCache or models.CertCacheModel
+//
+// The manager agrees to the CA's terms of service. Certificates are
+// requested on the first TLS handshake for each host name, and only for the
+// configured names, so that clients can't make the server request
+// certificates for arbitrary names.
+//
+// Error Handling:
+//   - cfg.ACMECARoots can't be read or holds no certificates: an error
+func newCertManager(cfg config.TLSConfig, cache autocert.Cache) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+
+	// A test CA such as Pebble serves its API with a certificate from its
+	// own root, which must be trusted explicitly.
+	if cfg.ACMECARoots != "" {
+		pemCerts, err := os.ReadFile(cfg.ACMECARoots)
+		if err != nil {
+			return nil, err
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ACMECARoots)
+		}
+
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEHosts()...),
+		Cache:      cache,
+		Email:      cfg.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// certReloader serves a certificate loaded from a pair of files, and
+// reloads it when an external agent (such as cert-manager or a cron job)
+// replaces the files, so that rotated certificates are used without a
+// restart. It is safe for concurrent use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	// cert is the certificate being served. It's swapped atomically, so
+	// handshakes never see a partly updated certificate.
+	cert atomic.Pointer[tls.Certificate]
+
+	// mu serialises reloads. loaded is the state of the files when cert
+	// was loaded. failing is true if the last reload failed, and failed is
+	// the state of the files then, so that a broken pair is only reported
+	// once.
+	mu      sync.Mutex
+	loaded  fileStamp
+	failing bool
+	failed  fileStamp
+}
+
+// fileStamp identifies a version of the certificate and key files by their
+// modification times and sizes.
+type fileStamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// newCertReloader loads the certificate and key, returning an error if
+// they can't be loaded, so that the server doesn't start without a
+// certificate.
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+
+	stamp, err := c.stamp()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cert.Store(&cert)
+	c.loaded = stamp
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, for use as
+// tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// stamp returns the current state of the certificate and key files.
+func (c *certReloader) stamp() (fileStamp, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	return fileStamp{
+		certMod:  certInfo.ModTime(),
+		keyMod:   keyInfo.ModTime(),
+		certSize: certInfo.Size(),
+		keySize:  keyInfo.Size(),
+	}, nil
+}
+
+// reload loads the certificate and key again if either file has changed.
+//
+// Flow:
+// 1. Check whether the files have changed since the certificate was loaded
+// 2. Load and parse the new pair
+// 3. Swap it in, and log the new certificate's expiry
+//
+// Error Handling:
+//   - The files can't be read, or don't hold a matching certificate and key
+//     (for example, because only one has been replaced so far): the error is
+//     logged, once for each version of the files, and returned. The old
+//     certificate is kept, and the next reload tries again.
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stamp, err := c.stamp()
+	if err == nil {
+		if stamp == c.loaded {
+			return nil
+		}
+
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err == nil {
+			c.cert.Store(&cert)
+			c.loaded = stamp
+			c.failing = false
+			c.logger.Info("reloaded TLS certificate", "cert", c.certFile, "expires", cert.Leaf.NotAfter)
+			return nil
+		}
+	}
+
+	if !c.failing || stamp != c.failed {
+		c.logger.Error("failed to reload TLS certificate; still using the old one", "cert", c.certFile, "error", err.Error())
+	}
+	c.failing = true
+	c.failed = stamp
+	return err
+}
+
+// watch checks the files for changes every interval until ctx is
+// cancelled.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reload()
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/acmetest"
+	"snippetbox.tomcat.net/internal/assert"
+	"snippetbox.tomcat.net/internal/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeHost is the host name certificates are requested for in tests.
+const acmeHost = "snippetbox.test"
+
+// newTestCertManager returns a cert manager which gets certificates for
+// acmeHost from ca, trusting the CA's own certificate through the
+// -acme-ca-roots setting as it would Pebble's.
+func newTestCertManager(t *testing.T, ca *acmetest.Server) *autocert.Manager {
+	roots := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default().TLS
+	cfg.ACMEDomains = acmeHost
+	cfg.ACMEDirectory = ca.DirectoryURL()
+	cfg.ACMECARoots = roots
+
+	certManager, err := newCertManager(cfg, autocert.DirCache(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certManager
+}
+
+// listenLocal listens on a random local port, returning the listener and
+// its port.
+func listenLocal(t *testing.T) (net.Listener, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln, port
+}
+
+// acmeClient returns a client which connects to addr for every request and
+// trusts certificates issued by ca.
+func acmeClient(ca *acmetest.Server, addr string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+			TLSClientConfig: &tls.Config{RootCAs: ca.Roots()},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 30 * time.Second,
+	}
+}
+
+func TestACMECertificates(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+	}{
+		{name: "TLS-ALPN challenge", challenge: acmetest.ChallengeTLSALPN},
+		{name: "HTTP challenge", challenge: acmetest.ChallengeHTTP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := acmetest.NewServer()
+			defer ca.Close()
+			ca.ChallengeTypes = []string{tt.challenge}
+			ca.Resolve(acmeHost, "127.0.0.1")
+
+			app := newTestApplication(t)
+			app.shutdownTimeout = 5 * time.Second
+			app.baseURL = "https://" + acmeHost
+			certManager := newTestCertManager(t, ca)
+
+			// Run the HTTPS server and the HTTP redirect server, as main
+			// does with ACME enabled.
+			tlsLn, tlsPort := listenLocal(t)
+			httpLn, httpPort := listenLocal(t)
+			ca.TLSPort = tlsPort
+			ca.HTTPPort = httpPort
+
+			srv := &http.Server{Handler: app.routes(), TLSConfig: newTLSConfig(certManager)}
+			redirectSrv := &http.Server{Handler: certManager.HTTPHandler(app.redirectRoutes())}
+
+			ctx, shutdown := context.WithCancel(context.Background())
+			result := make(chan error, 1)
+			go func() {
+				result <- app.serve(ctx,
+					listener{name: "server", srv: srv, listen: func() error { return srv.ServeTLS(tlsLn, "", "") }},
+					listener{name: "redirect server", srv: redirectSrv, listen: func() error { return redirectSrv.Serve(httpLn) }},
+				)
+			}()
+			defer func() {
+				shutdown()
+				assert.NilError(t, <-result)
+			}()
+
+			client := acmeClient(ca, tlsLn.Addr().String())
+
+			// The first request gets a certificate from the CA, which the
+			// client trusts.
+			for i := 0; i < 2; i++ {
+				resp, err := client.Get("https://" + acmeHost + "/ping")
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				assert.Equal(t, resp.StatusCode, http.StatusOK)
+				assert.Equal(t, string(body), "OK")
+				assert.Equal(t, resp.TLS.PeerCertificates[0].Subject.CommonName, acmeHost)
+				assert.Equal(t, resp.TLS.PeerCertificates[0].Issuer.CommonName, "acmetest root CA")
+			}
+
+			// The second request reused the certificate.
+			assert.Equal(t, ca.Issued(), 1)
+
+			// The plain HTTP listener still redirects other requests.
+			resp, err := acmeClient(ca, httpLn.Addr().String()).Get("http://" + acmeHost + "/about")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			assert.Equal(t, resp.StatusCode, http.StatusMovedPermanently)
+			assert.Equal(t, resp.Header.Get("Location"), "https://"+acmeHost+"/about")
+		})
+	}
+}
+
+func TestACMEUnknownHost(t *testing.T) {
+	ca := acmetest.NewServer()
+	defer ca.Close()
+	ca.Resolve("other.test", "127.0.0.1")
+
+	certManager := newTestCertManager(t, ca)
+
+	// Certificates are only requested for the configured host names.
+	_, err := certManager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.test"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, ca.Issued(), 0)
+}
+
+func TestNewCertManagerCARoots(t *testing.T) {
+	cfg := config.Default().TLS
+	cfg.ACMEDomains = acmeHost
+	cfg.ACMECARoots = filepath.Join(t.TempDir(), "empty.pem")
+
+	err := os.WriteFile(cfg.ACMECARoots, []byte("not a certificate"), 0o600)
+	assert.NilError(t, err)
+
+	_, err = newCertManager(cfg, autocert.DirCache(t.TempDir()))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.StringContains(t, err.Error(), "no certificates found")
+}
+
+// writeCertPair writes a self-signed certificate for commonName and its
+// private key to certFile and keyFile, as a certificate agent would.
+func writeCertPair(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification times of the files forward, so that a
+// rewrite is noticed even if it happened within the file system's timestamp
+// resolution and didn't change the files' sizes.
+func touch(t *testing.T, files ...string) {
+	later := time.Now().Add(time.Minute)
+	for _, file := range files {
+		err := os.Chtimes(file, later, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedName returns the common name of the certificate the reloader
+// currently serves.
+func servedName(t *testing.T, reloader *certReloader) string {
+	cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	assert.NilError(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertPair(t, certFile, keyFile, "first")
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	reloader, err := newCertReloader(certFile, keyFile, logger)
+	assert.NilError(t, err)
+	assert.Equal(t, servedName(t, reloader), "first")
+
+	t.Run("Unchanged files", func(t *testing.T) {
+		logs.Reset()
+		assert.NilError(t, reloader.reload())
+		assert.Equal(t, servedName(t, reloader), "first")
+		assert.Equal(t, logs.String(), "")
+	})
+
+	t.Run("Rotated certificate", func(t *testing.T) {
+		logs.Reset()
+		writeCertPair(t, certFile, keyFile, "second")
+		touch(t, certFile, keyFile)
+
+		assert.NilError(t, reloader.reload())
+		assert.Equal(t, servedName(t, reloader), "second")
+		assert.StringContains(t, logs.String(), "reloaded TLS certificate")
+	})
+
+	t.Run("Broken key keeps the old certificate", func(t *testing.T) {
+		logs.Reset()
+		err := os.WriteFile(keyFile, []byte("not a key"), 0o600)
+		assert.NilError(t, err)
+
+		for i := 0; i < 3; i++ {
+			if reloader.reload() == nil {
+				t.Fatal("expected an error")
+			}
+			assert.Equal(t, servedName(t, reloader), "second")
+		}
+
+		// The failure is only logged once for the same files.
+		assert.Equal(t, strings.Count(logs.String(), "failed to reload TLS certificate"), 1)
+	})
+
+	t.Run("Missing key keeps the old certificate", func(t *testing.T) {
+		logs.Reset()
+		assert.NilError(t, os.Remove(keyFile))
+
+		if reloader.reload() == nil {
+			t.Fatal("expected an error")
+		}
+		assert.Equal(t, servedName(t, reloader), "second")
+		assert.StringContains(t, logs.String(), "failed to reload TLS certificate")
+	})
+
+	t.Run("Fixed pair is loaded", func(t *testing.T) {
+		logs.Reset()
+		writeCertPair(t, certFile, keyFile, "third")
+
+		assert.NilError(t, reloader.reload())
+		assert.Equal(t, servedName(t, reloader), "third")
+		assert.StringContains(t, logs.String(), "reloaded TLS certificate")
+	})
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertPair(t, certFile, keyFile, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	writeCertPair(t, certFile, keyFile, "second")
+	touch(t, certFile, keyFile)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, reloader) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate wasn't loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The watcher stops when the context is cancelled.
+	cancel()
+	<-done
+}
+
+func TestNewCertReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{
+			name:  "Missing files",
+			setup: func(t *testing.T) {},
+		},
+		{
+			name: "Invalid key",
+			setup: func(t *testing.T) {
+				writeCertPair(t, certFile, keyFile, "first")
+				assert.NilError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			_, err := newCertReloader(certFile, keyFile, logger)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer which records the application's own
+// spans, such as template rendering.
+const tracerName = "snippetbox.tomcat.net/cmd/web"
+
+// newTracerProvider returns a tracer provider which sends spans in batches
+// to the OpenTelemetry collector at endpoint (such as
+// "http://localhost:4318"), using OTLP over HTTP.
+//
+// Parameters:
+//   - endpoint: The collector's base URL; spans are posted to its
+//     /v1/traces path
+//   - headers: Request headers sent with every export, such as an API key
+//   - serviceName: The service.name resource attribute, which names the
+//     application in the tracing UI
+//
+// The caller must call Shutdown on the provider to send the last spans
+// before exiting.
+func newTracerProvider(ctx context.Context, endpoint string, headers map[string]string, serviceName string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// trace middleware starts a server span for each request with otelhttp, so
+// that the spans of the queries and templates it runs are grouped under it.
+// If the request has a W3C traceparent header, for example from a proxy
+// which is itself traced, the span joins that trace. Responses with a 5xx
+// status code mark the span as failed.
+//
+// The span is named after the route pattern the request matched (such as
+// "GET /snippet/view/{id}"), which the servemux sets on the request it's
+// given, so trace must pass on the request otelhttp creates rather than go
+// back to the original. Requests which don't match a route are named by
+// their method only, so that scanning for random URLs doesn't create a name
+// for each one.
+//
+// If tracing is disabled (app.tracerProvider is nil) requests are passed on
+// as they are.
+func (app *application) trace(next http.Handler) http.Handler {
+	if app.tracerProvider == nil {
+		return next
+	}
+
+	route := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if r.Pattern != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+	})
+
+	return otelhttp.NewHandler(route, "",
+		otelhttp.WithTracerProvider(app.tracerProvider),
+		otelhttp.WithPropagators(propagation.TraceContext{}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if r.Pattern != "" {
+				return r.Pattern
+			}
+			return r.Method
+		}),
+	)
+}
+
+// startRenderSpan starts a span for executing the named page template, as a
+// child of the request's span. If the request isn't traced, the span isn't
+// recorded.
+func startRenderSpan(ctx context.Context, page string) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, "render "+page, trace.WithAttributes(attribute.String("template.name", page)))
+}
+
+// endSpan ends span, recording err, if it isn't nil, as the reason the
+// operation failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// newTestTracerProvider returns a tracer provider which records spans in
+// memory as soon as they end, and the exporter holding them.
+func newTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	return tp, exporter
+}
+
+// spanAttribute returns the value of the span's attribute with the given
+// key, or an invalid value if it hasn't got one.
+func spanAttribute(s tracetest.SpanStub, key string) attribute.Value {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTrace(t *testing.T) {
+	app := newTestApplication(t)
+	tp, exporter := newTestTracerProvider(t)
+	app.tracerProvider = tp
+
+	var logs bytes.Buffer
+	app.logger = newLogger(&logs, "text")
+
+	routes := app.routes()
+	serve := func(r *http.Request) tracetest.SpanStubs {
+		exporter.Reset()
+		logs.Reset()
+		routes.ServeHTTP(httptest.NewRecorder(), r)
+		return exporter.GetSpans()
+	}
+
+	t.Run("Server span", func(t *testing.T) {
+		spans := serve(httptest.NewRequest(http.MethodGet, "/snippet/view/1", nil))
+		server := spans[len(spans)-1]
+
+		assert.Equal(t, server.Name, "GET /snippet/view/{id}")
+		assert.Equal(t, server.SpanKind, trace.SpanKindServer)
+		assert.Equal(t, server.Parent.IsValid(), false)
+		assert.Equal(t, spanAttribute(server, "http.route").AsString(), "GET /snippet/view/{id}")
+		assert.Equal(t, spanAttribute(server, "url.path").AsString(), "/snippet/view/1")
+		assert.Equal(t, spanAttribute(server, "http.response.status_code").AsInt64(), int64(http.StatusOK))
+		assert.Equal(t, server.Status.Code, codes.Unset)
+	})
+
+	t.Run("Render span", func(t *testing.T) {
+		spans := serve(httptest.NewRequest(http.MethodGet, "/snippet/view/1", nil))
+		assert.Equal(t, len(spans), 2)
+		render, server := spans[0], spans[1]
+
+		assert.Equal(t, render.Name, "render view.html")
+		assert.Equal(t, render.SpanContext.TraceID(), server.SpanContext.TraceID())
+		assert.Equal(t, render.Parent.SpanID(), server.SpanContext.SpanID())
+	})
+
+	t.Run("Unmatched route", func(t *testing.T) {
+		spans := serve(httptest.NewRequest(http.MethodGet, "/no/such/page", nil))
+		server := spans[len(spans)-1]
+
+		assert.Equal(t, server.Name, "GET")
+		assert.Equal(t, spanAttribute(server, "http.route").Type(), attribute.INVALID)
+		assert.Equal(t, spanAttribute(server, "http.response.status_code").AsInt64(), int64(http.StatusNotFound))
+	})
+
+	t.Run("Remote parent", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		spans := serve(r)
+		server := spans[len(spans)-1]
+
+		assert.Equal(t, server.SpanContext.TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+		assert.Equal(t, server.Parent.SpanID().String(), "00f067aa0ba902b7")
+	})
+
+	t.Run("Trace ID in logs", func(t *testing.T) {
+		spans := serve(httptest.NewRequest(http.MethodGet, "/ping", nil))
+		server := spans[len(spans)-1]
+
+		assert.StringContains(t, logs.String(), "msg=\"received request\"")
+		assert.StringContains(t, logs.String(), "trace_id="+server.SpanContext.TraceID().String())
+		assert.StringContains(t, logs.String(), "span_id="+server.SpanContext.SpanID().String())
+	})
+}
+
+func TestTraceServerError(t *testing.T) {
+	app := newTestApplication(t)
+	tp, exporter := newTestTracerProvider(t)
+	app.tracerProvider = tp
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	app.trace(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 1)
+	assert.Equal(t, spans[0].Status.Code, codes.Error)
+}
+
+func TestTraceDisabled(t *testing.T) {
+	app := newTestApplication(t)
+
+	var got *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	app.trace(next).ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, got, r)
+}
+
+func TestRenderSpan(t *testing.T) {
+	tp, exporter := newTestTracerProvider(t)
+
+	// Outside a traced request, nothing is recorded.
+	_, span := startRenderSpan(context.Background(), "view.html")
+	endSpan(span, errors.New("template: no such field"))
+	assert.Equal(t, len(exporter.GetSpans()), 0)
+
+	// Inside one, a failed render marks the span as failed.
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "GET /")
+	_, span = startRenderSpan(ctx, "view.html")
+	endSpan(span, errors.New("template: no such field"))
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 2)
+	assert.Equal(t, spans[0].Status.Code, codes.Error)
+	assert.Equal(t, spans[0].Status.Description, "template: no such field")
+	assert.Equal(t, spanAttribute(spans[0], "template.name").AsString(), "view.html")
+}
@@ -0,0 +1,730 @@
+// Package acmetest provides a minimal stand-in ACME certificate authority
+// (RFC 8555) for tests, in the spirit of Let's Encrypt's Pebble. It
+// implements account registration, orders, the tls-alpn-01 and http-01
+// challenges and certificate issuance, and validates challenges by
+// connecting to the application like a real CA would, so tests exercise the
+// whole flow without touching the internet.
+//
+// JWS signatures and nonces aren't checked: the server trusts its clients.
+package acmetest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Challenge types supported by the server.
+const (
+	ChallengeTLSALPN = "tls-alpn-01"
+	ChallengeHTTP    = "http-01"
+)
+
+// idPeACMEIdentifier is the certificate extension holding the key
+// authorization digest in a tls-alpn-01 response (RFC 8737).
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// Server is a stand-in ACME CA running on an httptest TLS server. Its
+// exported fields may be changed before certificates are requested.
+type Server struct {
+	*httptest.Server
+
+	// ChallengeTypes are the challenges offered for each authorization, in
+	// order of preference. Both types are offered by default.
+	ChallengeTypes []string
+
+	// HTTPPort and TLSPort are the ports connected to when validating
+	// http-01 and tls-alpn-01 challenges, like Pebble's httpPort and
+	// tlsPort settings. They default to 80 and 443.
+	HTTPPort string
+	TLSPort  string
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu       sync.Mutex
+	hosts    map[string]string // Domain name to IP address
+	accounts []string          // JWK thumbprints, indexed by account ID
+	orders   []*order
+	authzs   []*authorization
+	issued   int
+}
+
+// identifier is an ACME identifier, which is always a DNS name here.
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// challenge is a challenge which the client may complete to prove it
+// controls a domain.
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+// authorization is an account's authorization to get certificates for a
+// domain.
+type authorization struct {
+	Status     string       `json:"status"`
+	Identifier identifier   `json:"identifier"`
+	Challenges []*challenge `json:"challenges"`
+
+	account int
+}
+
+// order is a request for a certificate.
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+
+	account int
+	authzs  []*authorization
+	chain   []byte // PEM encoded certificate chain, once issued
+}
+
+// NewServer starts a new stand-in CA with a freshly generated root
+// certificate. Callers should Close it when done. Use its Client() to make
+// requests to it, as it trusts the server's own certificate.
+func NewServer() *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acmetest root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		ChallengeTypes: []string{ChallengeTLSALPN, ChallengeHTTP},
+		HTTPPort:       "80",
+		TLSPort:        "443",
+		caKey:          key,
+		caCert:         cert,
+		hosts:          make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dir", s.directory)
+	mux.HandleFunc("GET /nonce", s.nonce) // Also matches HEAD
+	mux.HandleFunc("POST /account", s.newAccount)
+	mux.HandleFunc("POST /order", s.newOrder)
+	mux.HandleFunc("POST /order/{id}", s.getOrder)
+	mux.HandleFunc("POST /authz/{id}", s.getAuthz)
+	mux.HandleFunc("POST /chall/{authz}/{type}", s.acceptChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.finalize)
+	mux.HandleFunc("POST /cert/{id}", s.getCert)
+
+	s.Server = httptest.NewTLSServer(s.withNonce(mux))
+	return s
+}
+
+// DirectoryURL returns the URL of the ACME directory, which clients start
+// from.
+func (s *Server) DirectoryURL() string {
+	return s.URL + "/dir"
+}
+
+// Roots returns a pool containing the root certificate which issued
+// certificates chain to.
+func (s *Server) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.caCert)
+	return pool
+}
+
+// Resolve sets the IP address connected to when validating challenges for
+// a domain, in place of a DNS lookup.
+func (s *Server) Resolve(domain, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts[domain] = ip
+}
+
+// Issued returns the number of certificates issued.
+func (s *Server) Issued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// withNonce adds a fresh nonce to every response, as clients need one for
+// their next request.
+func (s *Server) withNonce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", randomString())
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) directory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   s.URL + "/nonce",
+		"newAccount": s.URL + "/account",
+		"newOrder":   s.URL + "/order",
+		"meta": map[string]any{
+			"termsOfService": s.URL + "/terms",
+		},
+	})
+}
+
+func (s *Server) nonce(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, r *http.Request) {
+	req, err := readJWS(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	if req.protected.JWK == nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", "new account requests must include a JWK")
+		return
+	}
+
+	thumbprint, err := jwkThumbprint(req.protected.JWK)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badPublicKey", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	id := slices.Index(s.accounts, thumbprint)
+	status := http.StatusOK
+	if id < 0 {
+		id = len(s.accounts)
+		s.accounts = append(s.accounts, thumbprint)
+		status = http.StatusCreated
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("%s/account/%d", s.URL, id))
+	writeJSON(w, status, map[string]any{"status": "valid"})
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, r *http.Request) {
+	req, account, ok := s.readAccountJWS(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	err := json.Unmarshal(req.payload, &payload)
+	if err != nil || len(payload.Identifiers) == 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "an order needs identifiers")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &order{Status: "pending", Identifiers: payload.Identifiers, account: account}
+	orderID := len(s.orders)
+
+	for _, ident := range payload.Identifiers {
+		if ident.Type != "dns" {
+			writeProblem(w, http.StatusBadRequest, "unsupportedIdentifier", ident.Type)
+			return
+		}
+
+		z := &authorization{Status: "pending", Identifier: ident, account: account}
+		authzID := len(s.authzs)
+		for _, typ := range s.ChallengeTypes {
+			z.Challenges = append(z.Challenges, &challenge{
+				Type:   typ,
+				URL:    fmt.Sprintf("%s/chall/%d/%s", s.URL, authzID, typ),
+				Token:  randomString(),
+				Status: "pending",
+			})
+		}
+		s.authzs = append(s.authzs, z)
+
+		o.authzs = append(o.authzs, z)
+		o.Authorizations = append(o.Authorizations, fmt.Sprintf("%s/authz/%d", s.URL, authzID))
+	}
+	o.Finalize = fmt.Sprintf("%s/finalize/%d", s.URL, orderID)
+	s.orders = append(s.orders, o)
+
+	w.Header().Set("Location", fmt.Sprintf("%s/order/%d", s.URL, orderID))
+	writeJSON(w, http.StatusCreated, o)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	_, account, ok := s.readAccountJWS(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := lookup(s.orders, r.PathValue("id"))
+	if !ok || o.account != account {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) getAuthz(w http.ResponseWriter, r *http.Request) {
+	req, account, ok := s.readAccountJWS(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, ok := lookup(s.authzs, r.PathValue("id"))
+	if !ok || z.account != account {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+
+	// Clients deactivate authorizations they no longer need.
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if len(req.payload) > 0 && json.Unmarshal(req.payload, &payload) == nil && payload.Status == "deactivated" {
+		z.Status = "deactivated"
+	}
+
+	writeJSON(w, http.StatusOK, z)
+}
+
+// acceptChallenge validates a challenge, once the client says it's ready.
+// Validation happens before responding, so the authorization is settled by
+// the time the client polls it.
+func (s *Server) acceptChallenge(w http.ResponseWriter, r *http.Request) {
+	_, account, ok := s.readAccountJWS(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	z, ok := lookup(s.authzs, r.PathValue("authz"))
+	if !ok || z.account != account {
+		s.mu.Unlock()
+		writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+
+	var chal *challenge
+	for _, c := range z.Challenges {
+		if c.Type == r.PathValue("type") {
+			chal = c
+		}
+	}
+	if chal == nil {
+		s.mu.Unlock()
+		writeProblem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+
+	domain := z.Identifier.Value
+	ip := s.hosts[domain]
+	keyAuth := chal.Token + "." + s.accounts[account]
+	s.mu.Unlock()
+
+	var err error
+	switch {
+	case ip == "":
+		err = fmt.Errorf("no address for %s", domain)
+	case chal.Type == ChallengeTLSALPN:
+		err = validateTLSALPN(domain, net.JoinHostPort(ip, s.TLSPort), keyAuth)
+	case chal.Type == ChallengeHTTP:
+		err = validateHTTP(domain, net.JoinHostPort(ip, s.HTTPPort), chal.Token, keyAuth)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		chal.Status = "invalid"
+		z.Status = "invalid"
+	} else {
+		chal.Status = "valid"
+		z.Status = "valid"
+	}
+	s.updateOrders()
+
+	writeJSON(w, http.StatusOK, chal)
+}
+
+// updateOrders makes pending orders ready once all their authorizations are
+// valid, or invalid if any failed. The caller must hold s.mu.
+func (s *Server) updateOrders() {
+	for _, o := range s.orders {
+		if o.Status != "pending" {
+			continue
+		}
+
+		valid := 0
+		for _, z := range o.authzs {
+			switch z.Status {
+			case "valid":
+				valid++
+			case "invalid", "deactivated":
+				o.Status = "invalid"
+			}
+		}
+
+		if o.Status == "pending" && valid == len(o.authzs) {
+			o.Status = "ready"
+		}
+	}
+}
+
+func (s *Server) finalize(w http.ResponseWriter, r *http.Request) {
+	req, account, ok := s.readAccountJWS(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	err := json.Unmarshal(req.payload, &payload)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := lookup(s.orders, r.PathValue("id"))
+	if !ok || o.account != account {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	if o.Status != "ready" {
+		writeProblem(w, http.StatusForbidden, "orderNotReady", "order is "+o.Status)
+		return
+	}
+
+	// The certificate may only be for the order's names.
+	names := csr.DNSNames
+	if len(names) == 0 {
+		names = []string{csr.Subject.CommonName}
+	}
+	for _, name := range names {
+		if !slices.Contains(o.Identifiers, identifier{Type: "dns", Value: name}) {
+			writeProblem(w, http.StatusBadRequest, "badCSR", name+" isn't in the order")
+			return
+		}
+	}
+
+	s.issued++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.issued + 1)),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, tmpl, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+
+	o.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+	o.Status = "valid"
+	o.Certificate = fmt.Sprintf("%s/cert/%s", s.URL, r.PathValue("id"))
+
+	w.Header().Set("Location", fmt.Sprintf("%s/order/%s", s.URL, r.PathValue("id")))
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) getCert(w http.ResponseWriter, r *http.Request) {
+	_, account, ok := s.readAccountJWS(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := lookup(s.orders, r.PathValue("id"))
+	if !ok || o.account != account || o.chain == nil {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(o.chain)
+}
+
+// validateTLSALPN completes a tls-alpn-01 challenge (RFC 8737) by
+// connecting to addr, asking for the acme-tls/1 protocol, and checking the
+// certificate presented holds the digest of the key authorization.
+func validateTLSALPN(domain, addr, keyAuth string) error {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true, // The certificate is self-signed.
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != "acme-tls/1" {
+		return fmt.Errorf("negotiated protocol %q", state.NegotiatedProtocol)
+	}
+
+	cert := state.PeerCertificates[0]
+	err = cert.VerifyHostname(domain)
+	if err != nil {
+		return err
+	}
+
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+
+		var digest []byte
+		_, err := asn1.Unmarshal(ext.Value, &digest)
+		if err != nil {
+			return err
+		}
+		if string(digest) != string(want[:]) {
+			return errors.New("acmeIdentifier doesn't match the key authorization")
+		}
+		return nil
+	}
+
+	return errors.New("no acmeIdentifier extension")
+}
+
+// validateHTTP completes an http-01 challenge by fetching the key
+// authorization from the well-known path on addr.
+func validateHTTP(domain, addr, token, keyAuth string) error {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+		// The challenge response must be served directly.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get("http://" + domain + "/.well-known/acme-challenge/" + token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge response status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return errors.New("challenge response doesn't match the key authorization")
+	}
+
+	return nil
+}
+
+// jws is a request body signed by an ACME client, with its protected
+// header and payload decoded.
+type jws struct {
+	protected struct {
+		JWK map[string]any `json:"jwk"`
+		KID string         `json:"kid"`
+	}
+	payload []byte
+}
+
+// readJWS decodes a request's JWS body, without checking its signature.
+func readJWS(r *http.Request) (jws, error) {
+	var req jws
+	var body struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return req, err
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(body.Protected)
+	if err != nil {
+		return req, err
+	}
+	err = json.Unmarshal(protected, &req.protected)
+	if err != nil {
+		return req, err
+	}
+
+	req.payload, err = base64.RawURLEncoding.DecodeString(body.Payload)
+	return req, err
+}
+
+// readAccountJWS decodes a request from a registered account, identified
+// by the key ID in its protected header. If the request is invalid, it
+// writes an error response and returns false.
+func (s *Server) readAccountJWS(w http.ResponseWriter, r *http.Request) (jws, int, bool) {
+	req, err := readJWS(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return req, 0, false
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(req.protected.KID, s.URL+"/account/"))
+
+	s.mu.Lock()
+	known := err == nil && id >= 0 && id < len(s.accounts)
+	s.mu.Unlock()
+
+	if !known {
+		writeProblem(w, http.StatusUnauthorized, "accountDoesNotExist", "unknown key ID "+req.protected.KID)
+		return req, 0, false
+	}
+
+	return req, id, true
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of a public key, which is
+// part of every key authorization.
+func jwkThumbprint(jwk map[string]any) (string, error) {
+	var members []string
+	switch jwk["kty"] {
+	case "EC":
+		members = []string{"crv", "kty", "x", "y"}
+	case "RSA":
+		members = []string{"e", "kty", "n"}
+	default:
+		return "", fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+
+	// The thumbprint is of the required members only, in lexical order
+	// and without whitespace.
+	var b strings.Builder
+	b.WriteString("{")
+	for i, m := range members {
+		v, ok := jwk[m].(string)
+		if !ok {
+			return "", fmt.Errorf("key is missing %q", m)
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%q:%q", m, v)
+	}
+	b.WriteString("}")
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// lookup returns the element of items with the index in the string id.
+func lookup[T any](items []T, id string) (T, bool) {
+	var zero T
+
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 0 || i >= len(items) {
+		return zero, false
+	}
+	return items[i], true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeProblem writes an RFC 7807 problem document, as ACME servers use for
+// errors.
+func writeProblem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":   "urn:ietf:params:acme:error:" + typ,
+		"detail": detail,
+		"status": status,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
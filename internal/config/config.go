@@ -0,0 +1,796 @@
+// Package config loads the application's configuration. Each setting can
+// come from four sources, which are applied in order so that later sources
+// override earlier ones:
+//
+//  1. The built-in defaults (see Default)
+//  2. A TOML or YAML file (YAML if its name ends in .yaml or .yml), named by
+//     the -config flag or SNIPPETBOX_CONFIG
+//  3. Environment variables, named SNIPPETBOX_ followed by the flag name in
+//     upper case with dashes replaced by underscores, such as
+//     SNIPPETBOX_SMTP_HOST for -smtp-host
+//  4. Command-line flags
+//
+// Every setting has a flag, a key in the file and an environment variable,
+// and values are written the same way in each (durations such as "12h",
+// booleans as "true" or "false").
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables read by Load.
+const EnvPrefix = "SNIPPETBOX_"
+
+// redacted replaces secrets when the configuration is printed.
+const redacted = "REDACTED"
+
+// Config is the application's configuration.
+type Config struct {
+	Addr                 string        // HTTP network address, such as ":4000"
+	DSN                  string        // MySQL data source name
+	DB                   DBConfig      // Database connection pool and query limits
+	Debug                bool          // Show detailed errors in responses
+	LogFormat            string        // "text" or "json"
+	BaseURL              string        // Public URL, used to build links in emails
+	Secret               string        // Key used to sign tokens (random if empty)
+	RequireVerifiedEmail bool          // Require a verified email to create snippets
+	ReportThreshold      int           // Reports needed to hide a snippet (0 disables)
+	ScannerRules         string        // Content scanner rules file (built-in if empty)
+	RateLimitStore       string        // "memory" or "mysql"
+	TrustedProxies       string        // Comma-separated CIDR ranges of reverse proxies
+	ProxyHeader          string        // "x-forwarded-for" or "forwarded"
+	TLS                  TLSConfig     // Certificate files
+	Server               ServerConfig  // HTTP server timeouts
+	SMTP                 SMTPConfig    // Email delivery
+	Session              SessionConfig // Session lifetimes
+	OIDC                 OIDCConfig    // Single sign-on
+	Metrics              MetricsConfig // Prometheus metrics endpoint
+	Tracing              TracingConfig // OpenTelemetry tracing
+
+	// ConfigFile is the file the configuration was read from, if any, and
+	// PrintConfig is set by the -print-config flag. They can only be set on
+	// the command line (or, for ConfigFile, with SNIPPETBOX_CONFIG).
+	ConfigFile  string
+	PrintConfig bool
+}
+
+// TLSConfig holds the server's HTTPS settings.
+//
+// Fields:
+//   - Enabled: Serve HTTPS; if false, serve plain HTTP, for example behind a
+//     proxy which terminates TLS
+//   - CertFile, KeyFile: The paths of the certificate and private key, both
+//     PEM encoded
+//   - ReloadInterval: How often to check CertFile and KeyFile for a rotated
+//     certificate (zero to never reload them)
+//   - RedirectAddr: If set, also listen for plain HTTP on this address and
+//     redirect requests to HTTPS
+//   - HSTSMaxAge: The max-age of the Strict-Transport-Security header sent
+//     over HTTPS (zero to not send it)
+//   - ACMEDomains: Comma-separated host names to get certificates for from
+//     an ACME CA such as Let's Encrypt, instead of using CertFile and KeyFile
+//     (disabled if empty)
+//   - ACMEEmail: Contact address given to the CA, for expiry warnings
+//   - ACMEDirectory: The CA's directory URL
+//   - ACMECARoots: A PEM file of CA certificates trusted for connections to
+//     the ACME server, such as Pebble's (the system's roots if empty)
+//   - ACMECache: Where certificates and the account key are cached: "dir"
+//     or "mysql"
+//   - ACMECacheDir: The directory used by the "dir" cache
+type TLSConfig struct {
+	Enabled        bool
+	CertFile       string
+	KeyFile        string
+	ReloadInterval time.Duration
+	RedirectAddr   string
+	HSTSMaxAge     time.Duration
+	ACMEDomains    string
+	ACMEEmail      string
+	ACMEDirectory  string
+	ACMECARoots    string
+	ACMECache      string
+	ACMECacheDir   string
+}
+
+// ACMEHosts returns the host names in ACMEDomains, or nil if certificates
+// don't come from an ACME CA.
+func (c TLSConfig) ACMEHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(c.ACMEDomains, ",") {
+		host = strings.TrimSpace(host)
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// DBConfig holds the database connection pool's settings and limits on
+// database access.
+//
+// Fields:
+//   - QueryTimeout: How long the queries made by each model method may take
+//     before they're abandoned
+//   - MaxOpenConns: The most connections open at once, in use or idle (0 is
+//     unlimited)
+//   - MaxIdleConns: The most idle connections kept open for reuse (no more
+//     than MaxOpenConns are kept, if it's lower)
+//   - ConnMaxLifetime: How long a connection may be reused before it's
+//     closed (0 is forever)
+//   - ConnMaxIdleTime: How long a connection may be idle before it's closed
+//     (0 is forever)
+//   - ConnectTimeout: How long to keep retrying at startup while the
+//     database isn't reachable, for example because its container is still
+//     starting
+//   - StatsInterval: How often to log the pool's statistics (0 disables it)
+type DBConfig struct {
+	QueryTimeout    time.Duration
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	ConnectTimeout  time.Duration
+	StatsInterval   time.Duration
+}
+
+// ServerConfig holds the HTTP server's timeouts.
+//
+// Fields:
+//   - IdleTimeout: How long to keep idle keep-alive connections open
+//   - ReadTimeout: Maximum time to read a request, including its body
+//   - WriteTimeout: Maximum time to write a response
+//   - ShutdownTimeout: How long to wait for in-flight requests and background
+//     tasks when shutting down
+//   - DrainDelay: How long to keep serving requests after being told to stop,
+//     with GET /readyz reporting that the server is draining, so that a load
+//     balancer can stop sending it requests first
+//   - ReadinessTimeout: How long GET /readyz waits for the database and
+//     session store to respond
+type ServerConfig struct {
+	IdleTimeout      time.Duration
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	ShutdownTimeout  time.Duration
+	DrainDelay       time.Duration
+	ReadinessTimeout time.Duration
+}
+
+// SMTPConfig holds the SMTP server used to send emails. If Host is empty,
+// emails are written to the log instead.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+// SessionConfig holds session lifetimes. Normal sessions end after
+// Lifetime, or after IdleTimeout without a request (zero disables this);
+// "remember me" sessions last for RememberMeLifetime.
+type SessionConfig struct {
+	Lifetime           time.Duration
+	IdleTimeout        time.Duration
+	RememberMeLifetime time.Duration
+}
+
+// OIDCConfig holds the OpenID Connect identity provider used for single
+// sign-on, which is disabled if Issuer is empty. Name is shown on the login
+// page.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Name         string
+}
+
+// MetricsConfig holds the Prometheus metrics endpoint, GET /metrics. If
+// Addr is set, it's served on its own plain HTTP listener there, which
+// should only be reachable by the monitoring system; otherwise it's served
+// on the main listener if Token is set. If Token is set, scrapes must send
+// it as a bearer token. Metrics aren't served if both are empty.
+type MetricsConfig struct {
+	Addr  string
+	Token string
+}
+
+// TracingConfig holds the OpenTelemetry collector which traces are sent to,
+// using OTLP over HTTP. Tracing is disabled if OTLPEndpoint is empty.
+// OTLPHeaders is a comma-separated list of key=value request headers, such
+// as an API key, and ServiceName names the application in the tracing UI.
+type TracingConfig struct {
+	OTLPEndpoint string
+	OTLPHeaders  string
+	ServiceName  string
+}
+
+// ParseHeaders parses a comma-separated list of key=value pairs, such as
+// "x-api-key=secret,x-tenant=snippetbox", as used for
+// TracingConfig.OTLPHeaders.
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, errors.New("config: headers must be comma-separated key=value pairs")
+		}
+		headers[k] = strings.TrimSpace(v)
+	}
+	return headers, nil
+}
+
+// Default returns the built-in configuration, which is suitable for local
+// development.
+func Default() Config {
+	return Config{
+		Addr:      ":4000",
+		DSN:       "web:pass@/snippetbox?parseTime=true",
+		LogFormat: "text",
+		DB: DBConfig{
+			QueryTimeout:    5 * time.Second,
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: time.Hour,
+			ConnMaxIdleTime: 15 * time.Minute,
+			ConnectTimeout:  30 * time.Second,
+		},
+		BaseURL:              "https://localhost:4000",
+		RequireVerifiedEmail: true,
+		ReportThreshold:      3,
+		RateLimitStore:       "memory",
+		ProxyHeader:          "x-forwarded-for",
+		TLS: TLSConfig{
+			Enabled:        true,
+			CertFile:       "./tls/localhost+2.pem",
+			KeyFile:        "./tls/localhost+2-key.pem",
+			ReloadInterval: 30 * time.Second,
+			HSTSMaxAge:     365 * 24 * time.Hour,
+
+			ACMEDirectory: "https://acme-v02.api.letsencrypt.org/directory",
+			ACMECache:     "dir",
+			ACMECacheDir:  "./tls/acme",
+		},
+		Server: ServerConfig{
+			IdleTimeout:      time.Minute,
+			ReadTimeout:      5 * time.Second,
+			WriteTimeout:     10 * time.Second,
+			ShutdownTimeout:  30 * time.Second,
+			ReadinessTimeout: 2 * time.Second,
+		},
+		SMTP: SMTPConfig{
+			Port:   587,
+			Sender: "Snippetbox <no-reply@snippetbox.tomcat.net>",
+		},
+		Session: SessionConfig{
+			Lifetime:           12 * time.Hour,
+			IdleTimeout:        2 * time.Hour,
+			RememberMeLifetime: 30 * 24 * time.Hour,
+		},
+		OIDC: OIDCConfig{
+			Name: "single sign-on",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "snippetbox",
+		},
+	}
+}
+
+// option links a setting's flag to its key in the configuration file.
+// Secret options are redacted when the configuration is printed.
+type option struct {
+	flag   string
+	key    string
+	secret bool
+}
+
+// env returns the name of the option's environment variable.
+func (o option) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(o.flag, "-", "_"))
+}
+
+// options registers each setting as a flag bound to its field of the
+// configuration, so that values from every source are parsed by the flag's
+// Set method.
+type options struct {
+	fs   *flag.FlagSet
+	list []option
+}
+
+func (o *options) add(name, key string, secret bool) {
+	o.list = append(o.list, option{flag: name, key: key, secret: secret})
+}
+
+func (o *options) string(p *string, name, key, usage string) {
+	o.fs.StringVar(p, name, *p, usage)
+	o.add(name, key, false)
+}
+
+func (o *options) secret(p *string, name, key, usage string) {
+	o.fs.StringVar(p, name, *p, usage)
+	o.add(name, key, true)
+}
+
+func (o *options) int(p *int, name, key, usage string) {
+	o.fs.IntVar(p, name, *p, usage)
+	o.add(name, key, false)
+}
+
+func (o *options) bool(p *bool, name, key, usage string) {
+	o.fs.BoolVar(p, name, *p, usage)
+	o.add(name, key, false)
+}
+
+func (o *options) duration(p *time.Duration, name, key, usage string) {
+	o.fs.DurationVar(p, name, *p, usage)
+	o.add(name, key, false)
+}
+
+// lookup returns the option with the given key in the configuration file.
+func (o *options) lookup(key string) (option, bool) {
+	for _, opt := range o.list {
+		if opt.key == key {
+			return opt, true
+		}
+	}
+	return option{}, false
+}
+
+// options returns the settings, bound to the fields of c. Each flag's
+// default is the field's current value.
+func (c *Config) options(name string) *options {
+	o := &options{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+
+	o.string(&c.Addr, "addr", "addr", "HTTP network address")
+	o.secret(&c.DSN, "dsn", "dsn", "MySQL data source name")
+	o.duration(&c.DB.QueryTimeout, "db-query-timeout", "db.query_timeout", "How long each model method's queries may take before they're abandoned")
+	o.int(&c.DB.MaxOpenConns, "db-max-open-conns", "db.max_open_conns", "Maximum number of open database connections (0 for unlimited)")
+	o.int(&c.DB.MaxIdleConns, "db-max-idle-conns", "db.max_idle_conns", "Maximum number of idle database connections kept for reuse")
+	o.duration(&c.DB.ConnMaxLifetime, "db-conn-max-lifetime", "db.conn_max_lifetime", "Close database connections after they've been open this long (0 to keep them)")
+	o.duration(&c.DB.ConnMaxIdleTime, "db-conn-max-idle-time", "db.conn_max_idle_time", "Close database connections after they've been idle this long (0 to keep them)")
+	o.duration(&c.DB.ConnectTimeout, "db-connect-timeout", "db.connect_timeout", "How long to keep retrying to connect to the database at startup")
+	o.duration(&c.DB.StatsInterval, "db-stats-interval", "db.stats_interval", "How often to log database connection pool statistics (0 to disable)")
+	o.bool(&c.Debug, "debug", "debug", "Enable debug mode")
+	o.string(&c.LogFormat, "log-format", "log_format", `Log format: "text" (key=value pairs) or "json" (one object per line)`)
+	o.string(&c.BaseURL, "base-url", "base_url", "Public base URL used in links sent by email")
+	o.secret(&c.Secret, "secret", "secret", "Secret key (at least 32 characters) used to sign tokens")
+	o.bool(&c.RequireVerifiedEmail, "require-verified-email", "require_verified_email", "Require a verified email address to create snippets")
+	o.int(&c.ReportThreshold, "report-threshold", "report_threshold", "Hide snippets reported by this many users until a moderator reviews them (0 to disable)")
+	o.string(&c.ScannerRules, "scanner-rules", "scanner_rules", "JSON file of rules for checking snippets for secrets and spam (built-in rules if empty)")
+	o.string(&c.RateLimitStore, "rate-limit-store", "rate_limit_store", `Where rate limits are tracked: "memory" or "mysql"`)
+	o.string(&c.TrustedProxies, "trusted-proxies", "trusted_proxies", "Comma-separated CIDR ranges of trusted reverse proxies, e.g. \"10.0.0.0/8,192.168.1.10\"")
+	o.string(&c.ProxyHeader, "proxy-header", "proxy_header", `Header the trusted proxies set to the client's address: "x-forwarded-for" or "forwarded"`)
+
+	o.bool(&c.TLS.Enabled, "tls", "tls.enabled", "Serve HTTPS (use -tls=false to serve plain HTTP behind a TLS-terminating proxy)")
+	o.string(&c.TLS.CertFile, "tls-cert", "tls.cert_file", "TLS certificate file (PEM)")
+	o.string(&c.TLS.KeyFile, "tls-key", "tls.key_file", "TLS private key file (PEM)")
+	o.duration(&c.TLS.ReloadInterval, "tls-reload-interval", "tls.reload_interval", "How often to check the TLS certificate and key files for changes (0 to disable)")
+	o.string(&c.TLS.RedirectAddr, "http-redirect-addr", "tls.redirect_addr", "Address of a plain HTTP listener which redirects to HTTPS, e.g. \":80\" (disabled if empty)")
+	o.duration(&c.TLS.HSTSMaxAge, "hsts-max-age", "tls.hsts_max_age", "Max-age of the Strict-Transport-Security header sent over HTTPS (0 to disable)")
+	o.string(&c.TLS.ACMEDomains, "acme-domains", "tls.acme_domains", "Comma-separated host names to get certificates for from an ACME CA (disabled if empty)")
+	o.string(&c.TLS.ACMEEmail, "acme-email", "tls.acme_email", "Contact email address given to the ACME CA")
+	o.string(&c.TLS.ACMEDirectory, "acme-directory", "tls.acme_directory", "ACME CA directory URL")
+	o.string(&c.TLS.ACMECARoots, "acme-ca-roots", "tls.acme_ca_roots", "PEM file of CA certificates trusted for connections to the ACME CA (system roots if empty)")
+	o.string(&c.TLS.ACMECache, "acme-cache", "tls.acme_cache", `Where ACME certificates are cached: "dir" or "mysql"`)
+	o.string(&c.TLS.ACMECacheDir, "acme-cache-dir", "tls.acme_cache_dir", "Directory for the \"dir\" ACME certificate cache")
+
+	o.duration(&c.Server.IdleTimeout, "idle-timeout", "server.idle_timeout", "How long to keep idle keep-alive connections open")
+	o.duration(&c.Server.ReadTimeout, "read-timeout", "server.read_timeout", "Maximum time to read a request, including its body")
+	o.duration(&c.Server.WriteTimeout, "write-timeout", "server.write_timeout", "Maximum time to write a response")
+	o.duration(&c.Server.ShutdownTimeout, "shutdown-timeout", "server.shutdown_timeout", "How long to wait for in-flight requests to finish when shutting down")
+	o.duration(&c.Server.DrainDelay, "drain-delay", "server.drain_delay", "How long to keep serving, reporting not ready on GET /readyz, before shutting down")
+	o.duration(&c.Server.ReadinessTimeout, "readiness-timeout", "server.readiness_timeout", "How long GET /readyz waits for the database and session store")
+
+	o.string(&c.SMTP.Host, "smtp-host", "smtp.host", "SMTP server host (emails are logged if empty)")
+	o.int(&c.SMTP.Port, "smtp-port", "smtp.port", "SMTP server port")
+	o.string(&c.SMTP.Username, "smtp-username", "smtp.username", "SMTP server username")
+	o.secret(&c.SMTP.Password, "smtp-password", "smtp.password", "SMTP server password")
+	o.string(&c.SMTP.Sender, "smtp-sender", "smtp.sender", "SMTP sender address")
+
+	o.duration(&c.Session.Lifetime, "session-lifetime", "session.lifetime", "Maximum lifetime of a normal session")
+	o.duration(&c.Session.IdleTimeout, "session-idle-timeout", "session.idle_timeout", "Log out normal sessions after this long without activity (0 to disable)")
+	o.duration(&c.Session.RememberMeLifetime, "remember-me-lifetime", "session.remember_me_lifetime", "Maximum lifetime of a \"remember me\" session")
+
+	o.string(&c.OIDC.Issuer, "oidc-issuer", "oidc.issuer", "OpenID Connect issuer URL for single sign-on (disabled if empty)")
+	o.string(&c.OIDC.ClientID, "oidc-client-id", "oidc.client_id", "OpenID Connect client ID")
+	o.secret(&c.OIDC.ClientSecret, "oidc-client-secret", "oidc.client_secret", "OpenID Connect client secret")
+	o.string(&c.OIDC.Name, "oidc-name", "oidc.name", "Name of the identity provider shown on the login page")
+
+	o.string(&c.Metrics.Addr, "metrics-addr", "metrics.addr", "Address of a separate plain HTTP listener for GET /metrics, e.g. \"localhost:9100\"")
+	o.secret(&c.Metrics.Token, "metrics-token", "metrics.token", "Bearer token required to scrape GET /metrics (served on addr if metrics.addr is empty)")
+
+	o.string(&c.Tracing.OTLPEndpoint, "otlp-endpoint", "tracing.otlp_endpoint", "OpenTelemetry collector's OTLP/HTTP URL to send traces to, e.g. \"http://localhost:4318\" (disabled if empty)")
+	o.secret(&c.Tracing.OTLPHeaders, "otlp-headers", "tracing.otlp_headers", "Comma-separated key=value headers sent to the OpenTelemetry collector, e.g. an API key")
+	o.string(&c.Tracing.ServiceName, "tracing-service-name", "tracing.service_name", "Service name reported in traces")
+
+	return o
+}
+
+// Load builds the configuration from the defaults, the configuration file,
+// the environment and the command-line arguments, then validates it.
+//
+// Parameters:
+//   - name: The program name, shown in usage messages
+//   - args: The command-line arguments, without the program name
+//   - environ: The environment, as returned by os.Environ
+//
+// Flow:
+// 1. Parse the flags, remembering which were given
+// 2. Start again from the defaults
+// 3. Apply the configuration file, if there is one
+// 4. Apply the SNIPPETBOX_* environment variables
+// 5. Apply the flags which were given
+// 6. Validate the result
+//
+// Error Handling:
+//   - -h or -help: flag.ErrHelp, after printing the usage message
+//   - Invalid flags, file contents or environment variables: an error naming
+//     the source and setting
+//   - Unknown keys in the file: an error, so that typos aren't silently
+//     ignored
+//   - Unknown SNIPPETBOX_* variables: returned, sorted, for the caller to warn
+//     about rather than an error, as the environment isn't always under the
+//     operator's control (Kubernetes, for example, sets SNIPPETBOX_PORT for a
+//     service named snippetbox)
+//   - Invalid settings: the errors from Validate
+func Load(name string, args, environ []string) (*Config, []string, error) {
+	cfg := Default()
+	o := cfg.options(name)
+	o.fs.StringVar(&cfg.ConfigFile, "config", "", "TOML or YAML configuration file (or set "+EnvPrefix+"CONFIG)")
+	o.fs.BoolVar(&cfg.PrintConfig, "print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+
+	err := o.fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected argument %q", o.fs.Arg(0))
+	}
+
+	// Remember the flags given on the command line, which are applied last
+	// so that they take precedence over the file and environment.
+	var given [][2]string
+	o.fs.Visit(func(f *flag.Flag) {
+		given = append(given, [2]string{f.Name, f.Value.String()})
+	})
+	configFile := cfg.ConfigFile
+
+	cfg = Default()
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, EnvPrefix) {
+			env[k] = v
+		}
+	}
+
+	cfg.ConfigFile = env[EnvPrefix+"CONFIG"]
+	delete(env, EnvPrefix+"CONFIG")
+	if configFile != "" {
+		cfg.ConfigFile = configFile
+	}
+
+	if cfg.ConfigFile != "" {
+		err = o.applyFile(cfg.ConfigFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	unknownEnv, err := o.applyEnv(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, kv := range given {
+		err = o.fs.Set(kv[0], kv[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("-%s: %w", kv[0], err)
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg, unknownEnv, nil
+}
+
+// applyFile sets the options in a configuration file, which is YAML if its
+// name ends in .yaml or .yml, and TOML otherwise. Both formats use the same
+// keys, with a table (or mapping) for each section.
+func (o *options) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		err = toml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]any)
+	flatten(doc, "", values)
+
+	// Apply the settings in a fixed order, so that the same error is
+	// reported each time.
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		opt, ok := o.lookup(key)
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+
+		s, err := fileString(values[key])
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+
+		err = o.fs.Set(opt.flag, s)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// flatten copies the values in a configuration file to dst, keyed by their dotted
+// paths such as "smtp.host".
+func flatten(doc map[string]any, prefix string, dst map[string]any) {
+	for k, v := range doc {
+		if table, ok := v.(map[string]any); ok {
+			flatten(table, prefix+k+".", dst)
+			continue
+		}
+		dst[prefix+k] = v
+	}
+}
+
+// fileString converts a value decoded from a TOML or YAML file to the form
+// accepted by the option's flag. Arrays of strings, which are convenient for
+// trusted_proxies, are joined with commas.
+func fileString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", errors.New("arrays may only contain strings")
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// applyEnv sets the options in the SNIPPETBOX_* environment variables, and
+// returns the names of any variables which don't match an option, sorted.
+func (o *options) applyEnv(env map[string]string) ([]string, error) {
+	for _, opt := range o.list {
+		v, ok := env[opt.env()]
+		if !ok {
+			continue
+		}
+		delete(env, opt.env())
+
+		err := o.fs.Set(opt.flag, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", opt.env(), err)
+		}
+	}
+
+	var unknown []string
+	for k := range env {
+		unknown = append(unknown, k)
+	}
+	sort.Strings(unknown)
+
+	return unknown, nil
+}
+
+// Validate checks that the settings are usable, returning every problem
+// found rather than just the first.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Addr != "", "addr must not be empty")
+	check(c.DSN != "", "dsn must not be empty")
+	check(c.DB.QueryTimeout > 0, "db.query_timeout must be positive")
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns must not be negative")
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime must not be negative")
+	check(c.DB.ConnMaxIdleTime >= 0, "db.conn_max_idle_time must not be negative")
+	check(c.DB.ConnectTimeout > 0, "db.connect_timeout must be positive")
+	check(c.DB.StatsInterval >= 0, "db.stats_interval must not be negative")
+	check(c.LogFormat == "text" || c.LogFormat == "json", `log_format must be "text" or "json", not %q`, c.LogFormat)
+
+	u, err := url.Parse(c.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"base_url must be an absolute http or https URL, not %q", c.BaseURL)
+
+	check(c.Secret == "" || len(c.Secret) >= 32, "secret must be at least 32 characters long")
+	check(c.ReportThreshold >= 0, "report_threshold must not be negative")
+	check(c.RateLimitStore == "memory" || c.RateLimitStore == "mysql",
+		`rate_limit_store must be "memory" or "mysql", not %q`, c.RateLimitStore)
+	check(c.ProxyHeader == "x-forwarded-for" || c.ProxyHeader == "forwarded",
+		`proxy_header must be "x-forwarded-for" or "forwarded", not %q`, c.ProxyHeader)
+
+	acme := len(c.TLS.ACMEHosts()) > 0
+	if c.TLS.Enabled {
+		check(acme || (c.TLS.CertFile != "" && c.TLS.KeyFile != ""), "tls.cert_file and tls.key_file must not be empty")
+		check(c.TLS.RedirectAddr != c.Addr, "tls.redirect_addr must be different from addr")
+		check(c.TLS.RedirectAddr == "" || (err == nil && u.Scheme == "https"),
+			"tls.redirect_addr needs an https base_url to redirect to")
+	} else {
+		check(c.TLS.RedirectAddr == "", "tls.redirect_addr can only be used with tls.enabled")
+		check(!acme, "tls.acme_domains can only be used with tls.enabled")
+	}
+	if acme {
+		d, err := url.Parse(c.TLS.ACMEDirectory)
+		check(err == nil && (d.Scheme == "http" || d.Scheme == "https") && d.Host != "",
+			"tls.acme_directory must be an absolute http or https URL, not %q", c.TLS.ACMEDirectory)
+		check(c.TLS.ACMECache == "dir" || c.TLS.ACMECache == "mysql",
+			`tls.acme_cache must be "dir" or "mysql", not %q`, c.TLS.ACMECache)
+		check(c.TLS.ACMECache != "dir" || c.TLS.ACMECacheDir != "", "tls.acme_cache_dir must not be empty")
+	}
+	check(c.TLS.ReloadInterval >= 0, "tls.reload_interval must not be negative")
+	check(c.TLS.HSTSMaxAge >= 0, "tls.hsts_max_age must not be negative")
+
+	check(c.Server.IdleTimeout >= 0, "server.idle_timeout must not be negative")
+	check(c.Server.ReadTimeout >= 0, "server.read_timeout must not be negative")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.Server.ReadinessTimeout > 0, "server.readiness_timeout must be positive")
+
+	check(c.SMTP.Port > 0 && c.SMTP.Port <= 65535, "smtp.port must be between 1 and 65535")
+	check(c.SMTP.Host == "" || c.SMTP.Sender != "", "smtp.sender must not be empty")
+
+	check(c.Session.Lifetime > 0, "session.lifetime must be positive")
+	check(c.Session.IdleTimeout >= 0, "session.idle_timeout must not be negative")
+	check(c.Session.RememberMeLifetime > 0, "session.remember_me_lifetime must be positive")
+
+	check(c.OIDC.Issuer == "" || c.OIDC.ClientID != "", "oidc.client_id is required when oidc.issuer is set")
+
+	check(c.Metrics.Addr == "" || (c.Metrics.Addr != c.Addr && c.Metrics.Addr != c.TLS.RedirectAddr),
+		"metrics.addr must be different from addr and tls.redirect_addr")
+
+	if c.Tracing.OTLPEndpoint != "" {
+		e, err := url.Parse(c.Tracing.OTLPEndpoint)
+		check(err == nil && (e.Scheme == "http" || e.Scheme == "https") && e.Host != "",
+			"tracing.otlp_endpoint must be an absolute http or https URL, not %q", c.Tracing.OTLPEndpoint)
+		_, err = ParseHeaders(c.Tracing.OTLPHeaders)
+		check(err == nil, "tracing.otlp_headers must be comma-separated key=value pairs")
+		check(c.Tracing.ServiceName != "", "tracing.service_name must not be empty")
+	}
+
+	return errors.Join(errs...)
+}
+
+// WriteTOML writes the configuration to w as a TOML file, with secrets
+// redacted. Load can read the output once the secrets are filled in again.
+// The password in the DSN is redacted, leaving the rest of it readable.
+func (c *Config) WriteTOML(w io.Writer) error {
+	// Bind the options to a copy, so that c can't be changed.
+	cp := *c
+	o := cp.options("")
+
+	doc := make(map[string]any)
+	for _, opt := range o.list {
+		v := o.fs.Lookup(opt.flag).Value.(flag.Getter).Get()
+
+		switch value := v.(type) {
+		case time.Duration:
+			v = value.String()
+		case string:
+			if opt.secret && value != "" {
+				v = redact(opt.key, value)
+			}
+		}
+
+		table := doc
+		section, key, ok := strings.Cut(opt.key, ".")
+		if ok {
+			if _, exists := doc[section]; !exists {
+				doc[section] = make(map[string]any)
+			}
+			table = doc[section].(map[string]any)
+		} else {
+			key = section
+		}
+		table[key] = v
+	}
+
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	err := enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// redact hides a secret. Only the password in a DSN, and the values of
+// OTLP headers, are hidden.
+func redact(key, value string) string {
+	if key == "tracing.otlp_headers" {
+		headers, err := ParseHeaders(value)
+		if err != nil {
+			return redacted
+		}
+
+		pairs := make([]string, 0, len(headers))
+		for k := range headers {
+			pairs = append(pairs, k+"="+redacted)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	}
+
+	if key != "dsn" {
+		return redacted
+	}
+
+	dsn, err := mysql.ParseDSN(value)
+	if err != nil {
+		return redacted
+	}
+	if dsn.Passwd != "" {
+		dsn.Passwd = redacted
+	}
+	return dsn.FormatDSN()
+}
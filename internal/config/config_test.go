@@ -0,0 +1,409 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// load calls Load, discarding the usage message printed for invalid flags.
+func load(args, environ []string) (*Config, error) {
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	defer func() { os.Stderr = stderr }()
+
+	cfg, _, err := Load("snippetbox", args, environ)
+	return cfg, err
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(nil, nil)
+	assert.NilError(t, err)
+
+	want := Default()
+	assert.Equal(t, *cfg, want)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join("testdata", "config.toml")
+
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "File",
+			args: []string{"-config", file},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.Addr, ":8000")
+				assert.Equal(t, cfg.TrustedProxies, "10.0.0.0/8,192.168.1.10")
+				assert.Equal(t, cfg.ProxyHeader, "forwarded")
+				assert.Equal(t, cfg.Server.ReadTimeout, 7*time.Second)
+				assert.Equal(t, cfg.Server.WriteTimeout, 10*time.Second)
+				assert.Equal(t, cfg.SMTP.Host, "smtp.example.com")
+				assert.Equal(t, cfg.SMTP.Port, 2525)
+				assert.Equal(t, cfg.Session.Lifetime, 6*time.Hour)
+				assert.Equal(t, cfg.ConfigFile, file)
+			},
+		},
+		{
+			name: "YAML file",
+			args: []string{"-config", filepath.Join("testdata", "config.yaml")},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.Addr, ":8000")
+				assert.Equal(t, cfg.TrustedProxies, "10.0.0.0/8,192.168.1.10")
+				assert.Equal(t, cfg.ProxyHeader, "forwarded")
+				assert.Equal(t, cfg.Server.ReadTimeout, 7*time.Second)
+				assert.Equal(t, cfg.SMTP.Host, "smtp.example.com")
+				assert.Equal(t, cfg.SMTP.Port, 2525)
+				assert.Equal(t, cfg.Session.Lifetime, 6*time.Hour)
+			},
+		},
+		{
+			name:    "File from environment",
+			environ: []string{"SNIPPETBOX_CONFIG=" + file},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.Addr, ":8000")
+				assert.Equal(t, cfg.ConfigFile, file)
+			},
+		},
+		{
+			name:    "Environment overrides file",
+			args:    []string{"-config", file},
+			environ: []string{"SNIPPETBOX_ADDR=:9000", "SNIPPETBOX_SESSION_LIFETIME=1h", "SNIPPETBOX_DEBUG=true", "HOME=/root"},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.Addr, ":9000")
+				assert.Equal(t, cfg.Session.Lifetime, time.Hour)
+				assert.Equal(t, cfg.Debug, true)
+				assert.Equal(t, cfg.SMTP.Port, 2525)
+			},
+		},
+		{
+			name:    "Flags override environment",
+			args:    []string{"-config", file, "-addr", ":9500", "-smtp-port=25"},
+			environ: []string{"SNIPPETBOX_ADDR=:9000", "SNIPPETBOX_SMTP_PORT=465"},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.Addr, ":9500")
+				assert.Equal(t, cfg.SMTP.Port, 25)
+				assert.Equal(t, cfg.Session.Lifetime, 6*time.Hour)
+			},
+		},
+		{
+			name:    "Flag set to its default still overrides",
+			args:    []string{"-config", file, "-addr", ":4000"},
+			environ: []string{"SNIPPETBOX_ADDR=:9000"},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.Addr, ":4000")
+			},
+		},
+		{
+			name: "Plain HTTP without certificates",
+			args: []string{"-tls=false", "-tls-cert", "", "-tls-key", ""},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.TLS.Enabled, false)
+			},
+		},
+		{
+			name:    "ACME without certificates",
+			args:    []string{"-tls-cert", "", "-tls-key", ""},
+			environ: []string{"SNIPPETBOX_ACME_DOMAINS=snippetbox.example.com, www.snippetbox.example.com"},
+			check: func(t *testing.T, cfg *Config) {
+				hosts := cfg.TLS.ACMEHosts()
+				assert.Equal(t, len(hosts), 2)
+				assert.Equal(t, hosts[0], "snippetbox.example.com")
+				assert.Equal(t, hosts[1], "www.snippetbox.example.com")
+				assert.Equal(t, cfg.TLS.ACMECache, "dir")
+			},
+		},
+		{
+			name: "Print config",
+			args: []string{"-print-config"},
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, cfg.PrintConfig, true)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(tt.args, tt.environ)
+			assert.NilError(t, err)
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		wantErr string
+	}{
+		{
+			name:    "Missing file",
+			args:    []string{"-config", filepath.Join("testdata", "missing.toml")},
+			wantErr: "no such file",
+		},
+		{
+			name:    "Unknown file setting",
+			args:    []string{"-config", filepath.Join("testdata", "unknown.toml")},
+			wantErr: `unknown setting "smtp.hots"`,
+		},
+		{
+			name:    "Invalid file value",
+			args:    []string{"-config", filepath.Join("testdata", "invalid.toml")},
+			wantErr: "session.lifetime: parse error",
+		},
+		{
+			name:    "Unknown YAML file setting",
+			args:    []string{"-config", filepath.Join("testdata", "unknown.yml")},
+			wantErr: `unknown setting "smtp.hots"`,
+		},
+		{
+			name:    "Invalid environment value",
+			environ: []string{"SNIPPETBOX_SMTP_PORT=smtp"},
+			wantErr: "SNIPPETBOX_SMTP_PORT: parse error",
+		},
+		{
+			name:    "Invalid flag",
+			args:    []string{"-session-lifetime", "forever"},
+			wantErr: "invalid value",
+		},
+		{
+			name:    "Unexpected argument",
+			args:    []string{"serve"},
+			wantErr: `unexpected argument "serve"`,
+		},
+		{
+			name:    "Short secret",
+			args:    []string{"-secret", "too-short"},
+			wantErr: "secret must be at least 32 characters long",
+		},
+		{
+			name:    "Invalid rate limit store",
+			environ: []string{"SNIPPETBOX_RATE_LIMIT_STORE=redis"},
+			wantErr: `rate_limit_store must be "memory" or "mysql", not "redis"`,
+		},
+		{
+			name:    "Invalid proxy header",
+			args:    []string{"-proxy-header", "x-real-ip"},
+			wantErr: `proxy_header must be "x-forwarded-for" or "forwarded", not "x-real-ip"`,
+		},
+		{
+			name:    "Relative base URL",
+			args:    []string{"-base-url", "/snippetbox"},
+			wantErr: "base_url must be an absolute http or https URL",
+		},
+		{
+			name:    "Redirect without TLS",
+			args:    []string{"-tls=false", "-http-redirect-addr", ":80"},
+			wantErr: "tls.redirect_addr can only be used with tls.enabled",
+		},
+		{
+			name:    "Redirect to HTTP base URL",
+			args:    []string{"-http-redirect-addr", ":80", "-base-url", "http://localhost:4000"},
+			wantErr: "tls.redirect_addr needs an https base_url to redirect to",
+		},
+		{
+			name:    "Redirect on the HTTPS address",
+			args:    []string{"-http-redirect-addr", ":4000"},
+			wantErr: "tls.redirect_addr must be different from addr",
+		},
+		{
+			name:    "Negative reload interval",
+			args:    []string{"-tls-reload-interval", "-1s"},
+			wantErr: "tls.reload_interval must not be negative",
+		},
+		{
+			name:    "ACME without TLS",
+			args:    []string{"-tls=false", "-acme-domains", "snippetbox.example.com"},
+			wantErr: "tls.acme_domains can only be used with tls.enabled",
+		},
+		{
+			name:    "Invalid ACME cache",
+			args:    []string{"-acme-domains", "snippetbox.example.com", "-acme-cache", "redis"},
+			wantErr: `tls.acme_cache must be "dir" or "mysql", not "redis"`,
+		},
+		{
+			name:    "Metrics on the main address",
+			args:    []string{"-metrics-addr", ":4000"},
+			wantErr: "metrics.addr must be different from addr and tls.redirect_addr",
+		},
+		{
+			name:    "Zero query timeout",
+			args:    []string{"-db-query-timeout", "0s"},
+			wantErr: "db.query_timeout must be positive",
+		},
+		{
+			name:    "Negative max open connections",
+			args:    []string{"-db-max-open-conns", "-1"},
+			wantErr: "db.max_open_conns must not be negative",
+		},
+		{
+			name:    "Zero connect timeout",
+			args:    []string{"-db-connect-timeout", "0s"},
+			wantErr: "db.connect_timeout must be positive",
+		},
+		{
+			name:    "Negative stats interval",
+			args:    []string{"-db-stats-interval", "-1m"},
+			wantErr: "db.stats_interval must not be negative",
+		},
+		{
+			name:    "Negative drain delay",
+			args:    []string{"-drain-delay", "-5s"},
+			wantErr: "server.drain_delay must not be negative",
+		},
+		{
+			name:    "Zero readiness timeout",
+			args:    []string{"-readiness-timeout", "0"},
+			wantErr: "server.readiness_timeout must be positive",
+		},
+		{
+			name:    "Invalid log format",
+			args:    []string{"-log-format", "logfmt"},
+			wantErr: `log_format must be "text" or "json", not "logfmt"`,
+		},
+		{
+			name:    "Invalid OTLP endpoint",
+			args:    []string{"-otlp-endpoint", "localhost:4318"},
+			wantErr: `tracing.otlp_endpoint must be an absolute http or https URL, not "localhost:4318"`,
+		},
+		{
+			name:    "Invalid OTLP headers",
+			args:    []string{"-otlp-endpoint", "http://localhost:4318", "-otlp-headers", "x-api-key"},
+			wantErr: "tracing.otlp_headers must be comma-separated key=value pairs",
+		},
+		{
+			name:    "OIDC without client ID",
+			args:    []string{"-oidc-issuer", "https://id.example.com"},
+			wantErr: "oidc.client_id is required when oidc.issuer is set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.args, tt.environ)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			assert.StringContains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadUnknownEnv(t *testing.T) {
+	environ := []string{
+		"SNIPPETBOX_SMTP_HOTS=smtp.example.com",
+		"SNIPPETBOX_PORT=tcp://10.96.0.12:4000",
+		"SNIPPETBOX_ADDR=:9000",
+		"HOME=/root",
+	}
+
+	// Unknown variables are returned, sorted, rather than stopping the
+	// application, and the known ones still apply.
+	cfg, unknown, err := Load("snippetbox", nil, environ)
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Addr, ":9000")
+	assert.Equal(t, len(unknown), 2)
+	assert.Equal(t, unknown[0], "SNIPPETBOX_PORT")
+	assert.Equal(t, unknown[1], "SNIPPETBOX_SMTP_HOTS")
+}
+
+func TestLoadHelp(t *testing.T) {
+	_, err := load([]string{"-h"}, nil)
+	assert.Equal(t, errors.Is(err, flag.ErrHelp), true)
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := Default()
+	cfg.SMTP.Port = 0
+	cfg.Session.Lifetime = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.StringContains(t, err.Error(), "smtp.port must be between 1 and 65535")
+	assert.StringContains(t, err.Error(), "session.lifetime must be positive")
+}
+
+func TestWriteTOML(t *testing.T) {
+	cfg, err := load([]string{"-config", filepath.Join("testdata", "config.toml"), "-oidc-issuer", "https://id.example.com", "-oidc-client-id", "snippetbox", "-oidc-client-secret", "oidc-secret", "-metrics-token", "metrics-secret", "-otlp-endpoint", "http://localhost:4318", "-otlp-headers", "x-api-key=otlp-secret"}, nil)
+	assert.NilError(t, err)
+
+	var buf bytes.Buffer
+	err = cfg.WriteTOML(&buf)
+	assert.NilError(t, err)
+	out := buf.String()
+
+	t.Run("Redacts secrets", func(t *testing.T) {
+		for _, secret := range []string{"file-pass", "file-secret", "smtp-pass", "oidc-secret", "metrics-secret", "otlp-secret"} {
+			if bytes.Contains(buf.Bytes(), []byte(secret)) {
+				t.Errorf("output contains %q:\n%s", secret, out)
+			}
+		}
+		assert.StringContains(t, out, "web:REDACTED@tcp(db:3306)/snippetbox")
+		assert.StringContains(t, out, "client_secret = 'REDACTED'")
+		assert.StringContains(t, out, "otlp_headers = 'x-api-key=REDACTED'")
+	})
+
+	t.Run("Includes settings", func(t *testing.T) {
+		assert.StringContains(t, out, "addr = ':8000'")
+		assert.StringContains(t, out, "[session]")
+		assert.StringContains(t, out, "lifetime = '6h0m0s'")
+		assert.StringContains(t, out, "port = 2525")
+	})
+
+	t.Run("Can be loaded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		err := os.WriteFile(path, buf.Bytes(), 0o600)
+		assert.NilError(t, err)
+
+		// The redacted signing key is too short to be valid, so it has to
+		// be given again.
+		loaded, err := load([]string{"-config", path}, []string{"SNIPPETBOX_SECRET=" + cfg.Secret})
+		assert.NilError(t, err)
+
+		// Everything but the redacted secrets and the file name should
+		// survive the round trip.
+		want := *cfg
+		want.DSN = "web:REDACTED@tcp(db:3306)/snippetbox?parseTime=true"
+		want.SMTP.Password = redacted
+		want.OIDC.ClientSecret = redacted
+		want.Metrics.Token = redacted
+		want.Tracing.OTLPHeaders = "x-api-key=" + redacted
+		want.ConfigFile = path
+		assert.Equal(t, *loaded, want)
+	})
+
+	t.Run("Leaves empty secrets empty", func(t *testing.T) {
+		cfg := Default()
+		var buf bytes.Buffer
+		err := cfg.WriteTOML(&buf)
+		assert.NilError(t, err)
+		assert.StringContains(t, buf.String(), "secret = ''")
+	})
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("x-api-key=secret, x-tenant = snippetbox,")
+	assert.NilError(t, err)
+	assert.Equal(t, len(headers), 2)
+	assert.Equal(t, headers["x-api-key"], "secret")
+	assert.Equal(t, headers["x-tenant"], "snippetbox")
+
+	_, err = ParseHeaders("x-api-key")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,129 @@
+// Package mailer sends transactional emails (such as account verification
+// links) to users. The Mailer interface lets the application swap between a
+// real SMTP server in production, a logging mailer during local development
+// and an in-memory mailer in tests.
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer defines the contract for sending a plain text email message.
+type Mailer interface {
+	Send(recipient, subject, body string) error
+}
+
+// Message is a single email as handed to a Mailer.
+type Message struct {
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// SMTP sends email through an SMTP server using PLAIN authentication.
+type SMTP struct {
+	Host     string // SMTP server host name
+	Port     int    // SMTP server port, usually 587 or 25
+	Username string // Username for PLAIN authentication; empty disables auth
+	Password string // Password for PLAIN authentication
+	Sender   string // Value for the From header, e.g. "Snippetbox <no-reply@snippetbox.net>"
+}
+
+// Send delivers the message through the configured SMTP server.
+func (m *SMTP) Send(recipient, subject, body string) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := buildMessage(m.Sender, recipient, subject, body)
+
+	err := smtp.SendMail(addr, auth, senderAddress(m.Sender), []string{recipient}, msg)
+	if err != nil {
+		return fmt.Errorf("mailer: sending to %s: %w", recipient, err)
+	}
+
+	return nil
+}
+
+// Log writes messages to a structured logger instead of sending them. It's
+// intended for local development, where there is usually no SMTP server.
+type Log struct {
+	Logger *slog.Logger
+}
+
+// Send logs the message at the Info level.
+func (m *Log) Send(recipient, subject, body string) error {
+	m.Logger.Info("email not sent (no SMTP server configured)", "recipient", recipient, "subject", subject, "body", body)
+	return nil
+}
+
+// Memory records every message it is asked to send. It is safe for concurrent
+// use and is intended for tests.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send records the message.
+func (m *Memory) Send(recipient, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, Message{Recipient: recipient, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages returns a copy of all the messages sent so far.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// Last returns the most recently sent message, and false if nothing has been
+// sent yet.
+func (m *Memory) Last() (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		return Message{}, false
+	}
+	return m.messages[len(m.messages)-1], true
+}
+
+// buildMessage assembles an RFC 5322 message with the given headers and body.
+func buildMessage(sender, recipient, subject, body string) []byte {
+	var b strings.Builder
+
+	b.WriteString("From: " + sender + "\r\n")
+	b.WriteString("To: " + recipient + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// senderAddress extracts the bare email address from a sender such as
+// "Snippetbox <no-reply@snippetbox.net>", for use in the SMTP envelope.
+func senderAddress(sender string) string {
+	if i := strings.LastIndexByte(sender, '<'); i >= 0 {
+		return strings.TrimSuffix(sender[i+1:], ">")
+	}
+	return sender
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Audit event actions. Actions are named "<subject>.<verb>" so that related
+// events sort and filter together.
+const (
+	AuditSignup         = "user.signup"
+	AuditLogin          = "user.login"
+	AuditLoginFailed    = "user.login_failed"
+	AuditLoginLocked    = "user.login_locked"
+	AuditLogout         = "user.logout"
+	AuditPasswordChange = "user.password_change"
+	AuditSnippetCreate  = "snippet.create"
+
+	AuditAdminUserDisable       = "admin.user_disable"
+	AuditAdminUserEnable        = "admin.user_enable"
+	AuditAdminUserPasswordReset = "admin.user_password_reset"
+	AuditAdminSnippetDelete     = "admin.snippet_delete"
+
+	AuditSnippetReport      = "snippet.report"
+	AuditSnippetHide        = "snippet.hide"
+	AuditModerationDismiss  = "moderation.dismiss"
+	AuditModerationTakeDown = "moderation.takedown"
+)
+
+// AuditEventModelInterface defines the interface for the audit log, which
+// records security-relevant events so that we can answer "who did what,
+// when". The log is append-only: events can be added and read, but never
+// changed or removed.
+type AuditEventModelInterface interface {
+	Insert(ctx context.Context, event AuditEvent) error
+	List(ctx context.Context, filter Filter) ([]AuditEvent, Metadata, error)
+	Export(ctx context.Context, fn func(AuditEvent) error) error
+}
+
+// AuditEvent represents an entry in the audit log.
+//
+// # Fields
+// - ID: Unique identifier, increasing in the order events were recorded
+// - Time: When the event happened (set by Insert)
+// - ActorID: The user who did it, or 0 if they weren't logged in
+// - ActorEmail: The actor's current email address (set by List and Export)
+// - Action: What they did, one of the Audit* constants
+// - Target: What they did it to, e.g. "snippet:42" or an email address
+// - IP: The client's IP address
+// - UserAgent: The client's User-Agent header
+type AuditEvent struct {
+	ID         int       `json:"id"`
+	Time       time.Time `json:"time"`
+	ActorID    int       `json:"actor_id,omitempty"`
+	ActorEmail string    `json:"actor_email,omitempty"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// AuditEventModel handles the database interactions for the audit log.
+type AuditEventModel struct {
+	DB *sql.DB // Database connection pool
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+}
+
+// Insert appends an event to the audit log. The event's ID, Time and
+// ActorEmail are ignored.
+func (m *AuditEventModel) Insert(ctx context.Context, event AuditEvent) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO audit_events (created, actor_id, action, target, ip, user_agent)
+	VALUES(UTC_TIMESTAMP(), ?, ?, ?, ?, ?)`
+
+	var actorID sql.NullInt64
+	if event.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: int64(event.ActorID), Valid: true}
+	}
+
+	_, err := m.DB.ExecContext(ctx, stmt, actorID, event.Action, truncate(event.Target, 255), event.IP, truncate(event.UserAgent, 255))
+	return err
+}
+
+// auditEventColumns selects the columns scanned by scanAuditEvent.
+const auditEventColumns = `SELECT a.id, a.created, COALESCE(a.actor_id, 0), COALESCE(u.email, ''),
+	a.action, a.target, a.ip, a.user_agent
+	FROM audit_events a LEFT JOIN users u ON u.id = a.actor_id`
+
+// List returns a page of events whose action, target or actor's email
+// address contains the filter's search text, newest first.
+//
+// # Returns
+// - []AuditEvent: The events on the requested page
+// - Metadata: Pagination details, including the total number of matches
+// - error: Any database error
+func (m *AuditEventModel) List(ctx context.Context, filter Filter) ([]AuditEvent, Metadata, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	pattern := filter.pattern()
+
+	var totalRecords int
+
+	stmt := `SELECT COUNT(*) FROM audit_events a LEFT JOIN users u ON u.id = a.actor_id
+	WHERE a.action LIKE ? OR a.target LIKE ? OR u.email LIKE ?`
+
+	err := m.DB.QueryRowContext(ctx, stmt, pattern, pattern, pattern).Scan(&totalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	stmt = auditEventColumns + `
+	WHERE a.action LIKE ? OR a.target LIKE ? OR u.email LIKE ?
+	ORDER BY a.id DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pattern, pattern, pattern, filter.limit(), filter.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return events, calculateMetadata(totalRecords, filter.Page, filter.PageSize), nil
+}
+
+// Export calls fn for every event in the log, oldest first, stopping at the
+// first error. Events are streamed from the database rather than loaded into
+// memory, so the log can be exported however large it grows. For the same
+// reason QueryTimeout doesn't apply: the export stops only if ctx is
+// cancelled, such as when the client downloading it goes away.
+func (m *AuditEventModel) Export(ctx context.Context, fn func(AuditEvent) error) error {
+	rows, err := m.DB.QueryContext(ctx, auditEventColumns+" ORDER BY a.id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// scanAuditEvent scans a row selected with auditEventColumns.
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var e AuditEvent
+	err := rows.Scan(&e.ID, &e.Time, &e.ActorID, &e.ActorEmail, &e.Action, &e.Target, &e.IP, &e.UserAgent)
+	return e, err
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestAuditEventModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := AuditEventModel{DB: db}
+
+	err := m.Insert(context.Background(), AuditEvent{
+		ActorID:   1,
+		Action:    AuditLogin,
+		Target:    "user:1",
+		IP:        "192.0.2.1",
+		UserAgent: "test",
+	})
+	assert.NilError(t, err)
+
+	events, metadata, err := m.List(context.Background(), Filter{Search: "alice", Page: 1, PageSize: 20})
+	assert.NilError(t, err)
+	assert.Equal(t, metadata.TotalRecords, 1)
+	assert.Equal(t, events[0].ActorEmail, "alice@example.com")
+	assert.Equal(t, events[0].Action, AuditLogin)
+
+	// The triggers make the table append-only.
+	_, err = db.Exec("UPDATE audit_events SET action = 'tampered'")
+	if err == nil {
+		t.Error("got nil error updating an audit event")
+	}
+
+	_, err = db.Exec("DELETE FROM audit_events")
+	if err == nil {
+		t.Error("got nil error deleting an audit event")
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// CertCacheModel stores the certificates, private keys and ACME account key
+// obtained by autocert in the acme_cache table, so that every instance of the
+// application shares them and they survive redeploys. It implements
+// autocert.Cache.
+type CertCacheModel struct {
+	DB *sql.DB // Database connection pool
+}
+
+// Get returns the data stored under key, or autocert.ErrCacheMiss if there
+// is none.
+func (m *CertCacheModel) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+
+	err := m.DB.QueryRowContext(ctx, "SELECT data FROM acme_cache WHERE cache_key = ?", key).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, autocert.ErrCacheMiss
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Put stores data under key, replacing anything already there.
+func (m *CertCacheModel) Put(ctx context.Context, key string, data []byte) error {
+	stmt := `INSERT INTO acme_cache (cache_key, data, updated) VALUES(?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE data = VALUES(data), updated = VALUES(updated)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, key, data)
+	return err
+}
+
+// Delete removes the data stored under key. Deleting a missing key isn't an
+// error.
+func (m *CertCacheModel) Delete(ctx context.Context, key string) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM acme_cache WHERE cache_key = ?", key)
+	return err
+}
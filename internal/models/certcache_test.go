@@ -0,0 +1,41 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCertCacheModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := &CertCacheModel{DB: newTestDB(t)}
+
+	_, err := m.Get(ctx, "snippetbox.example.com")
+	assert.Equal(t, err, autocert.ErrCacheMiss)
+
+	err = m.Put(ctx, "snippetbox.example.com", []byte("first"))
+	assert.NilError(t, err)
+
+	err = m.Put(ctx, "snippetbox.example.com", []byte("second"))
+	assert.NilError(t, err)
+
+	data, err := m.Get(ctx, "snippetbox.example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "second")
+
+	err = m.Delete(ctx, "snippetbox.example.com")
+	assert.NilError(t, err)
+
+	_, err = m.Get(ctx, "snippetbox.example.com")
+	assert.Equal(t, err, autocert.ErrCacheMiss)
+
+	err = m.Delete(ctx, "snippetbox.example.com")
+	assert.NilError(t, err)
+}
@@ -21,4 +21,30 @@ var (
 	// ErrDuplicateEmail is returned when attempting to create a user with an email
 	// address that already exists in the database.
 	ErrDuplicateEmail = errors.New("models: duplicate emails")
+
+	// ErrTwoFactorEnabled is returned when attempting to start two-factor
+	// enrolment for a user who has already completed it.
+	ErrTwoFactorEnabled = errors.New("models: two-factor authentication already enabled")
+
+	// ErrAccountLocked is returned when too many failed login attempts have
+	// been made for an account (or from an IP address) and further attempts
+	// are temporarily refused.
+	ErrAccountLocked = errors.New("models: account temporarily locked")
+
+	// ErrUnverifiedEmail is returned when an external identity can't be linked
+	// to an existing user because that user hasn't verified their email
+	// address, so the account may not belong to the identity's owner.
+	ErrUnverifiedEmail = errors.New("models: email address not verified")
+
+	// ErrDuplicateCredential is returned when registering a passkey whose
+	// credential ID is already registered (to any user).
+	ErrDuplicateCredential = errors.New("models: duplicate passkey credential")
+
+	// ErrDuplicateReport is returned when a user reports a snippet which
+	// they have already reported, and their report hasn't been resolved.
+	ErrDuplicateReport = errors.New("models: snippet already reported by user")
+
+	// ErrRateLimited is returned when a request is refused because too many
+	// requests have been made recently with the same key.
+	ErrRateLimited = errors.New("models: rate limit exceeded")
 )
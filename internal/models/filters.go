@@ -0,0 +1,82 @@
+package models
+
+import "strings"
+
+// Filter holds the search and pagination options for admin list queries.
+//
+// # Fields
+// - Search: Text to look for (an empty string matches everything)
+// - Page: The page to return, starting at 1
+// - PageSize: The number of records per page
+type Filter struct {
+	Search   string
+	Page     int
+	PageSize int
+}
+
+// limit returns the page size, for the LIMIT clause.
+func (f Filter) limit() int {
+	return f.PageSize
+}
+
+// offset returns the number of records before the page, for the OFFSET
+// clause.
+func (f Filter) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// pattern returns a LIKE pattern matching values which contain the search
+// text, with LIKE's wildcard characters escaped.
+func (f Filter) pattern() string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(f.Search) + "%"
+}
+
+// Metadata describes a page of results returned for a Filter.
+//
+// # Fields
+// - CurrentPage: The page returned
+// - PageSize: The number of records per page
+// - LastPage: The last page with any records (1 if there are none)
+// - TotalRecords: The number of records matching the filter, on all pages
+type Metadata struct {
+	CurrentPage  int
+	PageSize     int
+	LastPage     int
+	TotalRecords int
+}
+
+// HasPrevious reports whether there is a page before the current one.
+func (m Metadata) HasPrevious() bool {
+	return m.CurrentPage > 1
+}
+
+// HasNext reports whether there is a page after the current one.
+func (m Metadata) HasNext() bool {
+	return m.CurrentPage < m.LastPage
+}
+
+// PreviousPage returns the number of the page before the current one.
+func (m Metadata) PreviousPage() int {
+	return m.CurrentPage - 1
+}
+
+// NextPage returns the number of the page after the current one.
+func (m Metadata) NextPage() int {
+	return m.CurrentPage + 1
+}
+
+// calculateMetadata returns the metadata for a page of results.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	lastPage := (totalRecords + pageSize - 1) / pageSize
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		LastPage:     lastPage,
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestFilter(t *testing.T) {
+	f := Filter{Search: `100%_done\`, Page: 3, PageSize: 20}
+
+	assert.Equal(t, f.limit(), 20)
+	assert.Equal(t, f.offset(), 40)
+	assert.Equal(t, f.pattern(), `%100\%\_done\\%`)
+}
+
+func TestCalculateMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		totalRecords int
+		page         int
+		wantLastPage int
+		wantPrevious bool
+		wantNext     bool
+	}{
+		{"No records", 0, 1, 1, false, false},
+		{"One page", 20, 1, 1, false, false},
+		{"First of several", 41, 1, 3, false, true},
+		{"Middle", 41, 2, 3, true, true},
+		{"Last", 41, 3, 3, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := calculateMetadata(tt.totalRecords, tt.page, 20)
+
+			assert.Equal(t, m.LastPage, tt.wantLastPage)
+			assert.Equal(t, m.HasPrevious(), tt.wantPrevious)
+			assert.Equal(t, m.HasNext(), tt.wantNext)
+		})
+	}
+}
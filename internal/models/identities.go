@@ -0,0 +1,172 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// IdentityModelInterface defines the interface for linking users to
+// identities at external OpenID Connect providers. An identity is the pair of
+// the provider's issuer URL and the user's subject identifier there, which
+// (unlike an email address) never changes.
+type IdentityModelInterface interface {
+	Find(ctx context.Context, issuer, subject string) (int, error)
+	LinkByEmail(ctx context.Context, issuer, subject, email string) (int, error)
+	CreateUser(ctx context.Context, issuer, subject, name, email string) (int, error)
+}
+
+// IdentityModel handles the database interactions for external identities.
+type IdentityModel struct {
+	DB *sql.DB // Database connection pool
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+}
+
+// Find returns the ID of the user linked to an identity.
+//
+// # Returns
+// - int: The linked user's ID
+// - error: ErrNoRecord if the identity isn't linked to anyone, or any other
+// database error
+func (m *IdentityModel) Find(ctx context.Context, issuer, subject string) (int, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var userID int
+
+	stmt := "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// LinkByEmail links an identity to the existing user with the given email
+// address. The caller must only pass an email address which the provider has
+// verified.
+//
+// The user must have verified their email address too. Otherwise someone
+// could sign up with another person's address, wait for them to log in with
+// single sign-on, and share their account.
+//
+// # Returns
+// - int: The linked user's ID
+// - error: nil on success, or:
+//   - ErrNoRecord if there is no user with the email address
+//   - ErrUnverifiedEmail if the user hasn't verified their email address
+//   - Other errors for database failures
+func (m *IdentityModel) LinkByEmail(ctx context.Context, issuer, subject, email string) (int, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var userID int
+	var verified bool
+
+	stmt := "SELECT id, email_verified FROM users WHERE email = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&userID, &verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	if !verified {
+		return 0, ErrUnverifiedEmail
+	}
+
+	stmt = `INSERT INTO user_identities (user_id, issuer, subject, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID, issuer, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CreateUser creates a new user for someone logging in with single sign-on
+// for the first time, and links the identity to them. The user's email
+// address is marked as verified (the caller must only pass one the provider
+// has verified) and they are given a random password, so they can only log
+// in through the provider.
+//
+// # Returns
+// - int: The new user's ID
+// - error: nil on success, or:
+//   - ErrDuplicateEmail if a user with the email address already exists
+//   - Other errors for database failures
+func (m *IdentityModel) CreateUser(ctx context.Context, issuer, subject, name, email string) (int, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	password := make([]byte, 32)
+	_, err := rand.Read(password)
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword(password, 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO users (name, email, hashed_password, created, email_verified)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), TRUE)`
+
+	result, err := tx.ExecContext(ctx, stmt, name, email, string(hashedPassword))
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return 0, ErrDuplicateEmail
+			}
+		}
+		return 0, err
+	}
+
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO user_identities (user_id, issuer, subject, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err = tx.ExecContext(ctx, stmt, userID, issuer, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(userID), nil
+}
@@ -0,0 +1,71 @@
+package mocks
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.tomcat.net/internal/models"
+)
+
+// AuditEventModel is an in-memory implementation of
+// models.AuditEventModelInterface. It keeps the events, so that tests can
+// check which events were recorded.
+type AuditEventModel struct {
+	mu     sync.Mutex
+	events []models.AuditEvent
+}
+
+// Insert appends the event.
+func (m *AuditEventModel) Insert(_ context.Context, event models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = len(m.events) + 1
+	event.Time = time.Now()
+	m.events = append(m.events, event)
+
+	return nil
+}
+
+// List returns the events whose action or target contains the search text,
+// newest first.
+func (m *AuditEventModel) List(_ context.Context, filter models.Filter) ([]models.AuditEvent, models.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.AuditEvent
+	for i := len(m.events) - 1; i >= 0; i-- {
+		e := m.events[i]
+		if strings.Contains(e.Action, filter.Search) || strings.Contains(e.Target, filter.Search) {
+			events = append(events, e)
+		}
+	}
+
+	return paginate(events, filter)
+}
+
+// Export calls fn for each event, oldest first.
+func (m *AuditEventModel) Export(_ context.Context, fn func(models.AuditEvent) error) error {
+	m.mu.Lock()
+	events := append([]models.AuditEvent(nil), m.events...)
+	m.mu.Unlock()
+
+	for _, e := range events {
+		err := fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Events returns all the recorded events, oldest first.
+func (m *AuditEventModel) Events() []models.AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.AuditEvent(nil), m.events...)
+}
@@ -0,0 +1,72 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+
+	"snippetbox.tomcat.net/internal/models"
+)
+
+// IdentityModel is an in-memory implementation of
+// models.IdentityModelInterface. Links are remembered, so that a second
+// single sign-on login finds the identity linked by the first.
+type IdentityModel struct {
+	mu    sync.Mutex
+	links map[[2]string]int
+}
+
+// Find returns the user linked to the identity, or ErrNoRecord.
+func (m *IdentityModel) Find(_ context.Context, issuer, subject string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userID, ok := m.links[[2]string{issuer, subject}]
+	if !ok {
+		return 0, models.ErrNoRecord
+	}
+
+	return userID, nil
+}
+
+// LinkByEmail links the identity to one of the mock users:
+// - alice@example.com (ID 1) and carol@example.com (ID 3) are linked
+// - bob@example.com hasn't verified his email, so ErrUnverifiedEmail is returned
+// - Any other address returns ErrNoRecord
+func (m *IdentityModel) LinkByEmail(_ context.Context, issuer, subject, email string) (int, error) {
+	var userID int
+
+	switch email {
+	case "alice@example.com":
+		userID = 1
+	case "carol@example.com":
+		userID = 3
+	case "bob@example.com":
+		return 0, models.ErrUnverifiedEmail
+	default:
+		return 0, models.ErrNoRecord
+	}
+
+	m.link(issuer, subject, userID)
+	return userID, nil
+}
+
+// CreateUser returns ErrDuplicateEmail for "dupe@example.com", and otherwise
+// links the identity to a new user with ID 4.
+func (m *IdentityModel) CreateUser(_ context.Context, issuer, subject, name, email string) (int, error) {
+	if email == "dupe@example.com" {
+		return 0, models.ErrDuplicateEmail
+	}
+
+	m.link(issuer, subject, 4)
+	return 4, nil
+}
+
+func (m *IdentityModel) link(issuer, subject string, userID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.links == nil {
+		m.links = make(map[[2]string]int)
+	}
+	m.links[[2]string{issuer, subject}] = userID
+}
@@ -0,0 +1,21 @@
+package mocks
+
+import "snippetbox.tomcat.net/internal/models"
+
+// paginate returns the page of records requested by the filter, along with
+// the pagination metadata, as the real models' AdminList methods do.
+func paginate[T any](records []T, filter models.Filter) ([]T, models.Metadata, error) {
+	lastPage := max(1, (len(records)+filter.PageSize-1)/filter.PageSize)
+
+	metadata := models.Metadata{
+		CurrentPage:  filter.Page,
+		PageSize:     filter.PageSize,
+		LastPage:     lastPage,
+		TotalRecords: len(records),
+	}
+
+	start := min(len(records), (filter.Page-1)*filter.PageSize)
+	end := min(len(records), start+filter.PageSize)
+
+	return records[start:end], metadata, nil
+}
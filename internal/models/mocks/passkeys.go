@@ -0,0 +1,105 @@
+package mocks
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"snippetbox.tomcat.net/internal/models"
+)
+
+// PasskeyModel is an in-memory implementation of
+// models.PasskeyModelInterface. It keeps state, so that a passkey registered
+// in a test can then be used to log in.
+type PasskeyModel struct {
+	mu       sync.Mutex
+	nextID   int
+	passkeys []models.Passkey
+}
+
+// Insert stores the passkey in memory, returning ErrDuplicateCredential if
+// the credential ID is already stored.
+func (m *PasskeyModel) Insert(_ context.Context, userID int, name string, credentialID, publicKey []byte, signCount uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.passkeys {
+		if slices.Equal(p.CredentialID, credentialID) {
+			return models.ErrDuplicateCredential
+		}
+	}
+
+	m.nextID++
+	m.passkeys = append(m.passkeys, models.Passkey{
+		ID:           m.nextID,
+		UserID:       userID,
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		SignCount:    signCount,
+		Name:         name,
+		Created:      time.Now(),
+	})
+
+	return nil
+}
+
+// List returns the user's passkeys.
+func (m *PasskeyModel) List(_ context.Context, userID int) ([]models.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var passkeys []models.Passkey
+	for _, p := range m.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+
+	return passkeys, nil
+}
+
+// GetByCredentialID returns the passkey, or ErrNoRecord.
+func (m *PasskeyModel) GetByCredentialID(_ context.Context, credentialID []byte) (models.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.passkeys {
+		if slices.Equal(p.CredentialID, credentialID) {
+			return p, nil
+		}
+	}
+
+	return models.Passkey{}, models.ErrNoRecord
+}
+
+// UpdateSignCount stores the new signature counter.
+func (m *PasskeyModel) UpdateSignCount(_ context.Context, id int, signCount uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.passkeys {
+		if m.passkeys[i].ID == id {
+			m.passkeys[i].SignCount = signCount
+			m.passkeys[i].LastUsed = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the passkey, or returns ErrNoRecord if the user has no such
+// passkey.
+func (m *PasskeyModel) Delete(_ context.Context, userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, p := range m.passkeys {
+		if p.UserID == userID && p.ID == id {
+			m.passkeys = slices.Delete(m.passkeys, i, i+1)
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
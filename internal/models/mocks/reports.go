@@ -0,0 +1,151 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"snippetbox.tomcat.net/internal/models"
+)
+
+// ReportModel is an in-memory implementation of models.ReportModelInterface.
+// It remembers reports and moderation decisions, so that tests can check
+// their effect.
+type ReportModel struct {
+	mu        sync.Mutex
+	reports   []models.Report
+	hidden    map[int]bool
+	takenDown map[int]string
+}
+
+// Insert records the report, returning ErrDuplicateReport if the user
+// already has an open report for the snippet, and otherwise the number of
+// users who have open reports for it.
+func (m *ReportModel) Insert(_ context.Context, snippetID, reporterID int, reason string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reporters := 0
+	for _, r := range m.reports {
+		if r.SnippetID != snippetID {
+			continue
+		}
+		if r.ReporterID == reporterID {
+			return 0, models.ErrDuplicateReport
+		}
+		reporters++
+	}
+
+	m.reports = append(m.reports, models.Report{
+		ID:         len(m.reports) + 1,
+		SnippetID:  snippetID,
+		ReporterID: reporterID,
+		Reason:     reason,
+		Created:    time.Now(),
+	})
+
+	return reporters + 1, nil
+}
+
+// Hide records that the snippet is hidden.
+func (m *ReportModel) Hide(_ context.Context, snippetID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.hidden == nil {
+		m.hidden = make(map[int]bool)
+	}
+	m.hidden[snippetID] = true
+
+	return nil
+}
+
+// Queue returns the mock snippet with its open reports, if it has any.
+func (m *ReportModel) Queue(_ context.Context) ([]models.ReportedSnippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := models.ReportedSnippet{
+		Snippet: mockSnippet,
+		Hidden:  m.hidden[mockSnippet.ID],
+	}
+
+	for _, r := range m.reports {
+		if r.SnippetID == mockSnippet.ID {
+			rs.Reports = append(rs.Reports, r)
+		}
+	}
+
+	if len(rs.Reports) == 0 || m.takenDown[mockSnippet.ID] != "" {
+		return nil, nil
+	}
+
+	return []models.ReportedSnippet{rs}, nil
+}
+
+// Dismiss removes the snippet's reports and unhides it, or returns
+// ErrNoRecord if it has no reports.
+func (m *ReportModel) Dismiss(_ context.Context, snippetID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.resolve(snippetID) {
+		return models.ErrNoRecord
+	}
+	delete(m.hidden, snippetID)
+
+	return nil
+}
+
+// TakeDown records the reason the mock snippet was taken down, or returns
+// ErrNoRecord for any other snippet or if it has already been taken down.
+func (m *ReportModel) TakeDown(_ context.Context, snippetID int, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if snippetID != mockSnippet.ID || m.takenDown[snippetID] != "" {
+		return models.ErrNoRecord
+	}
+
+	if m.takenDown == nil {
+		m.takenDown = make(map[int]string)
+	}
+	m.takenDown[snippetID] = reason
+	m.resolve(snippetID)
+	delete(m.hidden, snippetID)
+
+	return nil
+}
+
+// resolve removes the snippet's reports, reporting whether it had any. The
+// caller must hold m.mu.
+func (m *ReportModel) resolve(snippetID int) bool {
+	open := m.reports[:0]
+	for _, r := range m.reports {
+		if r.SnippetID != snippetID {
+			open = append(open, r)
+		}
+	}
+
+	found := len(open) != len(m.reports)
+	m.reports = open
+
+	return found
+}
+
+// Hidden reports whether the snippet has been hidden.
+func (m *ReportModel) Hidden(snippetID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.hidden[snippetID]
+}
+
+// TakedownReason returns the reason the snippet was taken down, or "" if it
+// hasn't been.
+func (m *ReportModel) TakedownReason(snippetID int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.takenDown[snippetID]
+}
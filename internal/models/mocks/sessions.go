@@ -0,0 +1,123 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"snippetbox.tomcat.net/internal/models"
+)
+
+// UserSessionModel is an in-memory implementation of
+// models.UserSessionModelInterface. Unlike the other mocks it keeps state,
+// because tests for the sessions page need to log in from several clients
+// and then see (and revoke) those sessions.
+type UserSessionModel struct {
+	mu       sync.Mutex
+	nextID   int
+	sessions []models.UserSession
+}
+
+// Record stores the session in memory.
+func (m *UserSessionModel) Record(_ context.Context, token string, userID int, ip, userAgent string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	m.sessions = append(m.sessions, models.UserSession{
+		ID:        m.nextID,
+		UserID:    userID,
+		Token:     token,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   time.Now(),
+		LastSeen:  time.Now(),
+	})
+
+	return nil
+}
+
+// Touch returns ErrNoRecord if the session has been deleted.
+func (m *UserSessionModel) Touch(_ context.Context, token string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.sessions {
+		if m.sessions[i].Token == token {
+			m.sessions[i].LastSeen = now
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
+
+// List returns the user's sessions.
+func (m *UserSessionModel) List(_ context.Context, userID int) ([]models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []models.UserSession
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions, nil
+}
+
+// Get returns one of the user's sessions, or ErrNoRecord.
+func (m *UserSessionModel) Get(_ context.Context, userID, id int) (models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.ID == id {
+			return s, nil
+		}
+	}
+
+	return models.UserSession{}, models.ErrNoRecord
+}
+
+// Delete removes the session with the given token.
+func (m *UserSessionModel) Delete(_ context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions = m.filter(func(s models.UserSession) bool {
+		return s.Token != token
+	})
+
+	return nil
+}
+
+// DeleteOthers removes all the user's sessions except the one with
+// keepToken, returning the removed tokens.
+func (m *UserSessionModel) DeleteOthers(_ context.Context, userID int, keepToken string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []string
+	m.sessions = m.filter(func(s models.UserSession) bool {
+		if s.UserID == userID && s.Token != keepToken {
+			tokens = append(tokens, s.Token)
+			return false
+		}
+		return true
+	})
+
+	return tokens, nil
+}
+
+// filter returns the sessions for which keep returns true.
+func (m *UserSessionModel) filter(keep func(models.UserSession) bool) []models.UserSession {
+	var sessions []models.UserSession
+	for _, s := range m.sessions {
+		if keep(s) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
@@ -10,6 +10,8 @@
 package mocks
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"snippetbox.tomcat.net/internal/models"
@@ -18,6 +20,7 @@ import (
 // Mock data that mimics a real database entry.
 var mockSnippet = models.Snippet{
 	ID:      1,
+	UserID:  1,
 	Title:   "An old silent pond",
 	Content: "An old silent pond...",
 	Created: time.Now(),
@@ -27,7 +30,7 @@ var mockSnippet = models.Snippet{
 type SnippetModel struct{}
 
 // Mock the Insert method.
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(_ context.Context, userID int, title string, content string, expires int) (int, error) {
 	return 2, nil
 }
 
@@ -35,7 +38,7 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 // It simulates fetching a snippet by ID.
 // If the ID is 1, it returns a predefined mock snippet.
 // Otherwise, it returns an ErrNoRecord error, indicating that no record was found.
-func (m *SnippetModel) Get(id int) (models.Snippet, error) {
+func (m *SnippetModel) Get(_ context.Context, id int) (models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
@@ -47,6 +50,55 @@ func (m *SnippetModel) Get(id int) (models.Snippet, error) {
 // Mock the Latest method.
 // It simulates fetching the 10 most recently created snippets.
 // It returns a slice containing the mock snippet.
-func (m *SnippetModel) Latest() ([]models.Snippet, error) {
+func (m *SnippetModel) Latest(_ context.Context) ([]models.Snippet, error) {
 	return []models.Snippet{mockSnippet}, nil
 }
+
+// Mock the TakenDown method.
+// It returns a snippet taken down for spam for the user with ID 1 (the
+// author of the mock snippet), and no snippets for anyone else.
+func (m *SnippetModel) TakenDown(_ context.Context, userID int) ([]models.Snippet, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+
+	return []models.Snippet{{
+		ID:             3,
+		UserID:         1,
+		Title:          "Buy cheap watches",
+		Content:        "Buy cheap watches...",
+		Created:        time.Now(),
+		Expires:        time.Now(),
+		TakenDown:      time.Now(),
+		TakedownReason: "Spam",
+	}}, nil
+}
+
+// Mock the AdminList method.
+// It returns the mock snippet if its title contains the search text.
+func (m *SnippetModel) AdminList(_ context.Context, filter models.Filter) ([]models.Snippet, models.Metadata, error) {
+	var snippets []models.Snippet
+	if strings.Contains(mockSnippet.Title, filter.Search) {
+		snippets = append(snippets, mockSnippet)
+	}
+
+	return paginate(snippets, filter)
+}
+
+// Mock the Counts method.
+// It reports one live snippet and no expired ones.
+func (m *SnippetModel) Counts(_ context.Context) (int, int, error) {
+	return 1, 0, nil
+}
+
+// Mock the Delete method.
+// It simulates deleting the mock snippet with ID 1, and returns ErrNoRecord
+// for any other ID.
+func (m *SnippetModel) Delete(_ context.Context, id int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
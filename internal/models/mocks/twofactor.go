@@ -0,0 +1,74 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.tomcat.net/internal/models"
+)
+
+// Mock two-factor values for the user with ID 3, who has two-factor
+// authentication enabled.
+const (
+	MockTOTPCode     = "123456"
+	MockRecoveryCode = "abcde-fghij"
+)
+
+type TwoFactorModel struct{}
+
+// Mock the Enabled method.
+// Only the user with ID 3 has two-factor authentication enabled.
+func (m *TwoFactorModel) Enabled(_ context.Context, userID int) (bool, error) {
+	return userID == 3, nil
+}
+
+// Mock the Begin method.
+// It returns a fixed secret for any user except ID 3, for whom enrolment has
+// already been completed.
+func (m *TwoFactorModel) Begin(_ context.Context, userID int) ([]byte, error) {
+	if userID == 3 {
+		return nil, models.ErrTwoFactorEnabled
+	}
+
+	return []byte("12345678901234567890"), nil
+}
+
+// Mock the Confirm method.
+// It accepts MockTOTPCode and returns a single recovery code; any other code
+// returns ErrInvalidCredentials.
+func (m *TwoFactorModel) Confirm(_ context.Context, userID int, code string, now time.Time) ([]string, error) {
+	if code != MockTOTPCode {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	return []string{MockRecoveryCode}, nil
+}
+
+// Mock the ValidateCode method.
+// It accepts MockTOTPCode for the user with ID 3 only.
+func (m *TwoFactorModel) ValidateCode(_ context.Context, userID int, code string, now time.Time) error {
+	if userID != 3 {
+		return models.ErrNoRecord
+	}
+
+	if code != MockTOTPCode {
+		return models.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// Mock the UseRecoveryCode method.
+// It accepts MockRecoveryCode for the user with ID 3 only.
+func (m *TwoFactorModel) UseRecoveryCode(_ context.Context, userID int, code string) error {
+	if userID == 3 && code == MockRecoveryCode {
+		return nil
+	}
+
+	return models.ErrInvalidCredentials
+}
+
+// Mock the Disable method.
+func (m *TwoFactorModel) Disable(_ context.Context, userID int) error {
+	return nil
+}
@@ -1,18 +1,31 @@
 package mocks
 
 import (
+	"context"
+	"strings"
+	"sync"
 	"time"
 
 	"snippetbox.tomcat.net/internal/models"
 )
 
-type UserModel struct{}
+// UserModel is a mock implementation of models.UserModelInterface. The mock
+// users are fixed, but the flags set by administrators (disabled and password
+// reset required) are remembered so that admin tests can check their effect.
+type UserModel struct {
+	mu            sync.Mutex
+	disabled      map[int]bool
+	resetRequired map[int]bool
+}
+
+// mockUserIDs are the IDs of the users returned by Get.
+var mockUserIDs = []int{1, 2, 3, 4, 5, 6}
 
 // Mock the Insert method.
 // It simulates a successful user insertion and a duplicate email scenario.
 // If the provided email is "dupe@example.com", it returns an ErrDuplicateEmail error.
 // Otherwise, it simulates a successful insertion and returns nil (no error).
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(_ context.Context, name, email, password string) error {
 	switch email {
 	case "dupe@example.com":
 		return models.ErrDuplicateEmail
@@ -24,22 +37,45 @@ func (m *UserModel) Insert(name, email, password string) error {
 // Mock the Authenticate method.
 // It simulates a successful authentication and invalid credentials scenario.
 // If the provided email is "alice@example.com" and the password is "pa$$word", it returns a user ID of 1.
+// If the provided email is "bob@example.com" (a user who hasn't verified their
+// email address) and the password is "pa$$word", it returns a user ID of 2.
+// If the provided email is "carol@example.com" (a user with two-factor
+// authentication enabled) and the password is "pa$$word", it returns a user ID of 3.
+// If the provided email is "erin@example.com" (a moderator) or
+// "frank@example.com" (an administrator) and the password is "pa$$word", it
+// returns a user ID of 5 or 6.
 // Otherwise, it returns an ErrInvalidCredentials error.
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(_ context.Context, email, password string) (int, error) {
 	if email == "alice@example.com" && password == "pa$$word" {
 		return 1, nil
 	}
 
+	if email == "bob@example.com" && password == "pa$$word" {
+		return 2, nil
+	}
+
+	if email == "carol@example.com" && password == "pa$$word" {
+		return 3, nil
+	}
+
+	if email == "erin@example.com" && password == "pa$$word" {
+		return 5, nil
+	}
+
+	if email == "frank@example.com" && password == "pa$$word" {
+		return 6, nil
+	}
+
 	return 0, models.ErrInvalidCredentials
 }
 
 // Mock the Exists method.
 // It simulates checking if a user exists by ID.
-// If the ID is 1, it returns true (user exists).
+// If the ID is 1 to 6, it returns true (user exists).
 // Otherwise, it returns false (user does not exist).
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(_ context.Context, id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, 2, 3, 4, 5, 6:
 		return true, nil
 	default:
 		return false, nil
@@ -47,17 +83,85 @@ func (m *UserModel) Exists(id int) (bool, error) {
 }
 
 // Get mocks the retrieval of a user by ID.
-// It simulates two scenarios:
-// - If the ID is 1, returns a mock user with ID 1, email "alice@example.com", name "Alice", and current timestamp
+// Users have RoleUser unless stated otherwise. It simulates these scenarios:
+// - If the ID is 1, returns a mock verified user with ID 1, email "alice@example.com", name "Alice", and current timestamp
+// - If the ID is 2, returns a mock unverified user with ID 2, email "bob@example.com", name "Bob", and current timestamp
+// - If the ID is 3, returns a mock verified user with ID 3, email "carol@example.com", name "Carol", and current timestamp
+// - If the ID is 4, returns a mock verified user with email "dave@example.com" and name "Dave", the user
+// created by IdentityModel.CreateUser on his first single sign-on login
+// - If the ID is 5, returns a mock verified moderator with email "erin@example.com" and name "Erin"
+// - If the ID is 6, returns a mock verified administrator with email "frank@example.com" and name "Frank"
 // - For any other ID, returns an empty User and ErrNoRecord to simulate a non-existent user
-func (m *UserModel) Get(id int) (models.User, error) {
+func (m *UserModel) Get(_ context.Context, id int) (models.User, error) {
+	user, err := getMockUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user.Disabled = m.disabled[id]
+	user.PasswordResetRequired = m.resetRequired[id]
+
+	return user, nil
+}
+
+// getMockUser returns one of the fixed mock users.
+func getMockUser(id int) (models.User, error) {
 	switch id {
 	case 1:
 		return models.User{
-			ID:      1,
-			Email:   "alice@example.com",
-			Name:    "Alice",
+			ID:            1,
+			Email:         "alice@example.com",
+			Name:          "Alice",
+			Created:       time.Now(),
+			EmailVerified: true,
+			Role:          models.RoleUser,
+		}, nil
+	case 2:
+		return models.User{
+			ID:      2,
+			Email:   "bob@example.com",
+			Name:    "Bob",
 			Created: time.Now(),
+			Role:    models.RoleUser,
+		}, nil
+	case 3:
+		return models.User{
+			ID:            3,
+			Email:         "carol@example.com",
+			Name:          "Carol",
+			Created:       time.Now(),
+			EmailVerified: true,
+			Role:          models.RoleUser,
+		}, nil
+	case 4:
+		return models.User{
+			ID:            4,
+			Email:         "dave@example.com",
+			Name:          "Dave",
+			Created:       time.Now(),
+			EmailVerified: true,
+			Role:          models.RoleUser,
+		}, nil
+	case 5:
+		return models.User{
+			ID:            5,
+			Email:         "erin@example.com",
+			Name:          "Erin",
+			Created:       time.Now(),
+			EmailVerified: true,
+			Role:          models.RoleModerator,
+		}, nil
+	case 6:
+		return models.User{
+			ID:            6,
+			Email:         "frank@example.com",
+			Name:          "Frank",
+			Created:       time.Now(),
+			EmailVerified: true,
+			Role:          models.RoleAdmin,
 		}, nil
 	default:
 		return models.User{}, models.ErrNoRecord
@@ -69,14 +173,90 @@ func (m *UserModel) Get(id int) (models.User, error) {
 // - If the ID is 1 and current_password matches "pa$$word", it returns nil to simulate a successful update
 // - If the ID is 1 but current_password doesn't match, it returns ErrInvalidCredentials
 // - For any other ID, it returns ErrNoRecord to simulate a non-existent user
-func (m *UserModel) PasswordUpdate(id int, current_password, new_password string) error {
+func (m *UserModel) PasswordUpdate(_ context.Context, id int, current_password, new_password string) error {
 	if id == 1 {
 		if current_password != "pa$$word" {
 			return models.ErrInvalidCredentials
 		}
 
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.resetRequired, id)
+
 		return nil
 	}
 
 	return models.ErrNoRecord
 }
+
+// VerifyEmail mocks marking a user's email address as verified.
+// It returns nil for the email addresses of the mock users, and ErrNoRecord
+// for any other address.
+func (m *UserModel) VerifyEmail(_ context.Context, email string) error {
+	switch email {
+	case "alice@example.com", "bob@example.com", "carol@example.com", "erin@example.com", "frank@example.com":
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+// AdminList returns the mock users whose name or email address contains the
+// search text, newest first.
+func (m *UserModel) AdminList(ctx context.Context, filter models.Filter) ([]models.User, models.Metadata, error) {
+	var users []models.User
+
+	for i := len(mockUserIDs) - 1; i >= 0; i-- {
+		u, err := m.Get(ctx, mockUserIDs[i])
+		if err != nil {
+			return nil, models.Metadata{}, err
+		}
+
+		if strings.Contains(u.Name, filter.Search) || strings.Contains(u.Email, filter.Search) {
+			users = append(users, u)
+		}
+	}
+
+	return paginate(users, filter)
+}
+
+// Count returns the number of mock users.
+func (m *UserModel) Count(_ context.Context) (int, error) {
+	return len(mockUserIDs), nil
+}
+
+// SetDisabled records whether the mock user is disabled, or returns
+// ErrNoRecord for an unknown ID.
+func (m *UserModel) SetDisabled(_ context.Context, id int, disabled bool) error {
+	if _, err := getMockUser(id); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.disabled == nil {
+		m.disabled = make(map[int]bool)
+	}
+	m.disabled[id] = disabled
+
+	return nil
+}
+
+// RequirePasswordReset records that the mock user must change their
+// password, or returns ErrNoRecord for an unknown ID.
+func (m *UserModel) RequirePasswordReset(_ context.Context, id int) error {
+	if _, err := getMockUser(id); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.resetRequired == nil {
+		m.resetRequired = make(map[int]bool)
+	}
+	m.resetRequired[id] = true
+
+	return nil
+}
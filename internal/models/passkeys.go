@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// PasskeyModelInterface defines the interface for storing users' WebAuthn
+// credentials (passkeys), which let them log in without a password.
+type PasskeyModelInterface interface {
+	Insert(ctx context.Context, userID int, name string, credentialID, publicKey []byte, signCount uint32) error
+	List(ctx context.Context, userID int) ([]Passkey, error)
+	GetByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error)
+	UpdateSignCount(ctx context.Context, id int, signCount uint32) error
+	Delete(ctx context.Context, userID, id int) error
+}
+
+// Passkey represents a WebAuthn credential registered by a user.
+//
+// # Fields
+// - ID: Unique identifier, safe to show in pages and forms
+// - UserID: The user the passkey belongs to
+// - CredentialID: The ID chosen by the authenticator
+// - PublicKey: The credential's public key, in PKIX (DER) form
+// - SignCount: The authenticator's signature counter when last used
+// - Name: A label chosen by the user, e.g. "Laptop"
+// - Created: When the passkey was registered
+// - LastUsed: When the passkey was last used to log in (zero if never)
+type Passkey struct {
+	ID           int
+	UserID       int
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    uint32
+	Name         string
+	Created      time.Time
+	LastUsed     time.Time
+}
+
+// PasskeyModel handles the database interactions for passkeys.
+type PasskeyModel struct {
+	DB *sql.DB // Database connection pool
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+}
+
+// Insert stores a newly registered passkey.
+//
+// # Parameters
+// - userID: The user registering the passkey
+// - name: A label for the passkey (truncated to 100 characters)
+// - credentialID, publicKey, signCount: From the verified registration
+//
+// # Returns
+// - error: nil on success, or:
+//   - ErrDuplicateCredential if the credential is already registered
+//   - Other errors for database failures
+func (m *PasskeyModel) Insert(ctx context.Context, userID int, name string, credentialID, publicKey []byte, signCount uint32) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name, created)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, credentialID, publicKey, signCount, truncate(name, 100))
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "webauthn_credentials_uc_credential_id") {
+				return ErrDuplicateCredential
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// List returns a user's passkeys, oldest first.
+func (m *PasskeyModel) List(ctx context.Context, userID int) ([]Passkey, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT id, user_id, credential_id, public_key, sign_count, name, created, last_used
+	FROM webauthn_credentials WHERE user_id = ? ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		passkeys = append(passkeys, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// GetByCredentialID returns the passkey with the given credential ID, which
+// the browser sends when the user logs in.
+//
+// # Returns
+// - error: ErrNoRecord if no such passkey is registered, or any other
+// database error
+func (m *PasskeyModel) GetByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT id, user_id, credential_id, public_key, sign_count, name, created, last_used
+	FROM webauthn_credentials WHERE credential_id = ?`
+
+	p, err := scanPasskey(m.DB.QueryRowContext(ctx, stmt, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Passkey{}, ErrNoRecord
+		} else {
+			return Passkey{}, err
+		}
+	}
+
+	return p, nil
+}
+
+// UpdateSignCount records a successful login with a passkey, storing the
+// authenticator's new signature counter.
+func (m *PasskeyModel) UpdateSignCount(ctx context.Context, id int, signCount uint32) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := "UPDATE webauthn_credentials SET sign_count = ?, last_used = UTC_TIMESTAMP() WHERE id = ?"
+	_, err := m.DB.ExecContext(ctx, stmt, signCount, id)
+	return err
+}
+
+// Delete removes one of a user's passkeys.
+//
+// # Returns
+// - error: ErrNoRecord if there is no such passkey or it belongs to someone
+// else, or any other database error
+func (m *PasskeyModel) Delete(ctx context.Context, userID, id int) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE user_id = ? AND id = ?", userID, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// scanPasskey scans a webauthn_credentials row, as selected by List and
+// GetByCredentialID.
+func scanPasskey(row interface{ Scan(...any) error }) (Passkey, error) {
+	var p Passkey
+	var lastUsed sql.NullTime
+
+	err := row.Scan(&p.ID, &p.UserID, &p.CredentialID, &p.PublicKey, &p.SignCount, &p.Name, &p.Created, &lastUsed)
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	p.LastUsed = lastUsed.Time
+	return p, nil
+}
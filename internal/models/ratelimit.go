@@ -0,0 +1,230 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiterInterface defines the contract for rate limiting requests with
+// token buckets. Each key (such as a user ID or IP address) has its own
+// bucket, which holds up to the limit's Burst tokens and is refilled at a
+// steady rate. Each request takes one token, and is refused if the bucket is
+// empty.
+type RateLimiterInterface interface {
+	Allow(ctx context.Context, key string, limit RateLimit) (time.Duration, error)
+}
+
+// RateLimit describes a token bucket.
+//
+// Fields:
+//   - Requests: Tokens added to the bucket every Per
+//   - Per: The period over which Requests tokens are added
+//   - Burst: The size of the bucket, which is the number of requests that
+//     can be made at once after a quiet spell
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// take takes a token from a bucket which held tokens elapsed ago.
+//
+// # Returns
+// - float64: The tokens left in the bucket
+// - time.Duration: Zero if a token was taken, otherwise how long until one
+// will be available
+func (l RateLimit) take(tokens float64, elapsed time.Duration) (float64, time.Duration) {
+	rate := float64(l.Requests) / l.Per.Seconds() // tokens per second
+
+	tokens = math.Min(float64(l.Burst), tokens+elapsed.Seconds()*rate)
+	if tokens >= 1 {
+		return tokens - 1, 0
+	}
+
+	wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+	return tokens, wait
+}
+
+// untilFull returns how long a bucket holding tokens takes to fill up.
+func (l RateLimit) untilFull(tokens float64) time.Duration {
+	return time.Duration((float64(l.Burst) - tokens) / float64(l.Requests) * float64(l.Per))
+}
+
+// rateLimitSweepInterval is how often idle buckets are removed, so that
+// stores don't grow without limit.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket is a bucket in a MemoryRateLimiter.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket will have refilled, and can be forgotten
+}
+
+// MemoryRateLimiter keeps token buckets in memory. Limits aren't shared
+// between instances of the application; use MySQLRateLimiter for that. It is
+// safe for concurrent use.
+type MemoryRateLimiter struct {
+	// Now returns the current time. It defaults to time.Now and can be
+	// replaced in tests.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimiter returns an empty MemoryRateLimiter.
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		Now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the key's bucket. ctx is unused, as nothing here
+// can block.
+//
+// # Returns
+// - time.Duration: If the bucket is empty, how long until a request is allowed
+// - error: nil if the request may go ahead, ErrRateLimited otherwise
+func (l *MemoryRateLimiter) Allow(_ context.Context, key string, limit RateLimit) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	tokens, wait := limit.take(b.tokens, now.Sub(b.updated))
+	b.tokens = tokens
+	b.updated = now
+	b.full = now.Add(limit.untilFull(tokens))
+
+	if wait > 0 {
+		return wait, ErrRateLimited
+	}
+
+	return 0, nil
+}
+
+// sweep removes buckets which have refilled, as they behave the same as new
+// buckets. The caller must hold l.mu.
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// mySQLRateLimitIdle is how long a bucket in MySQLRateLimiter can go
+// unused before it's deleted. Buckets which take longer than this to refill
+// are reset to full when deleted, so limits should refill within a day.
+const mySQLRateLimitIdle = 24 * time.Hour
+
+// MySQLRateLimiter keeps token buckets in the rate_limits table, so that
+// every instance of the application shares the same limits. Times are taken
+// from the database server, so instances' clocks needn't agree.
+type MySQLRateLimiter struct {
+	DB *sql.DB // Database connection pool
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// Allow takes a token from the key's bucket, creating the bucket if
+// necessary. The bucket's row is locked while it's updated, so concurrent
+// requests can't both take the last token.
+//
+// # Returns
+// - time.Duration: If the bucket is empty, how long until a request is allowed
+// - error: nil if the request may go ahead, ErrRateLimited if it must wait,
+// or a database error
+func (m *MySQLRateLimiter) Allow(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.sweep(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	key = truncate(key, 255)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT IGNORE INTO rate_limits (bucket_key, tokens, updated)
+	VALUES(?, ?, UTC_TIMESTAMP(6))`
+
+	_, err = tx.ExecContext(ctx, stmt, key, limit.Burst)
+	if err != nil {
+		return 0, err
+	}
+
+	var tokens float64
+	var updated, now time.Time
+
+	stmt = `SELECT tokens, updated, UTC_TIMESTAMP(6) FROM rate_limits
+	WHERE bucket_key = ? FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, stmt, key).Scan(&tokens, &updated, &now)
+	if err != nil {
+		return 0, err
+	}
+
+	tokens, wait := limit.take(tokens, now.Sub(updated))
+
+	_, err = tx.ExecContext(ctx, "UPDATE rate_limits SET tokens = ?, updated = ? WHERE bucket_key = ?", tokens, now, key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if wait > 0 {
+		return wait, ErrRateLimited
+	}
+
+	return 0, nil
+}
+
+// sweep deletes buckets which haven't been used for a day, at most once a
+// minute.
+func (m *MySQLRateLimiter) sweep(ctx context.Context) error {
+	m.mu.Lock()
+	if time.Since(m.lastSweep) < rateLimitSweepInterval {
+		m.mu.Unlock()
+		return nil
+	}
+	m.lastSweep = time.Now()
+	m.mu.Unlock()
+
+	stmt := "DELETE FROM rate_limits WHERE updated < UTC_TIMESTAMP(6) - INTERVAL ? SECOND"
+	_, err := m.DB.ExecContext(ctx, stmt, int(mySQLRateLimitIdle.Seconds()))
+	return err
+}
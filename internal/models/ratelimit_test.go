@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)}
+
+	limiter := NewMemoryRateLimiter()
+	limiter.Now = clock.Now
+
+	// One request a minute, with bursts of up to three.
+	limit := RateLimit{Requests: 1, Per: time.Minute, Burst: 3}
+
+	// A new bucket is full, so the first three requests are allowed.
+	for i := 0; i < 3; i++ {
+		_, err := limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+		assert.NilError(t, err)
+	}
+
+	wait, err := limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+	assert.Equal(t, err, ErrRateLimited)
+	assert.Equal(t, wait, time.Minute)
+
+	// Other keys have their own buckets.
+	_, err = limiter.Allow(context.Background(), "ip:192.0.2.2", limit)
+	assert.NilError(t, err)
+
+	// Tokens are added steadily.
+	clock.Advance(45 * time.Second)
+	wait, err = limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+	assert.Equal(t, err, ErrRateLimited)
+	assert.Equal(t, wait, 15*time.Second)
+
+	clock.Advance(15 * time.Second)
+	_, err = limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+	assert.NilError(t, err)
+
+	// After a quiet spell the bucket refills, but no further than Burst.
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		_, err := limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+		assert.NilError(t, err)
+	}
+
+	_, err = limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+	assert.Equal(t, err, ErrRateLimited)
+}
+
+func TestMemoryRateLimiterSweep(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)}
+
+	limiter := NewMemoryRateLimiter()
+	limiter.Now = clock.Now
+
+	limit := RateLimit{Requests: 1, Per: time.Minute, Burst: 3}
+
+	_, err := limiter.Allow(context.Background(), "ip:192.0.2.1", limit)
+	assert.NilError(t, err)
+
+	// Full buckets are forgotten.
+	clock.Advance(2 * time.Minute)
+	_, err = limiter.Allow(context.Background(), "ip:192.0.2.2", limit)
+	assert.NilError(t, err)
+	assert.Equal(t, len(limiter.buckets), 1)
+}
+
+func TestMySQLRateLimiter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	limiter := &MySQLRateLimiter{DB: newTestDB(t)}
+	limit := RateLimit{Requests: 1, Per: time.Hour, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		_, err := limiter.Allow(context.Background(), "user:1", limit)
+		assert.NilError(t, err)
+	}
+
+	wait, err := limiter.Allow(context.Background(), "user:1", limit)
+	assert.Equal(t, err, ErrRateLimited)
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("got wait %v; want between 0 and an hour", wait)
+	}
+
+	_, err = limiter.Allow(context.Background(), "user:2", limit)
+	assert.NilError(t, err)
+}
@@ -0,0 +1,230 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ReportModelInterface defines the contract for abuse reports and the
+// moderation queue.
+type ReportModelInterface interface {
+	Insert(ctx context.Context, snippetID, reporterID int, reason string) (int, error)
+	Hide(ctx context.Context, snippetID int) error
+	Queue(ctx context.Context) ([]ReportedSnippet, error)
+	Dismiss(ctx context.Context, snippetID int) error
+	TakeDown(ctx context.Context, snippetID int, reason string) error
+}
+
+// Report is a user's report that a snippet is abusive.
+type Report struct {
+	ID            int
+	SnippetID     int
+	ReporterID    int
+	ReporterEmail string
+	Reason        string
+	Created       time.Time
+}
+
+// ReportedSnippet is a snippet in the moderation queue, with its open
+// reports. Hidden is true if the snippet was hidden automatically because
+// of the number of reports.
+type ReportedSnippet struct {
+	Snippet Snippet
+	Hidden  bool
+	Reports []Report
+}
+
+// ReportModel wraps a sql.DB connection pool and implements
+// ReportModelInterface.
+type ReportModel struct {
+	DB *sql.DB // Database connection pool
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+}
+
+// Insert records a user's report of a snippet, and returns the number of
+// distinct users with open reports for the snippet, so that the caller can
+// hide it once there are too many.
+//
+// Returns ErrDuplicateReport if the user already has an open report for the
+// snippet. Once a moderator has resolved their report, they can report the
+// snippet again.
+func (m *ReportModel) Insert(ctx context.Context, snippetID, reporterID int, reason string) (int, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO snippet_reports (snippet_id, reporter_id, reason, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.ExecContext(ctx, stmt, snippetID, reporterID, reason)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "snippet_reports_uc_snippet_open_reporter") {
+				return 0, ErrDuplicateReport
+			}
+		}
+		return 0, err
+	}
+
+	var reporters int
+
+	stmt = `SELECT COUNT(DISTINCT reporter_id) FROM snippet_reports
+	WHERE snippet_id = ? AND resolved IS NULL`
+
+	err = m.DB.QueryRowContext(ctx, stmt, snippetID).Scan(&reporters)
+	if err != nil {
+		return 0, err
+	}
+
+	return reporters, nil
+}
+
+// Hide hides a snippet until a moderator reviews it. Hidden snippets aren't
+// returned by SnippetModel.Get or Latest.
+func (m *ReportModel) Hide(ctx context.Context, snippetID int) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE snippets SET hidden = TRUE WHERE id = ?", snippetID)
+	return err
+}
+
+// Queue returns the snippets with open reports for moderators to review.
+// Hidden snippets come first, then the snippets with the oldest reports.
+func (m *ReportModel) Queue(ctx context.Context) ([]ReportedSnippet, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT r.id, r.snippet_id, r.reporter_id, COALESCE(u.email, ''), r.reason, r.created,
+		COALESCE(s.user_id, 0), s.title, s.content, s.created, s.expires, s.hidden
+	FROM snippet_reports r
+	JOIN snippets s ON s.id = r.snippet_id
+	LEFT JOIN users u ON u.id = r.reporter_id
+	WHERE r.resolved IS NULL AND s.taken_down IS NULL
+	ORDER BY s.hidden DESC, r.snippet_id, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queue []ReportedSnippet
+
+	for rows.Next() {
+		var r Report
+		var rs ReportedSnippet
+
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.ReporterID, &r.ReporterEmail, &r.Reason, &r.Created,
+			&rs.Snippet.UserID, &rs.Snippet.Title, &rs.Snippet.Content, &rs.Snippet.Created, &rs.Snippet.Expires, &rs.Hidden)
+		if err != nil {
+			return nil, err
+		}
+
+		// Rows are ordered by snippet, so a new snippet starts a new entry.
+		if len(queue) == 0 || queue[len(queue)-1].Snippet.ID != r.SnippetID {
+			rs.Snippet.ID = r.SnippetID
+			queue = append(queue, rs)
+		}
+
+		last := &queue[len(queue)-1]
+		last.Reports = append(last.Reports, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return queue, nil
+}
+
+// Dismiss closes the open reports for a snippet without taking it down, and
+// unhides the snippet if it was hidden.
+//
+// Returns ErrNoRecord if the snippet has no open reports.
+func (m *ReportModel) Dismiss(ctx context.Context, snippetID int) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE snippet_reports SET resolved = UTC_TIMESTAMP(), resolution = 'dismissed'
+	WHERE snippet_id = ? AND resolved IS NULL`
+
+	result, err := tx.ExecContext(ctx, stmt, snippetID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE snippets SET hidden = FALSE WHERE id = ?", snippetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TakeDown soft-deletes a snippet, recording the reason to show its author,
+// and closes its open reports. Taken-down snippets are kept in the database
+// but aren't returned by SnippetModel.Get or Latest.
+//
+// Returns ErrNoRecord if there is no such snippet, or it has already been
+// taken down.
+func (m *ReportModel) TakeDown(ctx context.Context, snippetID int, reason string) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE snippets SET taken_down = UTC_TIMESTAMP(), takedown_reason = ?, hidden = FALSE
+	WHERE id = ? AND taken_down IS NULL`
+
+	result, err := tx.ExecContext(ctx, stmt, reason, snippetID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	stmt = `UPDATE snippet_reports SET resolved = UTC_TIMESTAMP(), resolution = 'taken_down'
+	WHERE snippet_id = ? AND resolved IS NULL`
+
+	_, err = tx.ExecContext(ctx, stmt, snippetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
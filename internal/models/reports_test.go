@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestReportModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	snippets := SnippetModel{DB: db}
+	reports := ReportModel{DB: db}
+
+	id, err := snippets.Insert(context.Background(), 1, "An old silent pond", "An old silent pond...", 7)
+	assert.NilError(t, err)
+
+	reporters, err := reports.Insert(context.Background(), id, 2, "Spam")
+	assert.NilError(t, err)
+	assert.Equal(t, reporters, 1)
+
+	_, err = reports.Insert(context.Background(), id, 2, "Still spam")
+	assert.Equal(t, err, ErrDuplicateReport)
+
+	reporters, err = reports.Insert(context.Background(), id, 3, "Offensive")
+	assert.NilError(t, err)
+	assert.Equal(t, reporters, 2)
+
+	// Hidden snippets are left out of Get, but stay in the queue.
+	assert.NilError(t, reports.Hide(context.Background(), id))
+	_, err = snippets.Get(context.Background(), id)
+	assert.Equal(t, err, ErrNoRecord)
+
+	queue, err := reports.Queue(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(queue), 1)
+	assert.Equal(t, queue[0].Snippet.ID, id)
+	assert.Equal(t, queue[0].Hidden, true)
+	assert.Equal(t, len(queue[0].Reports), 2)
+
+	// Dismissing the reports shows the snippet again.
+	assert.NilError(t, reports.Dismiss(context.Background(), id))
+	_, err = snippets.Get(context.Background(), id)
+	assert.NilError(t, err)
+	assert.Equal(t, reports.Dismiss(context.Background(), id), ErrNoRecord)
+
+	// Taken-down snippets are left out of Get and Latest, and listed for
+	// their author.
+	assert.NilError(t, reports.TakeDown(context.Background(), id, "Spam"))
+	assert.Equal(t, reports.TakeDown(context.Background(), id, "Spam"), ErrNoRecord)
+
+	_, err = snippets.Get(context.Background(), id)
+	assert.Equal(t, err, ErrNoRecord)
+
+	latest, err := snippets.Latest(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(latest), 0)
+
+	takenDown, err := snippets.TakenDown(context.Background(), 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(takenDown), 1)
+	assert.Equal(t, takenDown[0].TakedownReason, "Spam")
+}
+
+func TestReportModelReportAgain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	snippets := SnippetModel{DB: db}
+	reports := ReportModel{DB: db}
+
+	id, err := snippets.Insert(context.Background(), 1, "An old silent pond", "An old silent pond...", 7)
+	assert.NilError(t, err)
+
+	_, err = reports.Insert(context.Background(), id, 2, "Spam")
+	assert.NilError(t, err)
+	assert.NilError(t, reports.Dismiss(context.Background(), id))
+
+	// Once their report has been dismissed, a user can report the snippet
+	// again, for example with a better reason.
+	reporters, err := reports.Insert(context.Background(), id, 2, "Spam: links to a phishing site")
+	assert.NilError(t, err)
+	assert.Equal(t, reporters, 1)
+
+	// But only once while that report is open.
+	_, err = reports.Insert(context.Background(), id, 2, "Still spam")
+	assert.Equal(t, err, ErrDuplicateReport)
+
+	queue, err := reports.Queue(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(queue), 1)
+	assert.Equal(t, len(queue[0].Reports), 1)
+	assert.Equal(t, queue[0].Reports[0].Reason, "Spam: links to a phishing site")
+}
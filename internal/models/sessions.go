@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time is
+// written, so that busy sessions don't cause a database write per request.
+const sessionTouchInterval = time.Minute
+
+// UserSessionModelInterface defines the interface for recording metadata
+// about logged-in sessions, so users can see where they are logged in and
+// sign out remotely. The session data itself lives in the scs session store;
+// rows here are keyed by the same session token.
+type UserSessionModelInterface interface {
+	Record(ctx context.Context, token string, userID int, ip, userAgent string) error
+	Touch(ctx context.Context, token string, now time.Time) error
+	List(ctx context.Context, userID int) ([]UserSession, error)
+	Get(ctx context.Context, userID, id int) (UserSession, error)
+	Delete(ctx context.Context, token string) error
+	DeleteOthers(ctx context.Context, userID int, keepToken string) ([]string, error)
+}
+
+// UserSession represents a logged-in session.
+//
+// # Fields
+// - ID: Unique identifier, safe to show in pages and forms
+// - UserID: The user who is logged in
+// - Token: The scs session token (never shown to users)
+// - IP: Client IP address when the session was created
+// - UserAgent: Client User-Agent header when the session was created
+// - Created: When the user logged in
+// - LastSeen: Roughly when the session was last used
+type UserSession struct {
+	ID        int
+	UserID    int
+	Token     string
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// UserSessionModel handles the database interactions for session metadata.
+type UserSessionModel struct {
+	DB *sql.DB // Database connection pool
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+}
+
+// Record stores metadata for a newly logged-in session.
+//
+// # Parameters
+// - token: The scs session token
+// - userID: The ID of the user who logged in
+// - ip: The client's IP address
+// - userAgent: The client's User-Agent header (truncated to 255 characters)
+func (m *UserSessionModel) Record(ctx context.Context, token string, userID int, ip, userAgent string) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO user_sessions (token, user_id, ip, user_agent, created, last_seen)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+
+	_, err := m.DB.ExecContext(ctx, stmt, token, userID, ip, truncate(userAgent, 255))
+	return err
+}
+
+// Touch updates the last-seen time of a session, at most once every
+// sessionTouchInterval.
+//
+// # Returns
+// - error: nil on success, or:
+//   - ErrNoRecord if there is no metadata for the session, meaning it has
+//     been revoked and must not be used
+//   - Other errors for database failures
+func (m *UserSessionModel) Touch(ctx context.Context, token string, now time.Time) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var lastSeen time.Time
+
+	stmt := "SELECT last_seen FROM user_sessions WHERE token = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, token).Scan(&lastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	if now.Sub(lastSeen) < sessionTouchInterval {
+		return nil
+	}
+
+	stmt = "UPDATE user_sessions SET last_seen = UTC_TIMESTAMP() WHERE token = ?"
+	_, err = m.DB.ExecContext(ctx, stmt, token)
+	return err
+}
+
+// List returns all the sessions recorded for a user, most recently used
+// first.
+func (m *UserSessionModel) List(ctx context.Context, userID int) ([]UserSession, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT id, user_id, token, ip, user_agent, created, last_seen FROM user_sessions
+	WHERE user_id = ? ORDER BY last_seen DESC`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []UserSession
+
+	for rows.Next() {
+		var s UserSession
+		err = rows.Scan(&s.ID, &s.UserID, &s.Token, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Get returns a single session belonging to the user.
+//
+// # Returns
+// - error: ErrNoRecord if there is no such session or it belongs to someone
+// else, or any other database error
+func (m *UserSessionModel) Get(ctx context.Context, userID, id int) (UserSession, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var s UserSession
+
+	stmt := `SELECT id, user_id, token, ip, user_agent, created, last_seen FROM user_sessions
+	WHERE user_id = ? AND id = ?`
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID, id).Scan(&s.ID, &s.UserID, &s.Token, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserSession{}, ErrNoRecord
+		} else {
+			return UserSession{}, err
+		}
+	}
+
+	return s, nil
+}
+
+// Delete removes the metadata for a session. Deleting a session which
+// doesn't exist is not an error.
+func (m *UserSessionModel) Delete(ctx context.Context, token string) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM user_sessions WHERE token = ?", token)
+	return err
+}
+
+// DeleteOthers removes the metadata for all of a user's sessions except the
+// one with keepToken.
+//
+// # Returns
+// - []string: The tokens of the deleted sessions, which the caller must also
+// delete from the session store
+// - error: Any database error
+func (m *UserSessionModel) DeleteOthers(ctx context.Context, userID int, keepToken string) ([]string, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT token FROM user_sessions WHERE user_id = ? AND token <> ? FOR UPDATE", userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []string
+	for rows.Next() {
+		var token string
+		err = rows.Scan(&token)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ? AND token <> ?", userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
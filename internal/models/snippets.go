@@ -1,43 +1,71 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-// SnippetModelInterface defines the contract for snippet data operations
+// SnippetModelInterface defines the contract for snippet data operations.
+// Each method takes the context of the request it's made for, so that its
+// queries are traced as part of the request and abandoned if the client goes
+// away.
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (Snippet, error)
-	Latest() ([]Snippet, error)
+	Insert(ctx context.Context, userID int, title string, content string, expires int) (int, error)
+	Get(ctx context.Context, id int) (Snippet, error)
+	Latest(ctx context.Context) ([]Snippet, error)
+	TakenDown(ctx context.Context, userID int) ([]Snippet, error)
+
+	// Admin-only operations
+	AdminList(ctx context.Context, filter Filter) ([]Snippet, Metadata, error)
+	Counts(ctx context.Context) (live, expired int, err error)
+	Delete(ctx context.Context, id int) error
 }
 
 // Snippet represents a single snippet in the database.
-// It contains the snippet's ID, title, content, creation time, and expiration time.
+// It contains the snippet's ID, author, title, content, creation time, and expiration time.
 type Snippet struct {
 	ID      int       // Unique identifier for the snippet
+	UserID  int       // ID of the user who created the snippet (0 for snippets created before this was recorded)
 	Title   string    // Title of the snippet
 	Content string    // Content of the snippet
 	Created time.Time // Time when the snippet was created
 	Expires time.Time // Time when the snippet will expire
+
+	// TakenDown is when a moderator took the snippet down (zero if they
+	// haven't), and TakedownReason the reason shown to the author.
+	TakenDown      time.Time
+	TakedownReason string
 }
 
 // SnippetModel wraps a sql.DB connection pool and implements SnippetModelInterface
 type SnippetModel struct {
-	DB *sql.DB // Database connection pool
+	DB     *sql.DB      // Database connection pool
+	Tracer trace.Tracer // Records a span for each method's queries (none if nil)
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
 }
 
 // Insert creates a new snippet record in the database.
-// It takes the snippet's title, content, and expiration period (in days) as parameters.
+// It takes the author's user ID and the snippet's title, content, and expiration period (in days) as parameters.
 // Returns the ID of the newly created snippet or an error if the operation fails.
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, content string, expires int) (_ int, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.Insert")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO snippets (user_id, title, content, created, expires)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
 
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := m.DB.ExecContext(ctx, stmt, userID, title, content, expires)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
 	id, err := result.LastInsertId()
@@ -50,15 +78,22 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 
 // Get retrieves a specific snippet from the database by its ID.
 // It returns the snippet if found, or ErrNoRecord if no matching record exists.
+// Snippets which have expired, been taken down by a moderator, or been
+// hidden after too many reports are treated as not existing.
 // Returns an error if the database operation fails.
-func (m *SnippetModel) Get(id int) (Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`
-	row := m.DB.QueryRow(stmt, id)
+func (m *SnippetModel) Get(ctx context.Context, id int) (_ Snippet, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.Get")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT id, COALESCE(user_id, 0), title, content, created, expires FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND taken_down IS NULL AND NOT hidden AND id = ?`
+	row := m.DB.QueryRowContext(ctx, stmt, id)
 
 	var s Snippet
 
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err = row.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Snippet{}, ErrNoRecord
@@ -70,13 +105,20 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 	return s, nil
 }
 
-// Latest retrieves the 10 most recently created snippets from the database.
+// Latest retrieves the 10 most recently created snippets from the database,
+// leaving out snippets which have been taken down or hidden.
 // It returns a slice of Snippet objects or an error if the database operation fails.
-func (m *SnippetModel) Latest() ([]Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
+func (m *SnippetModel) Latest(ctx context.Context) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.Latest")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
 
-	rows, err := m.DB.Query(stmt)
+	stmt := `SELECT id, COALESCE(user_id, 0), title, content, created, expires FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND taken_down IS NULL AND NOT hidden
+	ORDER BY id DESC LIMIT 10`
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +129,7 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 
 	for rows.Next() {
 		var s Snippet
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
 		if err != nil {
 			return nil, err
 		}
@@ -102,3 +144,135 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 
 	return snippets, nil
 }
+
+// TakenDown returns the user's snippets which moderators have taken down,
+// most recently taken down first, so that the author can see why.
+func (m *SnippetModel) TakenDown(ctx context.Context, userID int) (_ []Snippet, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.TakenDown")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT id, user_id, title, content, created, expires, taken_down, takedown_reason FROM snippets
+	WHERE user_id = ? AND taken_down IS NOT NULL
+	ORDER BY taken_down DESC`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.TakenDown, &s.TakedownReason)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// AdminList returns a page of snippets whose title contains the filter's
+// search text, newest first, for the admin area. Unlike Latest, expired
+// snippets are included.
+//
+// # Returns
+// - []Snippet: The snippets on the requested page
+// - Metadata: Pagination details, including the total number of matches
+// - error: Any database error
+func (m *SnippetModel) AdminList(ctx context.Context, filter Filter) (_ []Snippet, _ Metadata, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.AdminList")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	pattern := filter.pattern()
+
+	var totalRecords int
+
+	err = m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM snippets WHERE title LIKE ?", pattern).Scan(&totalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	stmt := `SELECT id, COALESCE(user_id, 0), title, content, created, expires FROM snippets
+	WHERE title LIKE ?
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pattern, filter.limit(), filter.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return snippets, calculateMetadata(totalRecords, filter.Page, filter.PageSize), nil
+}
+
+// Counts returns the number of live and expired snippets.
+func (m *SnippetModel) Counts(ctx context.Context) (live, expired int, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.Counts")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT
+		COALESCE(SUM(expires > UTC_TIMESTAMP()), 0),
+		COALESCE(SUM(expires <= UTC_TIMESTAMP()), 0)
+	FROM snippets`
+
+	err = m.DB.QueryRowContext(ctx, stmt).Scan(&live, &expired)
+	return live, expired, err
+}
+
+// Delete permanently deletes a snippet, whether or not it has expired.
+//
+// Returns ErrNoRecord if there is no snippet with the given ID.
+func (m *SnippetModel) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "SnippetModel.Delete")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM snippets WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
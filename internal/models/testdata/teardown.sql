@@ -1,3 +1,21 @@
+DROP TABLE acme_cache;
+
+DROP TABLE rate_limits;
+
+DROP TABLE snippet_reports;
+
+DROP TABLE audit_events;
+
+DROP TABLE webauthn_credentials;
+
+DROP TABLE user_identities;
+
+DROP TABLE user_sessions;
+
+DROP TABLE user_recovery_codes;
+
+DROP TABLE user_totp;
+
 DROP TABLE users;
 
 DROP TABLE snippets;
@@ -3,13 +3,16 @@ package models
 import (
 	"database/sql"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 // newTestDB initializes a test database connection pool and executes setup/teardown SQL scripts.
 // It:
 //   - Opens connection to MySQL database with test credentials and parameters
-//   - Executes setup.sql to create tables and test data
+//   - Executes setup.sql to create the tables as they were before any
+//     migrations, then each migration in order, then fixtures.sql to insert
+//     test data
 //   - Registers cleanup function to:
 //   - Execute teardown.sql to drop tables and reset state
 //   - Close database connection
@@ -26,17 +29,31 @@ func newTestDB(t *testing.T) *sql.DB {
 		t.Fatal(err)
 	}
 
-	// Read the setup SQL script from the file and execute the statements, closing
-	// the connection and calling t.Fatal() in the event of an error.
-	script, err := os.ReadFile("./testdata/setup.sql")
+	// Build the schema the way an upgraded deployment's was built: the
+	// original tables, then each migration in order (Glob sorts the files by
+	// name). The test data goes in last.
+	migrations, err := filepath.Glob("../../migrations/*.sql")
 	if err != nil {
 		db.Close()
 		t.Fatal(err)
 	}
-	_, err = db.Exec(string(script))
-	if err != nil {
-		db.Close()
-		t.Fatal(err)
+
+	scripts := append([]string{"./testdata/setup.sql"}, migrations...)
+	scripts = append(scripts, "./testdata/fixtures.sql")
+
+	// Read each SQL script from its file and execute the statements, closing
+	// the connection and calling t.Fatal() in the event of an error.
+	for _, path := range scripts {
+		script, err := os.ReadFile(path)
+		if err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			db.Close()
+			t.Fatalf("%s: %s", path, err)
+		}
 	}
 
 	// Use t.Cleanup() to register a function which will automatically be called
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginThrottleInterface defines the contract for tracking failed login
+// attempts. Attempts are tracked separately per account (by email address)
+// and per client IP address, so that an attacker can't guess one account's
+// password quickly, nor spray guesses across many accounts from one address.
+type LoginThrottleInterface interface {
+	Check(email, ip string) (time.Duration, error)
+	Failed(email, ip string)
+	Succeeded(email, ip string)
+}
+
+// LoginThrottlePolicy controls how quickly failed attempts lead to a lockout.
+//
+// Fields:
+//   - FreeAttempts: Failures allowed before any lockout is applied
+//   - BaseDelay: Lockout applied on reaching FreeAttempts failures. Each
+//     further failure doubles it
+//   - MaxDelay: Upper limit on a single lockout
+//   - ResetAfter: Failures are forgotten once this long has passed since the
+//     last one
+type LoginThrottlePolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	ResetAfter   time.Duration
+}
+
+// lockout returns how long to lock out a key after its nth failure.
+func (p LoginThrottlePolicy) lockout(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	return delay
+}
+
+var (
+	// DefaultAccountPolicy allows five wrong passwords for an account before
+	// locking it for 30 seconds, doubling up to 15 minutes.
+	DefaultAccountPolicy = LoginThrottlePolicy{
+		FreeAttempts: 5,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+
+	// DefaultIPPolicy is more lenient than DefaultAccountPolicy, as many
+	// users can share an IP address (for example behind a corporate NAT).
+	DefaultIPPolicy = LoginThrottlePolicy{
+		FreeAttempts: 20,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+)
+
+// loginAttempts records the failures for a single account or IP address.
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginThrottle tracks failed login attempts in memory and applies an
+// exponential backoff once a policy's free attempts are used up. Lockouts
+// end on their own once their time has passed. It is safe for concurrent use.
+type LoginThrottle struct {
+	// Now returns the current time. It defaults to time.Now and can be
+	// replaced in tests.
+	Now func() time.Time
+
+	accountPolicy LoginThrottlePolicy
+	ipPolicy      LoginThrottlePolicy
+
+	mu        sync.Mutex
+	accounts  map[string]*loginAttempts
+	ips       map[string]*loginAttempts
+	lastSweep time.Time
+}
+
+// throttleSweepInterval is how often expired entries are removed, so that the
+// maps don't grow without limit.
+const throttleSweepInterval = time.Minute
+
+// NewLoginThrottle returns a LoginThrottle which applies accountPolicy to
+// email addresses and ipPolicy to IP addresses.
+func NewLoginThrottle(accountPolicy, ipPolicy LoginThrottlePolicy) *LoginThrottle {
+	return &LoginThrottle{
+		Now:           time.Now,
+		accountPolicy: accountPolicy,
+		ipPolicy:      ipPolicy,
+		accounts:      make(map[string]*loginAttempts),
+		ips:           make(map[string]*loginAttempts),
+	}
+}
+
+// Check reports whether a login attempt for email from ip may go ahead. It
+// should be called before checking the password.
+//
+// # Returns
+// - time.Duration: If locked, how long until another attempt is allowed
+// - error: nil if the attempt may go ahead, ErrAccountLocked otherwise
+func (t *LoginThrottle) Check(email, ip string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+
+	wait := max(
+		t.remaining(t.accounts, t.accountPolicy, normalizeEmail(email), now),
+		t.remaining(t.ips, t.ipPolicy, ip, now),
+	)
+	if wait > 0 {
+		return wait, ErrAccountLocked
+	}
+
+	return 0, nil
+}
+
+// Failed records a failed login attempt for email from ip.
+func (t *LoginThrottle) Failed(email, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+
+	if now.Sub(t.lastSweep) >= throttleSweepInterval {
+		t.sweep(t.accounts, t.accountPolicy, now)
+		t.sweep(t.ips, t.ipPolicy, now)
+		t.lastSweep = now
+	}
+
+	t.fail(t.accounts, t.accountPolicy, normalizeEmail(email), now)
+	t.fail(t.ips, t.ipPolicy, ip, now)
+}
+
+// Succeeded clears the failures recorded against email. Failures recorded
+// against ip are kept, otherwise an attacker who owns one account could use
+// it to reset their IP address's count while guessing others.
+func (t *LoginThrottle) Succeeded(email, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.accounts, normalizeEmail(email))
+}
+
+// remaining returns how long key is still locked for, forgetting its
+// failures if it has been quiet for longer than the policy's ResetAfter.
+func (t *LoginThrottle) remaining(attempts map[string]*loginAttempts, policy LoginThrottlePolicy, key string, now time.Time) time.Duration {
+	a, ok := attempts[key]
+	if !ok {
+		return 0
+	}
+
+	if now.Sub(a.lastFailure) >= policy.ResetAfter {
+		delete(attempts, key)
+		return 0
+	}
+
+	return max(a.lockedUntil.Sub(now), 0)
+}
+
+// fail records a failure against key and, if the policy's free attempts are
+// used up, locks it.
+func (t *LoginThrottle) fail(attempts map[string]*loginAttempts, policy LoginThrottlePolicy, key string, now time.Time) {
+	a, ok := attempts[key]
+	if !ok || now.Sub(a.lastFailure) >= policy.ResetAfter {
+		a = &loginAttempts{}
+		attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+
+	if delay := policy.lockout(a.failures); delay > 0 {
+		a.lockedUntil = now.Add(delay)
+	}
+}
+
+// sweep deletes every key whose failures are old enough to be forgotten.
+func (t *LoginThrottle) sweep(attempts map[string]*loginAttempts, policy LoginThrottlePolicy, now time.Time) {
+	for key, a := range attempts {
+		if now.Sub(a.lastFailure) >= policy.ResetAfter {
+			delete(attempts, key)
+		}
+	}
+}
+
+// normalizeEmail makes lockouts case-insensitive, so that "Alice@Example.com"
+// and "alice@example.com" share a count.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
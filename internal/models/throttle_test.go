@@ -0,0 +1,132 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// fakeClock is an injectable clock for LoginThrottle.Now which only moves
+// when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestThrottle returns a LoginThrottle using a fake clock and small,
+// easily reasoned about policies.
+func newTestThrottle() (*LoginThrottle, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)}
+
+	throttle := NewLoginThrottle(
+		LoginThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, ResetAfter: time.Hour},
+		LoginThrottlePolicy{FreeAttempts: 5, BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, ResetAfter: time.Hour},
+	)
+	throttle.Now = clock.Now
+
+	return throttle, clock
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle, clock := newTestThrottle()
+
+	// The first two failures are free.
+	for i := 0; i < 2; i++ {
+		throttle.Failed("alice@example.com", "192.0.2.1")
+
+		_, err := throttle.Check("alice@example.com", "192.0.2.1")
+		assert.NilError(t, err)
+	}
+
+	// Each failure from the third onwards doubles the lockout, up to the
+	// maximum of four minutes.
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		throttle.Failed("alice@example.com", "192.0.2.1")
+
+		wait, err := throttle.Check("alice@example.com", "192.0.2.1")
+		assert.Equal(t, err, ErrAccountLocked)
+		assert.Equal(t, wait, want)
+
+		// The lockout ends on its own once its time has passed.
+		clock.Advance(want - time.Second)
+		_, err = throttle.Check("alice@example.com", "192.0.2.1")
+		assert.Equal(t, err, ErrAccountLocked)
+
+		clock.Advance(time.Second)
+		_, err = throttle.Check("alice@example.com", "192.0.2.1")
+		assert.NilError(t, err)
+	}
+}
+
+func TestLoginThrottleKeys(t *testing.T) {
+	t.Run("Account locked from any IP", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+
+		for i := 0; i < 3; i++ {
+			throttle.Failed("alice@example.com", "192.0.2.1")
+		}
+
+		_, err := throttle.Check("ALICE@example.com", "198.51.100.7")
+		assert.Equal(t, err, ErrAccountLocked)
+
+		_, err = throttle.Check("bob@example.com", "198.51.100.7")
+		assert.NilError(t, err)
+	})
+
+	t.Run("IP locked for any account", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+
+		for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+			throttle.Failed(email, "192.0.2.1")
+		}
+
+		_, err := throttle.Check("f@example.com", "192.0.2.1")
+		assert.Equal(t, err, ErrAccountLocked)
+
+		_, err = throttle.Check("f@example.com", "198.51.100.7")
+		assert.NilError(t, err)
+	})
+
+	t.Run("Success resets account but not IP", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+
+		for i := 0; i < 2; i++ {
+			throttle.Failed("alice@example.com", "192.0.2.1")
+		}
+		throttle.Succeeded("alice@example.com", "192.0.2.1")
+		throttle.Failed("alice@example.com", "192.0.2.1")
+
+		_, err := throttle.Check("alice@example.com", "192.0.2.1")
+		assert.NilError(t, err)
+
+		for i := 0; i < 2; i++ {
+			throttle.Failed("bob@example.com", "192.0.2.1")
+		}
+
+		// Five failures from the IP in total, so it is now locked.
+		_, err = throttle.Check("carol@example.com", "192.0.2.1")
+		assert.Equal(t, err, ErrAccountLocked)
+	})
+
+	t.Run("Failures forgotten after ResetAfter", func(t *testing.T) {
+		throttle, clock := newTestThrottle()
+
+		for i := 0; i < 2; i++ {
+			throttle.Failed("alice@example.com", "192.0.2.1")
+		}
+
+		clock.Advance(time.Hour)
+		throttle.Failed("alice@example.com", "192.0.2.1")
+
+		_, err := throttle.Check("alice@example.com", "192.0.2.1")
+		assert.NilError(t, err)
+	})
+}
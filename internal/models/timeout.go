@@ -0,0 +1,22 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultQueryTimeout is how long a model method's queries may take when
+// the model's QueryTimeout isn't set.
+const DefaultQueryTimeout = 5 * time.Second
+
+// queryContext returns a context for a model method's queries, which is
+// cancelled after timeout (DefaultQueryTimeout if zero) or when ctx is, such
+// as when the client making the request goes away. A query still running
+// then is abandoned, so that a slow query can't outlive its request. The
+// returned cancel function must be called when the method returns.
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
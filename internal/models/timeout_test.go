@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestQueryContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{name: "Default", timeout: 0, want: DefaultQueryTimeout},
+		{name: "Configured", timeout: time.Second, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			ctx, cancel := queryContext(context.Background(), tt.timeout)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			assert.Equal(t, ok, true)
+			if d := deadline.Sub(start); d < tt.want || d > tt.want+time.Second {
+				t.Errorf("got a deadline %v away; want %v", d, tt.want)
+			}
+		})
+	}
+
+	t.Run("Earlier request deadline", func(t *testing.T) {
+		parent, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+
+		ctx, cancelQuery := queryContext(parent, time.Hour)
+		defer cancelQuery()
+
+		want, _ := parent.Deadline()
+		got, _ := ctx.Deadline()
+		assert.Equal(t, got, want)
+	})
+
+	t.Run("Cancelled request", func(t *testing.T) {
+		parent, cancel := context.WithCancel(context.Background())
+		ctx, cancelQuery := queryContext(parent, time.Hour)
+		defer cancelQuery()
+
+		cancel()
+		<-ctx.Done()
+		assert.Equal(t, errors.Is(ctx.Err(), context.Canceled), true)
+	})
+}
+
+func TestModelQueryTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	t.Run("Snippets", func(t *testing.T) {
+		m := SnippetModel{DB: db, QueryTimeout: time.Nanosecond}
+		_, err := m.Latest(context.Background())
+		assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		m := UserModel{DB: db, QueryTimeout: time.Nanosecond}
+		_, err := m.Exists(context.Background(), 1)
+		assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+	})
+
+	// Every model which queries the database on a request's behalf bounds
+	// its queries in the same way.
+	others := []struct {
+		name  string
+		query func(timeout time.Duration) error
+	}{
+		{name: "Two-factor", query: func(timeout time.Duration) error {
+			_, err := (&TwoFactorModel{DB: db, QueryTimeout: timeout}).Enabled(context.Background(), 1)
+			return err
+		}},
+		{name: "Identities", query: func(timeout time.Duration) error {
+			_, err := (&IdentityModel{DB: db, QueryTimeout: timeout}).Find(context.Background(), "https://id.example.com", "alice")
+			return err
+		}},
+		{name: "Passkeys", query: func(timeout time.Duration) error {
+			_, err := (&PasskeyModel{DB: db, QueryTimeout: timeout}).List(context.Background(), 1)
+			return err
+		}},
+		{name: "Audit log", query: func(timeout time.Duration) error {
+			_, _, err := (&AuditEventModel{DB: db, QueryTimeout: timeout}).List(context.Background(), Filter{Page: 1, PageSize: 20})
+			return err
+		}},
+		{name: "Reports", query: func(timeout time.Duration) error {
+			_, err := (&ReportModel{DB: db, QueryTimeout: timeout}).Queue(context.Background())
+			return err
+		}},
+		{name: "Sessions", query: func(timeout time.Duration) error {
+			_, err := (&UserSessionModel{DB: db, QueryTimeout: timeout}).List(context.Background(), 1)
+			return err
+		}},
+		{name: "Rate limiter", query: func(timeout time.Duration) error {
+			_, err := (&MySQLRateLimiter{DB: db, QueryTimeout: timeout}).Allow(context.Background(), "user:1", RateLimit{Requests: 1, Per: time.Second, Burst: 1})
+			return err
+		}},
+	}
+
+	for _, tt := range others {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query(time.Nanosecond)
+			assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+		})
+	}
+
+	t.Run("Cancelled request", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		m := SnippetModel{DB: db}
+		_, err := m.Get(ctx, 1)
+		assert.Equal(t, errors.Is(err, context.Canceled), true)
+	})
+}
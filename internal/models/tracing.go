@@ -0,0 +1,45 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the name the models' tracer should be given, as in
+// provider.Tracer(models.TracerName).
+const TracerName = "snippetbox.tomcat.net/internal/models"
+
+// startSpan starts a span for the database queries made by a model method,
+// as a child of the span in ctx (normally the request's). name identifies
+// the method, such as "SnippetModel.Get". If tracer is nil, no span is
+// recorded.
+func startSpan(ctx context.Context, tracer trace.Tracer, name string) (context.Context, trace.Span) {
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer(TracerName)
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameMySQL,
+			attribute.String("db.operation.name", name),
+		),
+	)
+}
+
+// endSpan ends a span started by startSpan, recording err as the reason the
+// method failed. Errors which are expected outcomes, such as ErrNoRecord,
+// aren't recorded as failures.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNoRecord) && !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrDuplicateEmail) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// newTestTracer returns a tracer which records spans in memory as soon as
+// they end, and the exporter holding them.
+func newTestTracer(t *testing.T) (trace.Tracer, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	return tp.Tracer(TracerName), exporter
+}
+
+func TestEndSpan(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus codes.Code
+	}{
+		{name: "Success", err: nil, wantStatus: codes.Unset},
+		{name: "No record", err: ErrNoRecord, wantStatus: codes.Unset},
+		{name: "Invalid credentials", err: ErrInvalidCredentials, wantStatus: codes.Unset},
+		{name: "Wrapped duplicate email", err: fmt.Errorf("signup: %w", ErrDuplicateEmail), wantStatus: codes.Unset},
+		{name: "Database error", err: errors.New("connection refused"), wantStatus: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer, exporter := newTestTracer(t)
+
+			ctx, parent := tracer.Start(context.Background(), "GET /snippet/view/{id}", trace.WithSpanKind(trace.SpanKindServer))
+			_, span := startSpan(ctx, tracer, "SnippetModel.Get")
+			endSpan(span, tt.err)
+			parent.End()
+
+			spans := exporter.GetSpans()
+			assert.Equal(t, len(spans), 2)
+			s := spans[0]
+
+			assert.Equal(t, s.Name, "SnippetModel.Get")
+			assert.Equal(t, s.SpanKind, trace.SpanKindClient)
+			assert.Equal(t, s.Parent.SpanID(), spans[1].SpanContext.SpanID())
+			assert.Equal(t, s.Attributes[0], attribute.String("db.system.name", "mysql"))
+			assert.Equal(t, s.Status.Code, tt.wantStatus)
+		})
+	}
+
+	t.Run("Without a tracer", func(t *testing.T) {
+		_, span := startSpan(context.Background(), nil, "SnippetModel.Get")
+		assert.Equal(t, span.IsRecording(), false)
+
+		// Shouldn't panic.
+		endSpan(span, errors.New("connection refused"))
+	})
+}
+
+func TestSnippetModelTracing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	tracer, exporter := newTestTracer(t)
+	m := SnippetModel{DB: newTestDB(t), Tracer: tracer}
+
+	ctx, parent := tracer.Start(context.Background(), "GET /", trace.WithSpanKind(trace.SpanKindServer))
+	_, err := m.Latest(ctx)
+	assert.NilError(t, err)
+	_, err = m.Get(ctx, 999)
+	assert.Equal(t, err, ErrNoRecord)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 3)
+	assert.Equal(t, spans[0].Name, "SnippetModel.Latest")
+	assert.Equal(t, spans[1].Name, "SnippetModel.Get")
+	for _, s := range spans[:2] {
+		assert.Equal(t, s.Parent.SpanID(), parent.SpanContext().SpanID())
+		assert.Equal(t, s.Status.Code, codes.Unset)
+	}
+}
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"snippetbox.tomcat.net/internal/secrets"
+	"snippetbox.tomcat.net/internal/totp"
+)
+
+// recoveryCodeCount is the number of single-use recovery codes issued when a
+// user enables two-factor authentication.
+const recoveryCodeCount = 10
+
+// recoveryCodeAlphabet is the lower-case base32 alphabet. It has exactly 32
+// characters, so each random byte maps onto it without bias, and avoids 0
+// and 1 which are easily confused with o and l.
+const recoveryCodeAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// TwoFactorModelInterface defines the interface for TOTP two-factor
+// authentication operations. It covers:
+// - Enrolment (Begin and Confirm)
+// - Verifying codes during login (ValidateCode and UseRecoveryCode)
+// - Turning two-factor authentication off again
+type TwoFactorModelInterface interface {
+	Enabled(ctx context.Context, userID int) (bool, error)
+	Begin(ctx context.Context, userID int) ([]byte, error)
+	Confirm(ctx context.Context, userID int, code string, now time.Time) ([]string, error)
+	ValidateCode(ctx context.Context, userID int, code string, now time.Time) error
+	UseRecoveryCode(ctx context.Context, userID int, code string) error
+	Disable(ctx context.Context, userID int) error
+}
+
+// TwoFactorModel handles the database interactions for TOTP two-factor
+// authentication. Shared secrets are encrypted with Box before they are
+// stored, and recovery codes are stored as SHA-256 hashes.
+type TwoFactorModel struct {
+	DB  *sql.DB      // Database connection pool
+	Box *secrets.Box // Encrypts TOTP secrets at rest
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
+}
+
+// Enabled reports whether the user has completed two-factor enrolment.
+//
+// # Parameters
+// - userID: The ID of the user to check
+//
+// # Returns
+// - bool: true if two-factor authentication is enabled
+// - error: nil on success, database errors otherwise
+func (m *TwoFactorModel) Enabled(ctx context.Context, userID int) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var enabled bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM user_totp WHERE user_id = ? AND confirmed = TRUE)"
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Begin starts two-factor enrolment for a user and returns the shared secret
+// to show them. If enrolment was already started but not confirmed, the
+// existing secret is returned so that reloading the page doesn't invalidate
+// a QR code which has already been scanned.
+//
+// # Returns
+// - []byte: The (decrypted) shared secret
+// - error: nil on success, or:
+//   - ErrTwoFactorEnabled if enrolment has already been confirmed
+//   - Other errors for database or encryption failures
+func (m *TwoFactorModel) Begin(ctx context.Context, userID int) ([]byte, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var encrypted []byte
+	var confirmed bool
+
+	stmt := "SELECT encrypted_secret, confirmed FROM user_totp WHERE user_id = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&encrypted, &confirmed)
+	switch {
+	case err == nil && confirmed:
+		return nil, ErrTwoFactorEnabled
+	case err == nil:
+		return m.Box.Open(encrypted)
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err = m.Box.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `INSERT INTO user_totp (user_id, encrypted_secret, confirmed, created)
+	VALUES(?, ?, FALSE, UTC_TIMESTAMP())`
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// Confirm completes two-factor enrolment once the user has proven that their
+// authenticator app works by entering a valid code. It replaces any existing
+// recovery codes with a new set.
+//
+// # Parameters
+// - userID: The ID of the enrolling user
+// - code: The code from the user's authenticator app
+// - now: The current time
+//
+// # Returns
+// - []string: The plain-text recovery codes, which must be shown to the user
+// now as they can't be retrieved later
+// - error: nil on success, or:
+//   - ErrNoRecord if enrolment hasn't been started
+//   - ErrInvalidCredentials if the code is wrong
+//   - Other errors for database failures
+func (m *TwoFactorModel) Confirm(ctx context.Context, userID int, code string, now time.Time) ([]string, error) {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var encrypted []byte
+
+	stmt := "SELECT encrypted_secret FROM user_totp WHERE user_id = ? AND confirmed = FALSE"
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	secret, err := m.Box.Open(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Default.Validate(secret, strings.TrimSpace(code), now, 0)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt = "UPDATE user_totp SET confirmed = TRUE, last_used_step = ? WHERE user_id = ?"
+	_, err = tx.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = "DELETE FROM user_recovery_codes WHERE user_id = ?"
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES(?, ?)"
+	for _, c := range codes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hashRecoveryCode(c))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// ValidateCode checks a code from the user's authenticator app during login.
+// Each code can only be used once: the time step of the last accepted code
+// is recorded and codes from that step or earlier are rejected.
+//
+// # Returns
+// - error: nil if the code is valid, or:
+//   - ErrNoRecord if the user doesn't have two-factor authentication enabled
+//   - ErrInvalidCredentials if the code is wrong or has already been used
+//   - Other errors for database failures
+func (m *TwoFactorModel) ValidateCode(ctx context.Context, userID int, code string, now time.Time) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var encrypted []byte
+	var lastUsedStep int64
+
+	stmt := "SELECT encrypted_secret, last_used_step FROM user_totp WHERE user_id = ? AND confirmed = TRUE"
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&encrypted, &lastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	secret, err := m.Box.Open(encrypted)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Default.Validate(secret, strings.TrimSpace(code), now, lastUsedStep)
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	// Only record the step if nobody else has used a later code in the
+	// meantime, so two concurrent logins can't both use the same code.
+	stmt = "UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, step, userID, step)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// UseRecoveryCode redeems one of the user's single-use recovery codes in
+// place of a code from their authenticator app.
+//
+// # Returns
+// - error: nil if the code was valid and unused, ErrInvalidCredentials if not,
+// or any other database error.
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `UPDATE user_recovery_codes SET used = UTC_TIMESTAMP()
+	WHERE user_id = ? AND code_hash = ? AND used IS NULL`
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// Disable turns off two-factor authentication for the user, deleting their
+// shared secret and recovery codes.
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int) error {
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// newRecoveryCodes generates a fresh set of random recovery codes in the form
+// "xxxxx-xxxxx".
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[b[j]&31]
+		}
+
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode normalizes a recovery code (so that case, spaces and
+// dashes don't matter) and returns its hex-encoded SHA-256 hash. Recovery
+// codes have around 50 bits of entropy, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
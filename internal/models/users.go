@@ -1,12 +1,14 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,12 +18,45 @@ import (
 // - User existence verification
 // - User data retrieval
 // - Password updates
+//
+// Each method takes the context of the request it's made for, so that its
+// queries are traced as part of the request and abandoned if the client goes
+// away.
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
-	Get(id int) (User, error)
-	PasswordUpdate(id int, current_password, new_password string) error
+	Insert(ctx context.Context, name, email, password string) error
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (User, error)
+	PasswordUpdate(ctx context.Context, id int, current_password, new_password string) error
+	VerifyEmail(ctx context.Context, email string) error
+
+	// Admin-only operations
+	AdminList(ctx context.Context, filter Filter) ([]User, Metadata, error)
+	Count(ctx context.Context) (int, error)
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	RequirePasswordReset(ctx context.Context, id int) error
+}
+
+// Role is a user's role, which decides what they are allowed to do beyond
+// managing their own snippets and account.
+type Role string
+
+// The roles a user can have. Every user starts with RoleUser; moderators and
+// administrators are appointed by an administrator.
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Valid reports whether r is one of the defined roles.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	default:
+		return false
+	}
 }
 
 // User represents a registered user in the system.
@@ -32,18 +67,33 @@ type UserModelInterface interface {
 // - Email: User's email address (must be unique)
 // - HashedPassword: Bcrypt-hashed password
 // - Created: Timestamp of account creation
+// - EmailVerified: Whether the user has followed their verification link
+// - Role: The user's role
+// - Disabled: Whether an administrator has disabled the account
+// - PasswordResetRequired: Whether the user must change their password
+// before they can do anything else
 type User struct {
 	ID             int       // Unique user ID
 	Name           string    // User's name
 	Email          string    // User's email address (unique)
 	HashedPassword []byte    // Bcrypt-hashed password
 	Created        time.Time // Account creation timestamp
+	EmailVerified  bool      // True once the email address has been verified
+	Role           Role      // User, moderator or admin
+
+	Disabled              bool // Set by an administrator to stop the user logging in
+	PasswordResetRequired bool // Set by an administrator, cleared by PasswordUpdate
 }
 
 // UserModel handles all database interactions for users.
 // It provides methods for user authentication, creation, and verification.
 type UserModel struct {
-	DB *sql.DB // Database connection pool
+	DB     *sql.DB      // Database connection pool
+	Tracer trace.Tracer // Records a span for each method's queries (none if nil)
+
+	// QueryTimeout bounds how long each method's queries may take
+	// (DefaultQueryTimeout if zero).
+	QueryTimeout time.Duration
 }
 
 // Insert creates a new user record in the database.
@@ -64,14 +114,19 @@ type UserModel struct {
 //
 // # Example Usage
 //
-//	err := userModel.Insert("John Doe", "john@example.com", "mypassword")
+//	err := userModel.Insert(ctx, "John Doe", "john@example.com", "mypassword")
 //	if err != nil {
 //	    if errors.Is(err, models.ErrDuplicateEmail) {
 //	        // Handle duplicate email
 //	    }
 //	    // Handle other errors
 //	}
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.Insert")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
 	// Hash the password with bcrypt cost factor 12
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
@@ -83,7 +138,7 @@ func (m *UserModel) Insert(name, email, password string) error {
 	VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
 	// Execute the statement with provided values
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	_, err = m.DB.ExecContext(ctx, stmt, name, email, string(hashedPassword))
 	if err != nil {
 		// Check for duplicate email addresses
 		var mySQLError *mysql.MySQLError
@@ -112,13 +167,18 @@ func (m *UserModel) Insert(name, email, password string) error {
 //
 // # Security
 // - Uses constant-time comparison for password verification to mitigate timing attacks.
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (_ int, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.Authenticate")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
 	var id int
 	var hashedPassword []byte
 
 	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err = m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -147,12 +207,17 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 // # Returns
 // - bool: true if user exists, false if not
 // - error: nil on success, database errors otherwise
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (_ bool, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.Exists")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
 	var exists bool
 
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
 
@@ -166,11 +231,18 @@ func (m *UserModel) Exists(id int) (bool, error) {
 // - error: nil on success, or:
 //   - ErrNoRecord if no user with the given ID exists
 //   - Other errors for database failures
-func (m *UserModel) Get(id int) (User, error) {
+func (m *UserModel) Get(ctx context.Context, id int) (_ User, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.Get")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
 	var user User
-	stmt := "SELECT id, name, email, created FROM users WHERE id = ?"
+	stmt := `SELECT id, name, email, created, email_verified, role, disabled, password_reset_required
+	FROM users WHERE id = ?`
 
-	err := m.DB.QueryRow(stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created, &user.EmailVerified,
+		&user.Role, &user.Disabled, &user.PasswordResetRequired)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, ErrNoRecord
@@ -192,14 +264,19 @@ func (m *UserModel) Get(id int) (User, error) {
 //
 // Returns:
 // - error: nil on success, or an error if the update fails.
-func (m *UserModel) PasswordUpdate(id int, current_password, new_password string) error {
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, current_password, new_password string) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.PasswordUpdate")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
 	var currentHash []byte
 
 	// Prepare SQL statement to retrieve the current hashed password for the user.
 	stmt := "SELECT hashed_password FROM users WHERE id = ?"
 
 	// Execute the query and scan the result into currentHash.
-	err := m.DB.QueryRow(stmt, id).Scan(&currentHash)
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&currentHash)
 	if err != nil {
 		// If no rows are returned, the user ID is invalid.
 		if errors.Is(err, sql.ErrNoRows) {
@@ -229,10 +306,171 @@ func (m *UserModel) PasswordUpdate(id int, current_password, new_password string
 		return err
 	}
 
-	// Prepare SQL statement to update the user's password in the database.
-	stmt = "UPDATE users SET hashed_password = ? WHERE id = ?"
+	// Prepare SQL statement to update the user's password in the database,
+	// which also satisfies any password reset required by an administrator.
+	stmt = "UPDATE users SET hashed_password = ?, password_reset_required = FALSE WHERE id = ?"
 	// Execute the update statement with the new hashed password.
-	_, err = m.DB.Exec(stmt, newHash, id)
+	_, err = m.DB.ExecContext(ctx, stmt, newHash, id)
 	// Return any error encountered during the update.
 	return err
 }
+
+// VerifyEmail marks the email address of the user with the given email as
+// verified. Verifying an address which is already verified is not an error.
+//
+// Parameters:
+// - email: The email address that was proven to belong to the user.
+//
+// Returns:
+// - error: nil on success, ErrNoRecord if no user has that email address,
+// or any other database error.
+func (m *UserModel) VerifyEmail(ctx context.Context, email string) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.VerifyEmail")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var id int
+
+	stmt := "SELECT id FROM users WHERE email = ?"
+
+	err = m.DB.QueryRowContext(ctx, stmt, email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	stmt = "UPDATE users SET email_verified = TRUE WHERE id = ?"
+	_, err = m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// AdminList returns a page of users whose name or email address contains the
+// filter's search text, newest first, for the admin area.
+//
+// # Returns
+// - []User: The users on the requested page
+// - Metadata: Pagination details, including the total number of matches
+// - error: Any database error
+func (m *UserModel) AdminList(ctx context.Context, filter Filter) (_ []User, _ Metadata, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.AdminList")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	pattern := filter.pattern()
+
+	var totalRecords int
+
+	stmt := "SELECT COUNT(*) FROM users WHERE name LIKE ? OR email LIKE ?"
+
+	err = m.DB.QueryRowContext(ctx, stmt, pattern, pattern).Scan(&totalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	stmt = `SELECT id, name, email, created, email_verified, role, disabled, password_reset_required
+	FROM users
+	WHERE name LIKE ? OR email LIKE ?
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pattern, pattern, filter.limit(), filter.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var users []User
+
+	for rows.Next() {
+		var u User
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.EmailVerified,
+			&u.Role, &u.Disabled, &u.PasswordResetRequired)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filter.Page, filter.PageSize), nil
+}
+
+// Count returns the number of registered users.
+func (m *UserModel) Count(ctx context.Context) (_ int, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.Count")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var n int
+	err = m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// SetDisabled disables or re-enables a user's account. Disabled users can't
+// log in; the caller is responsible for ending their existing sessions.
+//
+// # Returns
+// - error: ErrNoRecord if there is no such user, or any other database error
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.SetDisabled")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.update(ctx, id, "UPDATE users SET disabled = ? WHERE id = ?", disabled, id)
+}
+
+// RequirePasswordReset makes a user change their password before they can
+// do anything else, for example because it may have been leaked. The flag is
+// cleared by PasswordUpdate.
+//
+// # Returns
+// - error: ErrNoRecord if there is no such user, or any other database error
+func (m *UserModel) RequirePasswordReset(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "UserModel.RequirePasswordReset")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := queryContext(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.update(ctx, id, "UPDATE users SET password_reset_required = TRUE WHERE id = ?", id)
+}
+
+// update executes an UPDATE statement for the user with the given ID,
+// returning ErrNoRecord if the user doesn't exist.
+//
+// MySQL only counts rows which were actually changed as affected, so when no
+// rows are affected it checks whether the user exists rather than assuming
+// they don't.
+func (m *UserModel) update(ctx context.Context, id int, stmt string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		exists, err := m.Exists(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+
+	return nil
+}
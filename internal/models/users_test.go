@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"testing"
 
 	"snippetbox.tomcat.net/internal/assert"
@@ -42,11 +43,11 @@ func TestUserModelExits(t *testing.T) {
 			db := newTestDB(t)
 
 			// Create a new instance of the UserModel
-			m := UserModel{db}
+			m := UserModel{DB: db}
 
 			// Call the UserMOdel.Exists() method and check that the return
 			// value and error match the expected values for the sub-test.
-			exists, err := m.Exists(tt.userID)
+			exists, err := m.Exists(context.Background(), tt.userID)
 
 			assert.Equal(t, exists, tt.want)
 			assert.NilError(t, err)
@@ -0,0 +1,445 @@
+// Package oidc implements the parts of OpenID Connect needed to log users in
+// with an external identity provider: discovery, the authorization code flow
+// with PKCE, and verification of RS256-signed ID tokens against the
+// provider's published JSON Web Key Set.
+//
+// Only what the application needs is implemented. In particular ID tokens
+// must be signed with RS256, which every OpenID provider is required to
+// support.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidIDToken is returned when an ID token is malformed, has a bad
+	// signature, or its claims don't match what was expected.
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+	// ErrExchange is returned when the provider refuses to exchange an
+	// authorization code for tokens.
+	ErrExchange = errors.New("oidc: code exchange failed")
+)
+
+// clockSkew is how far the provider's clock may be from ours when checking
+// the expiry and issue times of ID tokens.
+const clockSkew = time.Minute
+
+// jwksRefreshInterval limits how often the key set is fetched again when a
+// token is signed with an unknown key, so that tokens with made-up key IDs
+// can't be used to make us hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+// Config holds the settings for a relying party (that's us) registered with
+// an OpenID provider.
+type Config struct {
+	Issuer       string   // Provider's issuer URL, e.g. "https://accounts.example.com"
+	ClientID     string   // Client ID issued by the provider
+	ClientSecret string   // Client secret issued by the provider
+	RedirectURL  string   // Our callback URL, as registered with the provider
+	Scopes       []string // Scopes to request; "openid" is always included
+
+	// HTTPClient is used for all requests to the provider. It defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// metadata holds the fields we use from the provider's discovery document.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID provider which has been discovered and can be used
+// to log users in. It is safe for concurrent use.
+type Provider struct {
+	// Now returns the current time. It defaults to time.Now and can be
+	// replaced in tests.
+	Now func() time.Time
+
+	config   Config
+	metadata metadata
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+// Discover fetches the provider's discovery document from
+// "<issuer>/.well-known/openid-configuration" and returns a Provider ready
+// for use.
+//
+// The issuer in the document must exactly match config.Issuer, as required
+// by the OpenID Connect Discovery specification.
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	p := &Provider{Now: time.Now, config: config}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	err := p.getJSON(ctx, wellKnown, &p.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+
+	if p.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", p.metadata.Issuer, config.Issuer)
+	}
+
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: document is missing required endpoints")
+	}
+
+	return p, nil
+}
+
+// Issuer returns the provider's issuer URL. Together with a token's subject
+// it uniquely identifies a user.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// AuthCodeURL returns the URL to redirect the user to in order to log in.
+//
+// Parameters:
+//   - state: A random value which must be checked when the user returns, to
+//     prevent cross-site request forgery
+//   - nonce: A random value which the provider includes in the ID token, to
+//     prevent token replay
+//   - verifier: The PKCE code verifier, which must be passed to Exchange
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	scopes := []string{"openid"}
+	for _, s := range p.config.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", PKCEChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// tokenResponse is the response from the provider's token endpoint.
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange swaps the authorization code the user returned with for an ID
+// token, and verifies it.
+//
+// Parameters:
+//   - code: The "code" query parameter from the callback
+//   - verifier: The PKCE code verifier passed to AuthCodeURL
+//   - nonce: The nonce passed to AuthCodeURL
+//
+// Returns:
+//   - *Claims: The verified claims from the ID token
+//   - error: ErrExchange if the provider rejected the code,
+//     ErrInvalidIDToken if the ID token couldn't be verified, or any network
+//     error
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("oidc: decoding token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s (status %d)", ErrExchange, tr.Error, tr.ErrorDescription, resp.StatusCode)
+	}
+
+	if tr.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+
+	return p.Verify(ctx, tr.IDToken, nonce)
+}
+
+// Claims holds the claims from a verified ID token.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified boolish  `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the "aud" claim, which may be a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var ss []string
+	err := json.Unmarshal(b, &ss)
+	if err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// boolish is a boolean claim which some providers send as a string.
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("oidc: invalid boolean %s", data)
+	}
+	return nil
+}
+
+// Verify checks an ID token's signature and claims, and returns the claims.
+//
+// The token must:
+//   - Be signed with RS256 by a key in the provider's key set
+//   - Have been issued by the provider, for our client ID
+//   - Not have expired, allowing for a little clock skew
+//   - Carry the expected nonce
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidIDToken, err)
+	}
+
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidIDToken, err)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidIDToken, err)
+	}
+
+	now := p.Now()
+
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID:
+		return nil, fmt.Errorf("%w: wrong authorized party", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// key returns the provider's public key with the given ID, fetching the key
+// set if it hasn't been fetched yet or the key is new (providers rotate
+// their keys from time to time).
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if p.keys != nil && p.Now().Sub(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, p.metadata.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching key set: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.keys = keys
+	p.keysFetched = p.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	return key, nil
+}
+
+// getJSON fetches url and decodes the JSON response into dst.
+func (p *Provider) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a JWT.
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+// RandomString returns a random, URL-safe string with 256 bits of entropy,
+// suitable for use as a state, nonce or PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge returns the S256 code challenge for a PKCE code verifier, as
+// defined in RFC 7636.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,221 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+	"snippetbox.tomcat.net/internal/oidc/oidctest"
+)
+
+// newProvider starts a stand-in provider and discovers it.
+func newProvider(t *testing.T) (*oidctest.Server, *Provider) {
+	t.Helper()
+
+	srv := oidctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	p, err := Discover(context.Background(), Config{
+		Issuer:       srv.URL,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  "https://snippetbox.example.com/user/login/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+		HTTPClient:   srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, p
+}
+
+// authorize follows the AuthCodeURL to the stand-in provider and returns the
+// query parameters it redirects back with.
+func authorize(t *testing.T, srv *oidctest.Server, authURL string) url.Values {
+	t.Helper()
+
+	client := &http.Client{
+		Transport: srv.Client().Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	rs, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	location, err := rs.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location.Query()
+}
+
+func TestExchange(t *testing.T) {
+	srv, p := newProvider(t)
+	ctx := context.Background()
+
+	t.Run("Valid", func(t *testing.T) {
+		callback := authorize(t, srv, p.AuthCodeURL("state", "nonce", "verifier"))
+		assert.Equal(t, callback.Get("state"), "state")
+
+		claims, err := p.Exchange(ctx, callback.Get("code"), "verifier", "nonce")
+		assert.NilError(t, err)
+		assert.Equal(t, claims.Subject, srv.User.Subject)
+		assert.Equal(t, claims.Email, srv.User.Email)
+		assert.Equal(t, bool(claims.EmailVerified), true)
+	})
+
+	t.Run("Wrong verifier", func(t *testing.T) {
+		callback := authorize(t, srv, p.AuthCodeURL("state", "nonce", "verifier"))
+
+		_, err := p.Exchange(ctx, callback.Get("code"), "other verifier", "nonce")
+		assert.Equal(t, errors.Is(err, ErrExchange), true)
+	})
+
+	t.Run("Code reused", func(t *testing.T) {
+		callback := authorize(t, srv, p.AuthCodeURL("state", "nonce", "verifier"))
+
+		_, err := p.Exchange(ctx, callback.Get("code"), "verifier", "nonce")
+		assert.NilError(t, err)
+
+		_, err = p.Exchange(ctx, callback.Get("code"), "verifier", "nonce")
+		assert.Equal(t, errors.Is(err, ErrExchange), true)
+	})
+
+	t.Run("Wrong nonce", func(t *testing.T) {
+		callback := authorize(t, srv, p.AuthCodeURL("state", "nonce", "verifier"))
+
+		_, err := p.Exchange(ctx, callback.Get("code"), "verifier", "other nonce")
+		assert.Equal(t, errors.Is(err, ErrInvalidIDToken), true)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	srv, p := newProvider(t)
+
+	now := time.Now()
+	p.Now = func() time.Time { return now }
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":   srv.URL,
+			"sub":   "1234567890",
+			"aud":   oidctest.ClientID,
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]any)
+		token   func(signed string) string
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+		},
+		{
+			name: "Audience array",
+			modify: func(c map[string]any) {
+				c["aud"] = []string{oidctest.ClientID, "other"}
+				c["azp"] = oidctest.ClientID
+			},
+		},
+		{
+			name:    "Wrong issuer",
+			modify:  func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+			wantErr: true,
+		},
+		{
+			name:    "Wrong audience",
+			modify:  func(c map[string]any) { c["aud"] = "other" },
+			wantErr: true,
+		},
+		{
+			name:    "Expired",
+			modify:  func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() },
+			wantErr: true,
+		},
+		{
+			name:    "Issued in the future",
+			modify:  func(c map[string]any) { c["iat"] = now.Add(time.Hour).Unix() },
+			wantErr: true,
+		},
+		{
+			name:    "Wrong nonce",
+			modify:  func(c map[string]any) { c["nonce"] = "other" },
+			wantErr: true,
+		},
+		{
+			name: "Tampered claims",
+			token: func(signed string) string {
+				parts := strings.Split(signed, ".")
+				parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"someone-else"}`))
+				return strings.Join(parts, ".")
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unsigned",
+			token: func(signed string) string {
+				parts := strings.Split(signed, ".")
+				parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+				return parts[0] + "." + parts[1] + "."
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Malformed",
+			token:   func(signed string) string { return "not a token" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			token := srv.Sign(claims)
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+
+			_, err := p.Verify(context.Background(), token, "nonce")
+			if tt.wantErr {
+				assert.Equal(t, errors.Is(err, ErrInvalidIDToken), true)
+			} else {
+				assert.NilError(t, err)
+			}
+		})
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	_, err := Discover(context.Background(), Config{
+		Issuer:     srv.URL + "/",
+		HTTPClient: srv.Client(),
+	})
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,389 @@
+// Package scanner checks snippet content before it's saved, for leaked
+// credentials (such as cloud access keys, private keys and API tokens) and
+// for spam. Checks are made by a pipeline of scanners, configured from a
+// JSON rules file (see Parse for the format).
+//
+// Each finding either blocks the snippet from being saved or warns the
+// author, who can choose to save it anyway.
+package scanner
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Action is what to do when a rule matches.
+type Action string
+
+const (
+	// Warn asks the author to confirm that they want to save the snippet.
+	Warn Action = "warn"
+	// Block prevents the snippet from being saved.
+	Block Action = "block"
+)
+
+// Finding is a match for one of the scanners' rules.
+//
+// Fields:
+//   - Rule: The name of the rule, such as "AWS access key ID"
+//   - Message: A description of the problem to show to the author
+//   - Action: Whether the finding blocks the snippet or is a warning
+type Finding struct {
+	Rule    string
+	Message string
+	Action  Action
+}
+
+// Scanner is implemented by each step of the pipeline.
+type Scanner interface {
+	// Scan returns the findings for the content. Each rule is reported at
+	// most once, however many times it matches.
+	Scan(content string) []Finding
+}
+
+// Pipeline is a Scanner which runs each of its scanners in turn and returns
+// all of their findings.
+type Pipeline []Scanner
+
+// Scan runs each scanner in the pipeline over the content.
+func (p Pipeline) Scan(content string) []Finding {
+	var findings []Finding
+	for _, s := range p {
+		findings = append(findings, s.Scan(content)...)
+	}
+	return findings
+}
+
+// Blocked reports whether any of the findings block the content from being
+// saved.
+func Blocked(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Action == Block {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary joins the messages of the findings with the given action, for
+// showing to the author.
+func Summary(findings []Finding, action Action) string {
+	var messages []string
+	for _, f := range findings {
+		if f.Action == action {
+			messages = append(messages, f.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// PatternRule finds text matching a regular expression, such as the fixed
+// format of a cloud provider's access keys.
+type PatternRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  Action
+}
+
+// PatternScanner checks content against a list of PatternRules.
+type PatternScanner struct {
+	Rules []PatternRule
+}
+
+// Scan reports each rule whose pattern matches the content.
+func (s *PatternScanner) Scan(content string) []Finding {
+	var findings []Finding
+	for _, rule := range s.Rules {
+		if rule.Pattern.MatchString(content) {
+			findings = append(findings, Finding{
+				Rule:    rule.Name,
+				Message: "it looks like it contains a secret (" + rule.Name + ")",
+				Action:  rule.Action,
+			})
+		}
+	}
+	return findings
+}
+
+// EntropyScanner finds long, random-looking tokens, which are likely to be
+// passwords or API keys that don't match any known pattern.
+//
+// A token is a run of letters, digits and the characters "+/=_-" (covering
+// base64 and most token formats). It's reported if it's at least MinLength
+// characters long, contains both letters and digits, and its Shannon entropy
+// is at least MinEntropy bits per character. Hexadecimal strings (such as git
+// commit hashes) have at most 4 bits per character, so a MinEntropy above 4
+// ignores them.
+type EntropyScanner struct {
+	MinLength  int
+	MinEntropy float64
+	Action     Action
+}
+
+// Scan reports a finding if any token in the content looks random.
+func (s *EntropyScanner) Scan(content string) []Finding {
+	tokens := strings.FieldsFunc(content, func(r rune) bool {
+		return !isTokenChar(r)
+	})
+
+	for _, token := range tokens {
+		if len(token) < s.MinLength || !hasLettersAndDigits(token) {
+			continue
+		}
+
+		if Entropy(token) >= s.MinEntropy {
+			return []Finding{{
+				Rule:    "high-entropy token",
+				Message: "it looks like it contains a password or secret token",
+				Action:  s.Action,
+			}}
+		}
+	}
+
+	return nil
+}
+
+// Entropy returns the Shannon entropy of s in bits per character.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+func isTokenChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("+/=_-", r))
+}
+
+func hasLettersAndDigits(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsLetter) && strings.ContainsFunc(s, unicode.IsDigit)
+}
+
+// linkRX matches web links.
+var linkRX = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>]+`)
+
+// LinkScanner finds content with more than MaxLinks web links, a sign of
+// link spam.
+type LinkScanner struct {
+	MaxLinks int
+	Action   Action
+}
+
+// Scan reports a finding if the content has too many links.
+func (s *LinkScanner) Scan(content string) []Finding {
+	n := len(linkRX.FindAllStringIndex(content, -1))
+	if n <= s.MaxLinks {
+		return nil
+	}
+
+	return []Finding{{
+		Rule:    "links",
+		Message: fmt.Sprintf("it contains %d links (the most allowed is %d)", n, s.MaxLinks),
+		Action:  s.Action,
+	}}
+}
+
+// KeywordScanner finds content containing at least MinMatches different
+// spam keywords. Keywords are matched as whole words, ignoring case.
+type KeywordScanner struct {
+	Keywords   []string
+	MinMatches int
+	Action     Action
+
+	rx *regexp.Regexp
+}
+
+// NewKeywordScanner returns a KeywordScanner for the keywords.
+func NewKeywordScanner(keywords []string, minMatches int, action Action) *KeywordScanner {
+	quoted := make([]string, len(keywords))
+	for i, k := range keywords {
+		quoted[i] = regexp.QuoteMeta(strings.ToLower(k))
+	}
+
+	return &KeywordScanner{
+		Keywords:   keywords,
+		MinMatches: minMatches,
+		Action:     action,
+		rx:         regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+	}
+}
+
+// Scan reports a finding if enough different keywords appear in the
+// content.
+func (s *KeywordScanner) Scan(content string) []Finding {
+	if len(s.Keywords) == 0 {
+		return nil
+	}
+
+	matched := make(map[string]bool)
+	for _, m := range s.rx.FindAllString(content, -1) {
+		matched[strings.ToLower(m)] = true
+	}
+
+	if len(matched) < s.MinMatches {
+		return nil
+	}
+
+	return []Finding{{
+		Rule:    "keywords",
+		Message: "it looks like spam",
+		Action:  s.Action,
+	}}
+}
+
+// config is the format of the rules file.
+type config struct {
+	Patterns []struct {
+		Name    string `json:"name"`
+		Pattern string `json:"pattern"`
+		Action  Action `json:"action"`
+	} `json:"patterns"`
+
+	Entropy *struct {
+		MinLength  int     `json:"min_length"`
+		MinEntropy float64 `json:"min_entropy"`
+		Action     Action  `json:"action"`
+	} `json:"entropy"`
+
+	Links *struct {
+		Max    int    `json:"max"`
+		Action Action `json:"action"`
+	} `json:"links"`
+
+	Keywords *struct {
+		Words      []string `json:"words"`
+		MinMatches int      `json:"min_matches"`
+		Action     Action   `json:"action"`
+	} `json:"keywords"`
+}
+
+// Parse builds a pipeline from a JSON rules file. The file has this
+// format, where every section is optional and each action is "warn" or
+// "block":
+//
+//	{
+//	  "patterns": [
+//	    {"name": "AWS access key ID", "pattern": "\\bAKIA[0-9A-Z]{16}\\b", "action": "block"}
+//	  ],
+//	  "entropy": {"min_length": 32, "min_entropy": 4.5, "action": "warn"},
+//	  "links": {"max": 10, "action": "warn"},
+//	  "keywords": {"words": ["casino", "viagra"], "min_matches": 2, "action": "block"}
+//	}
+//
+// Patterns use Go's regular expression syntax.
+func Parse(data []byte) (Pipeline, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var cfg config
+	err := dec.Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("scanner: invalid rules: %w", err)
+	}
+
+	var pipeline Pipeline
+
+	if len(cfg.Patterns) > 0 {
+		ps := &PatternScanner{}
+		for i, p := range cfg.Patterns {
+			if p.Name == "" {
+				return nil, fmt.Errorf("scanner: pattern %d has no name", i+1)
+			}
+
+			rx, err := regexp.Compile(p.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("scanner: pattern %q: %w", p.Name, err)
+			}
+
+			if err := checkAction(p.Action); err != nil {
+				return nil, fmt.Errorf("scanner: pattern %q: %w", p.Name, err)
+			}
+
+			ps.Rules = append(ps.Rules, PatternRule{Name: p.Name, Pattern: rx, Action: p.Action})
+		}
+		pipeline = append(pipeline, ps)
+	}
+
+	if e := cfg.Entropy; e != nil {
+		if e.MinLength < 1 || e.MinEntropy <= 0 {
+			return nil, errors.New("scanner: entropy: min_length and min_entropy must be positive")
+		}
+		if err := checkAction(e.Action); err != nil {
+			return nil, fmt.Errorf("scanner: entropy: %w", err)
+		}
+		pipeline = append(pipeline, &EntropyScanner{MinLength: e.MinLength, MinEntropy: e.MinEntropy, Action: e.Action})
+	}
+
+	if l := cfg.Links; l != nil {
+		if l.Max < 0 {
+			return nil, errors.New("scanner: links: max can't be negative")
+		}
+		if err := checkAction(l.Action); err != nil {
+			return nil, fmt.Errorf("scanner: links: %w", err)
+		}
+		pipeline = append(pipeline, &LinkScanner{MaxLinks: l.Max, Action: l.Action})
+	}
+
+	if k := cfg.Keywords; k != nil {
+		if k.MinMatches < 1 {
+			return nil, errors.New("scanner: keywords: min_matches must be positive")
+		}
+		if err := checkAction(k.Action); err != nil {
+			return nil, fmt.Errorf("scanner: keywords: %w", err)
+		}
+		pipeline = append(pipeline, NewKeywordScanner(k.Words, k.MinMatches, k.Action))
+	}
+
+	return pipeline, nil
+}
+
+func checkAction(a Action) error {
+	if a != Warn && a != Block {
+		return fmt.Errorf(`action must be "warn" or "block", not %q`, a)
+	}
+	return nil
+}
+
+// Load reads and parses a rules file.
+func Load(path string) (Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Default returns the pipeline for the built-in rules, used when no rules
+// file is configured.
+func Default() Pipeline {
+	p, err := Parse(defaultRules)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
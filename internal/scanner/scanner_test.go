@@ -0,0 +1,180 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// ruleNames returns the names of the rules which matched, in order.
+func ruleNames(findings []Finding) []string {
+	var names []string
+	for _, f := range findings {
+		names = append(names, f.Rule)
+	}
+	return names
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDefault(t *testing.T) {
+	pipeline := Default()
+
+	tests := []struct {
+		name        string
+		fixture     string
+		wantRules   []string
+		wantBlocked bool
+	}{
+		{
+			name:    "Clean",
+			fixture: "clean.txt",
+		},
+		{
+			name:        "AWS access key",
+			fixture:     "aws_key.txt",
+			wantRules:   []string{"AWS access key ID"},
+			wantBlocked: true,
+		},
+		{
+			name:        "Private key",
+			fixture:     "private_key.txt",
+			wantRules:   []string{"private key"},
+			wantBlocked: true,
+		},
+		{
+			name:      "JWT",
+			fixture:   "jwt.txt",
+			wantRules: []string{"JSON Web Token", "high-entropy token"},
+		},
+		{
+			name:      "High-entropy token",
+			fixture:   "password.txt",
+			wantRules: []string{"high-entropy token"},
+		},
+		{
+			name:      "Too many links",
+			fixture:   "links.txt",
+			wantRules: []string{"links"},
+		},
+		{
+			name:        "Spam keywords",
+			fixture:     "spam.txt",
+			wantRules:   []string{"keywords"},
+			wantBlocked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := pipeline.Scan(readFixture(t, tt.fixture))
+
+			if got := ruleNames(findings); !slices.Equal(got, tt.wantRules) {
+				t.Errorf("got rules %q; want %q", got, tt.wantRules)
+			}
+			assert.Equal(t, Blocked(findings), tt.wantBlocked)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	pipeline, err := Load(filepath.Join("testdata", "rules.json"))
+	assert.NilError(t, err)
+
+	findings := pipeline.Scan("Deploy to build-01.corp.example.com")
+	assert.Equal(t, strings.Join(ruleNames(findings), ","), "internal hostname")
+	assert.Equal(t, Blocked(findings), false)
+
+	findings = pipeline.Scan("You won the LOTTERY")
+	assert.Equal(t, strings.Join(ruleNames(findings), ","), "keywords")
+	assert.Equal(t, Blocked(findings), true)
+
+	// The built-in rules aren't used with a rules file.
+	findings = pipeline.Scan(readFixture(t, "aws_key.txt"))
+	assert.Equal(t, len(findings), 0)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{
+			name:  "Invalid JSON",
+			rules: `{"patterns": [`,
+		},
+		{
+			name:  "Unknown field",
+			rules: `{"pattern": []}`,
+		},
+		{
+			name:  "Invalid pattern",
+			rules: `{"patterns": [{"name": "x", "pattern": "(", "action": "block"}]}`,
+		},
+		{
+			name:  "Missing name",
+			rules: `{"patterns": [{"pattern": "x", "action": "block"}]}`,
+		},
+		{
+			name:  "Invalid action",
+			rules: `{"links": {"max": 3, "action": "reject"}}`,
+		},
+		{
+			name:  "Invalid entropy",
+			rules: `{"entropy": {"min_length": 0, "min_entropy": 4, "action": "warn"}}`,
+		},
+		{
+			name:  "Invalid keywords",
+			rules: `{"keywords": {"words": ["spam"], "min_matches": 0, "action": "warn"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.rules))
+			if err == nil {
+				t.Error("got nil error")
+			}
+		})
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"", 0},
+		{"aaaa", 0},
+		{"abab", 1},
+		{"abcd", 2},
+		{"0123456789abcdef", 4},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, Entropy(tt.s), tt.want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	findings := []Finding{
+		{Rule: "a", Message: "first", Action: Block},
+		{Rule: "b", Message: "second", Action: Warn},
+		{Rule: "c", Message: "third", Action: Block},
+	}
+
+	assert.Equal(t, Summary(findings, Block), "first; third")
+	assert.Equal(t, Summary(findings, Warn), "second")
+}
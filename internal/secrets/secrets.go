@@ -0,0 +1,75 @@
+// Package secrets encrypts small values (such as TOTP shared secrets) before
+// they are stored in the database, using AES-256-GCM.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrDecrypt is returned when a ciphertext can't be decrypted, either because
+// it has been tampered with or because it was encrypted with another key.
+var ErrDecrypt = errors.New("secrets: unable to decrypt value")
+
+// Box encrypts and decrypts values with a single 32-byte key. It is safe for
+// concurrent use.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a Box which uses the given 32-byte key.
+func NewBox(key []byte) (*Box, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext. A random nonce is generated for each call and
+// prepended to the returned ciphertext.
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize(), b.aead.NonceSize()+len(plaintext)+b.aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a ciphertext created by Seal, returning ErrDecrypt if it is
+// invalid.
+func (b *Box) Open(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < b.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, sealed := ciphertext[:b.aead.NonceSize()], ciphertext[b.aead.NonceSize():]
+
+	plaintext, err := b.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
+
+// DeriveKey derives a 32-byte key for a specific purpose from the
+// application's master secret, so that the same secret is never used
+// directly for both signing and encryption.
+func DeriveKey(master []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, master)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestBox(t *testing.T) {
+	box, err := NewBox(DeriveKey([]byte("master secret"), "totp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewBox(DeriveKey([]byte("master secret"), "something else"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("12345678901234567890")
+
+	ciphertext, err := box.Seal(plaintext)
+	assert.NilError(t, err)
+
+	t.Run("Round trip", func(t *testing.T) {
+		got, err := box.Open(ciphertext)
+		assert.NilError(t, err)
+		assert.Equal(t, string(got), string(plaintext))
+	})
+
+	t.Run("Not stored in the clear", func(t *testing.T) {
+		assert.Equal(t, bytes.Contains(ciphertext, plaintext), false)
+	})
+
+	t.Run("Unique nonces", func(t *testing.T) {
+		again, err := box.Seal(plaintext)
+		assert.NilError(t, err)
+		assert.Equal(t, bytes.Equal(again, ciphertext), false)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)-1] ^= 1
+
+		_, err := box.Open(tampered)
+		assert.Equal(t, err, ErrDecrypt)
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		_, err := other.Open(ciphertext)
+		assert.Equal(t, err, ErrDecrypt)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := box.Open(ciphertext[:4])
+		assert.Equal(t, err, ErrDecrypt)
+	})
+}
@@ -0,0 +1,85 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestSignerVerify(t *testing.T) {
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	signer := New([]byte("0123456789abcdef0123456789abcdef"))
+	other := New([]byte("fedcba9876543210fedcba9876543210"))
+
+	valid := signer.Sign("email-verification", "alice@example.com", now.Add(time.Hour))
+
+	tests := []struct {
+		name      string
+		signer    *Signer
+		purpose   string
+		token     string
+		wantValue string
+		wantErr   error
+	}{
+		{
+			name:      "Valid",
+			signer:    signer,
+			purpose:   "email-verification",
+			token:     valid,
+			wantValue: "alice@example.com",
+		},
+		{
+			name:    "Expired",
+			signer:  signer,
+			purpose: "email-verification",
+			token:   signer.Sign("email-verification", "alice@example.com", now),
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name:    "Wrong purpose",
+			signer:  signer,
+			purpose: "password-reset",
+			token:   valid,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Wrong key",
+			signer:  other,
+			purpose: "email-verification",
+			token:   valid,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Tampered value",
+			signer:  signer,
+			purpose: "email-verification",
+			token:   "Ym9iQGV4YW1wbGUuY29t" + valid[len("YWxpY2VAZXhhbXBsZS5jb20"):],
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Malformed",
+			signer:  signer,
+			purpose: "email-verification",
+			token:   "not-a-token",
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Empty",
+			signer:  signer,
+			purpose: "email-verification",
+			token:   "",
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := tt.signer.Verify(tt.purpose, tt.token, now)
+
+			assert.Equal(t, value, tt.wantValue)
+			assert.Equal(t, err, tt.wantErr)
+		})
+	}
+}
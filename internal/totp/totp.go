@@ -0,0 +1,175 @@
+// Package totp implements time-based one-time passwords as described in
+// RFC 6238 (which builds on the HOTP algorithm from RFC 4226). These are the
+// six digit codes shown by authenticator apps such as Google Authenticator.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Algorithm is the HMAC hash function used to generate codes.
+type Algorithm string
+
+// The algorithms permitted by RFC 6238. Authenticator apps almost universally
+// support SHA1 only, which is why it's the default.
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+// SecretSize is the length in bytes of secrets created by NewSecret. RFC 4226
+// recommends at least 160 bits.
+const SecretSize = 20
+
+// Config holds the parameters used to generate and validate codes.
+//
+// Fields:
+//   - Algorithm: The HMAC hash function
+//   - Digits: The number of digits in a code (6 or 8)
+//   - Period: How long each code is valid for
+//   - Skew: The number of periods either side of the current one which are
+//     also accepted, to allow for clock drift and slow typists
+type Config struct {
+	Algorithm Algorithm
+	Digits    int
+	Period    time.Duration
+	Skew      int
+}
+
+// Default is the configuration understood by common authenticator apps.
+var Default = Config{
+	Algorithm: SHA1,
+	Digits:    6,
+	Period:    30 * time.Second,
+	Skew:      1,
+}
+
+// NewSecret returns a new random secret of SecretSize bytes.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret returns the unpadded base32 form of secret, which is how it is
+// shown to users who can't scan a QR code.
+func EncodeSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// Step returns the time step (the counter value in RFC 4226 terms) that t
+// falls into.
+func (c Config) Step(t time.Time) int64 {
+	return t.Unix() / int64(c.Period/time.Second)
+}
+
+// Code returns the code for secret at time t.
+func (c Config) Code(secret []byte, t time.Time) string {
+	return c.codeAt(secret, c.Step(t))
+}
+
+// Validate checks whether code is valid for secret at time t, allowing for
+// c.Skew periods of drift either way.
+//
+// Returns:
+//   - int64: The time step the code matched, which callers should store and
+//     pass as minStep on the next call so that a code can't be replayed
+//   - bool: Whether the code was valid
+//
+// Only codes for steps strictly greater than minStep are accepted.
+func (c Config) Validate(secret []byte, code string, t time.Time, minStep int64) (int64, bool) {
+	if len(code) != c.Digits {
+		return 0, false
+	}
+
+	current := c.Step(t)
+
+	for i := -c.Skew; i <= c.Skew; i++ {
+		step := current + int64(i)
+		if step <= minStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(c.codeAt(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns an otpauth:// provisioning URI which authenticator apps can
+// import (usually by scanning it as a QR code).
+//
+// Parameters:
+//   - issuer: The name of the service, e.g. "Snippetbox"
+//   - account: The user's account name, usually their email address
+//   - secret: The shared secret
+func (c Config) URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", EncodeSecret(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", string(c.Algorithm))
+	v.Set("digits", strconv.Itoa(c.Digits))
+	v.Set("period", strconv.Itoa(int(c.Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// codeAt implements the HOTP algorithm from RFC 4226 section 5.3 for the
+// given counter value.
+func (c Config) codeAt(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(c.hash(), secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte select a 4-byte
+	// window, of which we take the low 31 bits.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < c.Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", c.Digits, value%mod)
+}
+
+// hash returns the constructor for the configured hash function.
+func (c Config) hash() func() hash.Hash {
+	switch c.Algorithm {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+// TestCodeRFC6238 checks Code against the test vectors in RFC 6238
+// Appendix B. The seeds are the ASCII string "1234567890" repeated to the
+// length of each hash function's output.
+func TestCodeRFC6238(t *testing.T) {
+	seeds := map[Algorithm][]byte{
+		SHA1:   []byte(strings.Repeat("1234567890", 2)),
+		SHA256: []byte(strings.Repeat("1234567890", 4)[:32]),
+		SHA512: []byte(strings.Repeat("1234567890", 7)[:64]),
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm Algorithm
+		want      string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{1111111111, SHA1, "14050471"},
+		{1111111111, SHA256, "67062674"},
+		{1111111111, SHA512, "99943326"},
+		{1234567890, SHA1, "89005924"},
+		{1234567890, SHA256, "91819424"},
+		{1234567890, SHA512, "93441116"},
+		{2000000000, SHA1, "69279037"},
+		{2000000000, SHA256, "90698825"},
+		{2000000000, SHA512, "38618901"},
+		{20000000000, SHA1, "65353130"},
+		{20000000000, SHA256, "77737706"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.algorithm)+"/"+time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			c := Config{Algorithm: tt.algorithm, Digits: 8, Period: 30 * time.Second}
+
+			assert.Equal(t, c.Code(seeds[tt.algorithm], time.Unix(tt.unix, 0)), tt.want)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte(strings.Repeat("1234567890", 2))
+	now := time.Unix(1111111111, 0)
+	step := Default.Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		minStep  int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{
+			name:     "Current period",
+			code:     Default.Code(secret, now),
+			wantStep: step,
+			wantOK:   true,
+		},
+		{
+			name:     "Previous period",
+			code:     Default.Code(secret, now.Add(-30*time.Second)),
+			wantStep: step - 1,
+			wantOK:   true,
+		},
+		{
+			name:     "Next period",
+			code:     Default.Code(secret, now.Add(30*time.Second)),
+			wantStep: step + 1,
+			wantOK:   true,
+		},
+		{
+			name: "Outside skew",
+			code: Default.Code(secret, now.Add(-90*time.Second)),
+		},
+		{
+			name:    "Replayed",
+			code:    Default.Code(secret, now),
+			minStep: step,
+		},
+		{
+			name: "Wrong length",
+			code: "12345",
+		},
+		{
+			name: "Empty",
+			code: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Default.Validate(secret, tt.code, now, tt.minStep)
+
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, gotStep, tt.wantStep)
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret := []byte(strings.Repeat("1234567890", 2))
+
+	got := Default.URI("Snippetbox", "alice@example.com", secret)
+
+	want := "otpauth://totp/Snippetbox:alice@example.com?algorithm=SHA1&digits=6&issuer=Snippetbox&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	assert.Equal(t, got, want)
+}
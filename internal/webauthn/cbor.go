@@ -0,0 +1,144 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errCBOR is returned when CBOR data is malformed or uses a feature the
+// decoder doesn't support.
+var errCBOR = errors.New("webauthn: invalid CBOR")
+
+// maxCBORDepth limits nesting, so that hostile input can't exhaust the stack.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR data item in b and returns it along with
+// the bytes which follow it.
+//
+// Only the subset of CBOR used by WebAuthn authenticators is supported:
+// definite-length integers, byte and text strings, arrays and maps, and the
+// simple values false, true and null. Values are decoded as:
+//   - Integers: int64
+//   - Byte strings: []byte
+//   - Text strings: string
+//   - Arrays: []any
+//   - Maps: map[any]any, with int64 or string keys
+//   - Simple values: bool or nil
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	// Major type 7 holds simple values, where the additional information is
+	// the value itself rather than an argument.
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+		}
+	}
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24 && len(b) >= 1:
+		arg, b = uint64(b[0]), b[1:]
+	case info == 25 && len(b) >= 2:
+		arg, b = uint64(binary.BigEndian.Uint16(b)), b[2:]
+	case info == 26 && len(b) >= 4:
+		arg, b = uint64(binary.BigEndian.Uint32(b)), b[4:]
+	case info == 27 && len(b) >= 8:
+		arg, b = binary.BigEndian.Uint64(b), b[8:]
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported or truncated argument", errCBOR)
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), b, nil
+
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), b, nil
+
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: string too long", errCBOR)
+		}
+		s, rest := b[:arg], b[arg:]
+		if major == 3 {
+			return string(s), rest, nil
+		}
+		return append([]byte(nil), s...), rest, nil
+
+	case 4:
+		// Each item takes at least one byte, which bounds the allocation.
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: array too long", errCBOR)
+		}
+		items := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item any
+			var err error
+			item, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, b, nil
+
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: map too long", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value any
+			var err error
+			key, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type", errCBOR)
+			}
+			value, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, b, nil
+
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+	}
+}
@@ -0,0 +1,81 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 8949 Appendix A.
+	tests := []struct {
+		name string
+		hex  string
+		want any
+	}{
+		{"Zero", "00", int64(0)},
+		{"Small integer", "17", int64(23)},
+		{"One byte integer", "1818", int64(24)},
+		{"Two byte integer", "1903e8", int64(1000)},
+		{"Four byte integer", "1a000f4240", int64(1000000)},
+		{"Negative integer", "20", int64(-1)},
+		{"Negative two byte integer", "3903e7", int64(-1000)},
+		{"False", "f4", false},
+		{"True", "f5", true},
+		{"Null", "f6", nil},
+		{"Byte string", "4401020304", []byte{1, 2, 3, 4}},
+		{"Text string", "6449455446", "IETF"},
+		{"Array", "83010203", []any{int64(1), int64(2), int64(3)}},
+		{"Map", "a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)}},
+		{"Nested", "a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, rest, err := decodeCBOR(b)
+			assert.NilError(t, err)
+			assert.Equal(t, len(rest), 0)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v; want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"Empty", ""},
+		{"Truncated argument", "19"},
+		{"Truncated string", "4401"},
+		{"Truncated array", "8301"},
+		{"Indefinite length", "9f01ff"},
+		{"Float", "f93c00"},
+		{"Tag", "c074"},
+		{"Array longer than input", "9b00000000ffffffff"},
+		{"Unsupported map key", "a1f401"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = decodeCBOR(b)
+			assert.Equal(t, errors.Is(err, errCBOR), true)
+		})
+	}
+}
@@ -0,0 +1,511 @@
+// Package webauthn implements the server side of the WebAuthn registration
+// and authentication ceremonies, so that users can log in with passkeys.
+//
+// Only what the application needs is implemented:
+//   - Attestation isn't verified. Registration requests "none" attestation,
+//     as we don't restrict which authenticators can be used
+//   - Public keys must be ES256 (ECDSA P-256) or RS256 (RSA PKCS#1 v1.5),
+//     which between them cover all common authenticators
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrInvalidResponse is returned when a response from the authenticator
+	// is malformed, doesn't match the challenge or relying party, or has a
+	// bad signature.
+	ErrInvalidResponse = errors.New("webauthn: invalid response")
+
+	// ErrSignCount is returned when an assertion's signature counter hasn't
+	// increased since the credential was last used, which suggests the
+	// authenticator has been cloned.
+	ErrSignCount = errors.New("webauthn: signature counter did not increase")
+)
+
+// COSE algorithm identifiers for the supported public key types.
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+)
+
+// Flags in the authenticator data.
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagAttestedData  = 0x40
+	flagExtensionData = 0x80
+)
+
+// challengeSize is the length of random challenges, in bytes.
+const challengeSize = 32
+
+// timeout is how long the browser should wait for the user, in milliseconds.
+const timeout = 5 * 60 * 1000
+
+// Bytes is a byte slice which is encoded in JSON as unpadded base64url, as
+// used by the WebAuthn JavaScript API.
+type Bytes []byte
+
+// MarshalJSON encodes b as an unpadded base64url string.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes an unpadded base64url string. A JSON null leaves b
+// unchanged.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	*b, err = base64.RawURLEncoding.DecodeString(s)
+	return err
+}
+
+// RelyingParty describes the website that credentials are registered with.
+type RelyingParty struct {
+	ID     string // Domain that credentials are scoped to, e.g. "snippetbox.tomcat.net"
+	Name   string // Human-readable name, e.g. "Snippetbox"
+	Origin string // Origin pages are served from, e.g. "https://snippetbox.tomcat.net"
+}
+
+// Credential is a public key credential created by an authenticator.
+type Credential struct {
+	ID        []byte // Credential ID chosen by the authenticator
+	PublicKey []byte // Public key in PKIX (DER) form
+	SignCount uint32 // Signature counter at registration
+}
+
+// NewChallenge returns a new random challenge.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// CreationOptions is the JSON form of the options passed to
+// navigator.credentials.create(), after base64url fields are decoded.
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+	Timeout                int                    `json:"timeout"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions returns the options for registering a new passkey.
+//
+// Parameters:
+//   - challenge: From NewChallenge, to be passed to VerifyRegistration
+//   - userHandle: Opaque identifier for the user, returned when they log in
+//   - name: The user's account name, e.g. their email address
+//   - displayName: The user's name
+//   - exclude: IDs of the user's existing credentials, so the same
+//     authenticator isn't registered twice
+func (rp *RelyingParty) CreationOptions(challenge, userHandle []byte, name, displayName string, exclude [][]byte) CreationOptions {
+	excludeCredentials := []credentialDescriptor{}
+	for _, id := range exclude {
+		excludeCredentials = append(excludeCredentials, credentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User:      userEntity{ID: userHandle, Name: name, DisplayName: displayName},
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		ExcludeCredentials: excludeCredentials,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+		Timeout:     timeout,
+	}
+}
+
+// RequestOptions is the JSON form of the options passed to
+// navigator.credentials.get(), after base64url fields are decoded.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+	Timeout          int                    `json:"timeout"`
+}
+
+// RequestOptions returns the options for logging in with a passkey. No
+// credentials are listed, so the user can pick any passkey they have for the
+// site without entering their email address first.
+func (rp *RelyingParty) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		AllowCredentials: []credentialDescriptor{},
+		UserVerification: "preferred",
+		Timeout:          timeout,
+	}
+}
+
+// AttestationResponse is the JSON form of the credential returned by
+// navigator.credentials.create(), as posted back by the browser.
+type AttestationResponse struct {
+	ID                Bytes `json:"id"`
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// AssertionResponse is the JSON form of the credential returned by
+// navigator.credentials.get(), as posted back by the browser.
+type AssertionResponse struct {
+	ID                Bytes `json:"id"`
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle"`
+}
+
+// clientData holds the fields we check from the client data JSON, which the
+// browser creates and the authenticator signs (via its hash).
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the parsed form of the authenticator data structure.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte // PKIX (DER) form, only set during registration
+}
+
+// VerifyRegistration checks the response from navigator.credentials.create()
+// and returns the new credential.
+//
+// Parameters:
+//   - challenge: The challenge passed to CreationOptions
+//   - clientDataJSON: response.clientDataJSON
+//   - attestationObject: response.attestationObject
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	obj, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	m, ok := obj.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object is not a map", ErrInvalidResponse)
+	}
+
+	rawAuthData, ok := m["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authenticator data", ErrInvalidResponse)
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if authData.flags&flagAttestedData == 0 || authData.publicKey == nil {
+		return Credential{}, fmt.Errorf("%w: no credential in authenticator data", ErrInvalidResponse)
+	}
+
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response from navigator.credentials.get()
+// against a stored credential.
+//
+// Parameters:
+//   - challenge: The challenge passed to RequestOptions
+//   - credential: The stored credential matching the response's ID, with
+//     the signature counter from its last use
+//   - clientDataJSON, authenticatorData, signature: From the response
+//
+// Returns:
+//   - uint32: The new signature counter, which must be stored
+//   - bool: Whether the authenticator verified the user (with a PIN or
+//     biometric), rather than just checking they were present
+//   - error: ErrInvalidResponse or ErrSignCount if verification failed
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential Credential, clientDataJSON, authenticatorData, signature []byte) (uint32, bool, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, false, err
+	}
+
+	authData, err := rp.parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, false, err
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(bytes.Clone(authenticatorData), clientDataHash[:]...))
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return 0, false, fmt.Errorf("%w: bad signature", ErrInvalidResponse)
+		}
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: bad signature", ErrInvalidResponse)
+		}
+	default:
+		return 0, false, fmt.Errorf("%w: unsupported public key type", ErrInvalidResponse)
+	}
+
+	// Authenticators which don't implement a counter (including most synced
+	// passkeys) always return zero. Otherwise the counter must increase.
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, false, ErrSignCount
+	}
+
+	return authData.signCount, authData.flags&flagUserVerified != 0, nil
+}
+
+// checkClientData checks the type, challenge and origin in the client data.
+func (rp *RelyingParty) checkClientData(clientDataJSON []byte, wantType string, challenge []byte) error {
+	var cd clientData
+
+	err := json.Unmarshal(clientDataJSON, &cd)
+	if err != nil {
+		return fmt.Errorf("%w: client data: %v", ErrInvalidResponse, err)
+	}
+
+	switch {
+	case cd.Type != wantType:
+		return fmt.Errorf("%w: wrong client data type %q", ErrInvalidResponse, cd.Type)
+	case cd.Challenge != base64.RawURLEncoding.EncodeToString(challenge):
+		return fmt.Errorf("%w: wrong challenge", ErrInvalidResponse)
+	case cd.Origin != rp.Origin:
+		return fmt.Errorf("%w: wrong origin %q", ErrInvalidResponse, cd.Origin)
+	case cd.CrossOrigin:
+		return fmt.Errorf("%w: cross-origin request", ErrInvalidResponse)
+	}
+
+	return nil
+}
+
+// parseAuthenticatorData parses the authenticator data and checks that it's
+// for this relying party and that the user was present.
+func (rp *RelyingParty) parseAuthenticatorData(b []byte) (authenticatorData, error) {
+	var ad authenticatorData
+
+	if len(b) < 37 {
+		return ad, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+
+	ad.rpIDHash = b[:32]
+	ad.flags = b[32]
+	ad.signCount = binary.BigEndian.Uint32(b[33:37])
+	rest := b[37:]
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return ad, fmt.Errorf("%w: wrong relying party", ErrInvalidResponse)
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return ad, fmt.Errorf("%w: user not present", ErrInvalidResponse)
+	}
+
+	if ad.flags&flagAttestedData != 0 {
+		// The attested credential data is a 16-byte AAGUID, a 2-byte length,
+		// the credential ID and the credential public key in COSE form.
+		if len(rest) < 18 {
+			return ad, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+		}
+
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return ad, fmt.Errorf("%w: credential ID truncated", ErrInvalidResponse)
+		}
+		ad.credentialID = bytes.Clone(rest[:n])
+		rest = rest[n:]
+
+		var coseKey any
+		var err error
+		coseKey, rest, err = decodeCBOR(rest)
+		if err != nil {
+			return ad, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+		}
+
+		ad.publicKey, err = parseCOSEKey(coseKey)
+		if err != nil {
+			return ad, err
+		}
+	}
+
+	if ad.flags&flagExtensionData != 0 {
+		var err error
+		_, rest, err = decodeCBOR(rest)
+		if err != nil {
+			return ad, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+		}
+	}
+
+	if len(rest) != 0 {
+		return ad, fmt.Errorf("%w: trailing bytes in authenticator data", ErrInvalidResponse)
+	}
+
+	return ad, nil
+}
+
+// COSE key parameters (RFC 9053).
+const (
+	coseKeyType = 1
+	coseAlg     = 3
+	coseCurve   = -1 // For EC2 keys
+	coseX       = -2 // For EC2 keys
+	coseY       = -3 // For EC2 keys
+	coseN       = -1 // For RSA keys
+	coseE       = -2 // For RSA keys
+
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+	coseCurveP256  = 1
+)
+
+// oidPublicKeyECDSA and oidNamedCurveP256 identify P-256 keys in PKIX form.
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+)
+
+// parseCOSEKey converts a credential public key from COSE form to PKIX
+// (DER) form, which is what's stored.
+func parseCOSEKey(v any) ([]byte, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a map", ErrInvalidResponse)
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid EC2 public key", ErrInvalidResponse)
+		}
+
+		// Build the PKIX form directly and let x509 parse it, which checks
+		// that the point is on the curve.
+		point := append(append([]byte{4}, x...), y...)
+		params, _ := asn1.Marshal(oidNamedCurveP256)
+
+		der, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+			PublicKey: asn1.BitString{Bytes: point, BitLength: len(point) * 8},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid EC2 public key: %v", ErrInvalidResponse, err)
+		}
+
+		return der, nil
+
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA public key", ErrInvalidResponse)
+		}
+
+		return x509.MarshalPKIXPublicKey(&rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		})
+
+	default:
+		return nil, fmt.Errorf("%w: unsupported public key type %d/%d", ErrInvalidResponse, kty, alg)
+	}
+}
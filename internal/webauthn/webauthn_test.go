@@ -0,0 +1,181 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"snippetbox.tomcat.net/internal/assert"
+	"snippetbox.tomcat.net/internal/webauthn"
+	"snippetbox.tomcat.net/internal/webauthn/webauthntest"
+)
+
+var rp = &webauthn.RelyingParty{
+	ID:     "snippetbox.example.com",
+	Name:   "Snippetbox",
+	Origin: "https://snippetbox.example.com",
+}
+
+// register creates a credential on the authenticator and verifies it.
+func register(t *testing.T, a *webauthntest.Authenticator) webauthn.Credential {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.Create(rp.CreationOptions(challenge, []byte{1}, "alice@example.com", "Alice", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := rp.VerifyRegistration(challenge, resp.ClientDataJSON, resp.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cred
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	tests := []struct {
+		name          string
+		origin        string
+		rpID          string
+		swapChallenge bool
+		wantErr       error
+	}{
+		{
+			name:   "Valid",
+			origin: rp.Origin,
+			rpID:   rp.ID,
+		},
+		{
+			name:    "Wrong origin",
+			origin:  "https://evil.example.com",
+			rpID:    rp.ID,
+			wantErr: webauthn.ErrInvalidResponse,
+		},
+		{
+			name:    "Wrong relying party",
+			origin:  rp.Origin,
+			rpID:    "evil.example.com",
+			wantErr: webauthn.ErrInvalidResponse,
+		},
+		{
+			name:          "Wrong challenge",
+			origin:        rp.Origin,
+			rpID:          rp.ID,
+			swapChallenge: true,
+			wantErr:       webauthn.ErrInvalidResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := webauthntest.New(tt.origin)
+
+			challenge, err := webauthn.NewChallenge()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			options := rp.CreationOptions(challenge, []byte{1}, "alice@example.com", "Alice", nil)
+			options.RP.ID = tt.rpID
+
+			resp, err := a.Create(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.swapChallenge {
+				challenge, _ = webauthn.NewChallenge()
+			}
+
+			cred, err := rp.VerifyRegistration(challenge, resp.ClientDataJSON, resp.AttestationObject)
+			if tt.wantErr != nil {
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, string(cred.ID), string(resp.ID))
+			assert.Equal(t, cred.SignCount, uint32(1))
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a := webauthntest.New(rp.Origin)
+	cred := register(t, a)
+
+	// assertion performs an authentication ceremony and verifies it against
+	// the stored credential.
+	assertion := func(a *webauthntest.Authenticator, tamper func(*webauthn.AssertionResponse)) (uint32, bool, error) {
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := a.Get(rp.RequestOptions(challenge))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tamper != nil {
+			tamper(&resp)
+		}
+
+		return rp.VerifyAssertion(challenge, cred, resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		signCount, verified, err := assertion(a, nil)
+		assert.NilError(t, err)
+		assert.Equal(t, signCount, uint32(2))
+		assert.Equal(t, verified, true)
+
+		cred.SignCount = signCount
+	})
+
+	t.Run("User not verified", func(t *testing.T) {
+		a.UserVerified = false
+		defer func() { a.UserVerified = true }()
+
+		signCount, verified, err := assertion(a, nil)
+		assert.NilError(t, err)
+		assert.Equal(t, verified, false)
+
+		cred.SignCount = signCount
+	})
+
+	t.Run("Tampered client data", func(t *testing.T) {
+		_, _, err := assertion(a, func(resp *webauthn.AssertionResponse) {
+			var cd map[string]any
+			json.Unmarshal(resp.ClientDataJSON, &cd)
+			cd["extra"] = "tampered"
+			resp.ClientDataJSON, _ = json.Marshal(cd)
+		})
+		assert.Equal(t, errors.Is(err, webauthn.ErrInvalidResponse), true)
+	})
+
+	t.Run("Bad signature", func(t *testing.T) {
+		_, _, err := assertion(a, func(resp *webauthn.AssertionResponse) {
+			resp.Signature[len(resp.Signature)-1] ^= 1
+		})
+		assert.Equal(t, errors.Is(err, webauthn.ErrInvalidResponse), true)
+	})
+
+	t.Run("Cloned authenticator", func(t *testing.T) {
+		clone := a.Clone()
+
+		signCount, _, err := assertion(a, nil)
+		assert.NilError(t, err)
+		cred.SignCount = signCount
+
+		// The clone's counter is now behind the stored one.
+		_, _, err = assertion(clone, nil)
+		assert.Equal(t, errors.Is(err, webauthn.ErrSignCount), true)
+	})
+}
@@ -0,0 +1,240 @@
+// Package webauthntest provides a software WebAuthn authenticator for tests.
+// It plays the part of both the browser and the authenticator, turning the
+// options the server sends into the responses the browser would post back.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"snippetbox.tomcat.net/internal/webauthn"
+)
+
+// ErrNoCredential is returned by Get when the authenticator has no
+// credential for the relying party, and by Create when it already has one of
+// the excluded credentials.
+var ErrNoCredential = errors.New("webauthntest: no suitable credential")
+
+// credential is a key pair held by the authenticator.
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// Authenticator is a software authenticator which creates ES256 credentials.
+type Authenticator struct {
+	// Origin is the origin the "browser" reports in the client data.
+	Origin string
+
+	// UserVerified sets whether the authenticator reports that it verified
+	// the user (for example with a PIN), rather than only that they were
+	// present.
+	UserVerified bool
+
+	credentials []*credential
+}
+
+// New returns an authenticator for pages served from origin, which verifies
+// users.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true}
+}
+
+// Clone returns a copy of the authenticator holding the same keys and
+// signature counters, as if its keys had been extracted.
+func (a *Authenticator) Clone() *Authenticator {
+	clone := &Authenticator{Origin: a.Origin, UserVerified: a.UserVerified}
+	for _, c := range a.credentials {
+		copied := *c
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return clone
+}
+
+// Create performs the registration ceremony, like
+// navigator.credentials.create().
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.AttestationResponse, error) {
+	for _, c := range a.credentials {
+		for _, excluded := range options.ExcludeCredentials {
+			if slices.Equal(c.id, excluded.ID) {
+				return webauthn.AttestationResponse{}, ErrNoCredential
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return webauthn.AttestationResponse{}, err
+	}
+
+	c := &credential{
+		id:         randomBytes(16),
+		key:        key,
+		rpID:       options.RP.ID,
+		userHandle: options.User.ID,
+	}
+	a.credentials = append(a.credentials, c)
+
+	clientDataJSON := a.clientData("webauthn.create", options.Challenge)
+
+	// Attested credential data: AAGUID (all zeros), credential ID length,
+	// credential ID and the public key in COSE form.
+	attested := make([]byte, 16, 16+2+len(c.id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(c.id)))
+	attested = append(attested, c.id...)
+	attested = append(attested, coseKey(&key.PublicKey)...)
+
+	authData := a.authenticatorData(c, 0x40, attested)
+
+	attestationObject := encodeMap(
+		encodeText("fmt"), encodeText("none"),
+		encodeText("attStmt"), encodeMap(),
+		encodeText("authData"), encodeBytes(authData),
+	)
+
+	return webauthn.AttestationResponse{
+		ID:                c.id,
+		ClientDataJSON:    clientDataJSON,
+		AttestationObject: attestationObject,
+	}, nil
+}
+
+// Get performs the authentication ceremony, like navigator.credentials.get(),
+// using the first credential the options allow.
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var c *credential
+	for _, candidate := range a.credentials {
+		if candidate.rpID != options.RPID {
+			continue
+		}
+
+		allowed := len(options.AllowCredentials) == 0
+		for _, d := range options.AllowCredentials {
+			allowed = allowed || slices.Equal(d.ID, candidate.id)
+		}
+
+		if allowed {
+			c = candidate
+			break
+		}
+	}
+
+	if c == nil {
+		return webauthn.AssertionResponse{}, ErrNoCredential
+	}
+
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	authData := a.authenticatorData(c, 0, nil)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	return webauthn.AssertionResponse{
+		ID:                c.id,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         signature,
+		UserHandle:        c.userHandle,
+	}, nil
+}
+
+// clientData returns the client data JSON the browser would create.
+func (a *Authenticator) clientData(typ string, challenge []byte) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return b
+}
+
+// authenticatorData increments the credential's counter and returns the
+// authenticator data for it.
+func (a *Authenticator) authenticatorData(c *credential, flags byte, attested []byte) []byte {
+	c.signCount++
+
+	flags |= 0x01 // User present
+	if a.UserVerified {
+		flags |= 0x04
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, c.signCount)
+	return append(data, attested...)
+}
+
+// coseKey encodes an ES256 public key in COSE form.
+func coseKey(key *ecdsa.PublicKey) []byte {
+	point, _ := key.ECDH()
+	xy := point.Bytes()[1:] // Uncompressed point, without the 0x04 prefix
+
+	return encodeMap(
+		encodeInt(1), encodeInt(2), // kty: EC2
+		encodeInt(3), encodeInt(-7), // alg: ES256
+		encodeInt(-1), encodeInt(1), // crv: P-256
+		encodeInt(-2), encodeBytes(xy[:32]),
+		encodeInt(-3), encodeBytes(xy[32:]),
+	)
+}
+
+// encodeHead encodes a CBOR major type and argument.
+func encodeHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func encodeInt(n int) []byte {
+	if n < 0 {
+		return encodeHead(1, uint64(-1-n))
+	}
+	return encodeHead(0, uint64(n))
+}
+
+func encodeBytes(b []byte) []byte {
+	return append(encodeHead(2, uint64(len(b))), b...)
+}
+
+func encodeText(s string) []byte {
+	return append(encodeHead(3, uint64(len(s))), s...)
+}
+
+// encodeMap encodes a map from alternating, already encoded, keys and values.
+func encodeMap(keysAndValues ...[]byte) []byte {
+	b := encodeHead(5, uint64(len(keysAndValues)/2))
+	for _, kv := range keysAndValues {
+		b = append(b, kv...)
+	}
+	return b
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,6 @@
+-- Email address verification. Accounts created before addresses were
+-- verified are treated as verified, rather than losing the ability to create
+-- snippets.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET email_verified = TRUE;
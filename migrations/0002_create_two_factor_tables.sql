@@ -0,0 +1,18 @@
+-- TOTP two-factor authentication: each user's encrypted secret, and their
+-- hashed recovery codes.
+CREATE TABLE user_totp (
+    user_id INTEGER NOT NULL PRIMARY KEY,
+    encrypted_secret VARBINARY(255) NOT NULL,
+    confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE user_recovery_codes (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used DATETIME NULL
+);
+
+CREATE INDEX idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
@@ -0,0 +1,13 @@
+-- Metadata about logged-in sessions, for the active sessions page.
+CREATE TABLE user_sessions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    token CHAR(43) NOT NULL,
+    user_id INTEGER NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL
+);
+
+ALTER TABLE user_sessions ADD CONSTRAINT user_sessions_uc_token UNIQUE (token);
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
@@ -0,0 +1,11 @@
+-- Accounts at OpenID Connect identity providers linked to users.
+CREATE TABLE user_identities (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+ALTER TABLE user_identities ADD CONSTRAINT user_identities_uc_issuer_subject UNIQUE (issuer, subject);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,14 @@
+-- Users' passkeys.
+CREATE TABLE webauthn_credentials (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    credential_id VARBINARY(255) NOT NULL,
+    public_key BLOB NOT NULL,
+    sign_count INT UNSIGNED NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL
+);
+
+ALTER TABLE webauthn_credentials ADD CONSTRAINT webauthn_credentials_uc_credential_id UNIQUE (credential_id);
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
@@ -0,0 +1,3 @@
+-- User roles. Existing users get the "user" role; the first administrator
+-- has to be appointed in the database (see "Roles" in the README).
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
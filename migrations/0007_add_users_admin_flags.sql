@@ -0,0 +1,5 @@
+-- Flags set by administrators: disabled accounts, and accounts which must
+-- change their password before doing anything else.
+ALTER TABLE users
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
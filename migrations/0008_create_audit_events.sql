@@ -0,0 +1,19 @@
+-- The audit log of security-relevant events.
+CREATE TABLE audit_events (
+    id BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    created DATETIME NOT NULL,
+    actor_id INTEGER NULL,
+    action VARCHAR(50) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL
+);
+
+CREATE INDEX idx_audit_events_actor_id ON audit_events(actor_id);
+
+-- The audit log is append-only.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
@@ -0,0 +1,21 @@
+-- Snippet authors, abuse reports, and the moderation state of snippets.
+-- Snippets created before authors were recorded have no author.
+ALTER TABLE snippets
+    ADD COLUMN user_id INTEGER NULL AFTER id,
+    ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN taken_down DATETIME NULL,
+    ADD COLUMN takedown_reason VARCHAR(500) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_snippets_user_id ON snippets(user_id);
+
+CREATE TABLE snippet_reports (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    reporter_id INTEGER NOT NULL,
+    reason VARCHAR(500) NOT NULL,
+    created DATETIME NOT NULL,
+    resolved DATETIME NULL,
+    resolution VARCHAR(20) NOT NULL DEFAULT ''
+);
+
+ALTER TABLE snippet_reports ADD CONSTRAINT snippet_reports_uc_snippet_reporter UNIQUE (snippet_id, reporter_id);
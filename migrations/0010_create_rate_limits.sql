@@ -0,0 +1,8 @@
+-- Token buckets for the MySQL rate limit store.
+CREATE TABLE rate_limits (
+    bucket_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    tokens DOUBLE NOT NULL,
+    updated DATETIME(6) NOT NULL
+);
+
+CREATE INDEX idx_rate_limits_updated ON rate_limits(updated);
@@ -0,0 +1,6 @@
+-- The MySQL cache for ACME certificates.
+CREATE TABLE acme_cache (
+    cache_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    data MEDIUMBLOB NOT NULL,
+    updated DATETIME NOT NULL
+);
@@ -0,0 +1,12 @@
+-- Users could only ever report a snippet once, so once a moderator had
+-- dismissed a user's report, that user could never report the snippet again,
+-- for example with a better reason or after a mistaken dismissal. A dismissed
+-- report shouldn't block a new one, so only one open report per user and
+-- snippet is enforced now: open_reporter_id is NULL once a report is
+-- resolved, and the unique key allows any number of NULLs.
+ALTER TABLE snippet_reports DROP INDEX snippet_reports_uc_snippet_reporter;
+
+ALTER TABLE snippet_reports
+    ADD COLUMN open_reporter_id INTEGER AS (IF(resolved IS NULL, reporter_id, NULL)) VIRTUAL;
+
+ALTER TABLE snippet_reports ADD CONSTRAINT snippet_reports_uc_snippet_open_reporter UNIQUE (snippet_id, open_reporter_id);
@@ -0,0 +1,116 @@
+// Registration and login with passkeys (WebAuthn). The server sends options
+// with binary fields encoded as base64url strings, and expects the browser's
+// response encoded the same way. Whatever the outcome, the server responds
+// with a redirect, which is followed to show the result.
+
+function base64urlToBuffer(s) {
+	var base64 = s.replace(/-/g, "+").replace(/_/g, "/");
+	var binary = atob(base64);
+	var bytes = new Uint8Array(binary.length);
+	for (var i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+	if (!buffer) {
+		return null;
+	}
+	var bytes = new Uint8Array(buffer);
+	var binary = "";
+	for (var i = 0; i < bytes.length; i++) {
+		binary += String.fromCharCode(bytes[i]);
+	}
+	return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function decodeDescriptors(descriptors) {
+	return (descriptors || []).map(function (d) {
+		return { type: d.type, id: base64urlToBuffer(d.id) };
+	});
+}
+
+function postJSON(form, url, body) {
+	return fetch(url, {
+		method: "POST",
+		credentials: "same-origin",
+		headers: {
+			"Content-Type": "application/json",
+			"X-CSRF-Token": form.elements["csrf_token"].value
+		},
+		body: JSON.stringify(body || {})
+	}).then(function (response) {
+		if (!response.ok) {
+			throw new Error("Request failed with status " + response.status);
+		}
+		return response;
+	});
+}
+
+function passkeyError(err) {
+	// The user cancelling the browser's dialog isn't worth reporting.
+	if (err.name !== "NotAllowedError") {
+		alert("Something went wrong with your passkey: " + err.message);
+	}
+}
+
+var registerForm = document.getElementById("passkey-register");
+if (registerForm && window.PublicKeyCredential) {
+	registerForm.addEventListener("submit", function (event) {
+		event.preventDefault();
+
+		postJSON(registerForm, "/account/passkeys/register/begin")
+			.then(function (response) { return response.json(); })
+			.then(function (options) {
+				var publicKey = options.publicKey;
+				publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+				publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+				publicKey.excludeCredentials = decodeDescriptors(publicKey.excludeCredentials);
+				return navigator.credentials.create({ publicKey: publicKey });
+			})
+			.then(function (credential) {
+				return postJSON(registerForm, "/account/passkeys/register/finish", {
+					name: registerForm.elements["name"].value,
+					credential: {
+						id: bufferToBase64url(credential.rawId),
+						clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+						attestationObject: bufferToBase64url(credential.response.attestationObject)
+					}
+				});
+			})
+			.then(function (response) { window.location = response.url; })
+			.catch(passkeyError);
+	});
+} else if (registerForm) {
+	registerForm.hidden = true;
+}
+
+var loginForm = document.getElementById("passkey-login");
+if (loginForm && window.PublicKeyCredential) {
+	loginForm.addEventListener("submit", function (event) {
+		event.preventDefault();
+
+		postJSON(loginForm, "/user/login/passkey/begin")
+			.then(function (response) { return response.json(); })
+			.then(function (options) {
+				var publicKey = options.publicKey;
+				publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+				publicKey.allowCredentials = decodeDescriptors(publicKey.allowCredentials);
+				return navigator.credentials.get({ publicKey: publicKey });
+			})
+			.then(function (credential) {
+				return postJSON(loginForm, "/user/login/passkey/finish", {
+					id: bufferToBase64url(credential.rawId),
+					clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+					authenticatorData: bufferToBase64url(credential.response.authenticatorData),
+					signature: bufferToBase64url(credential.response.signature),
+					userHandle: bufferToBase64url(credential.response.userHandle)
+				});
+			})
+			.then(function (response) { window.location = response.url; })
+			.catch(passkeyError);
+	});
+} else if (loginForm) {
+	loginForm.hidden = true;
+}